@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Ancestors returns the parent directories of path, nearest first.
+func Ancestors(path string) []string {
+	var ancestors []string
+	path = filepath.Clean(path)
+	for {
+		parent := filepath.Dir(path)
+		if parent == path {
+			return ancestors
+		}
+		ancestors = append(ancestors, parent)
+		path = parent
+	}
+}
+
+// FindAncestor returns the nearest ancestor of path named name, as bd does,
+// or else the nearest whose name starts with name, then the nearest whose
+// name contains it. Failing that, a single ancestor whose name fuzzily
+// matches name is returned, and an error listing the candidates when
+// several do.
+func FindAncestor(path string, name string) (string, error) {
+	ancestors := Ancestors(path)
+	for _, ancestor := range ancestors {
+		if filepath.Base(ancestor) == name {
+			return ancestor, nil
+		}
+	}
+	lowerName := strings.ToLower(name)
+	for _, ancestor := range ancestors {
+		if strings.HasPrefix(strings.ToLower(filepath.Base(ancestor)), lowerName) {
+			return ancestor, nil
+		}
+	}
+	for _, ancestor := range ancestors {
+		if strings.Contains(strings.ToLower(filepath.Base(ancestor)), lowerName) {
+			return ancestor, nil
+		}
+	}
+	var candidates []string
+	for _, ancestor := range ancestors {
+		if isSubsequence(lowerName, strings.ToLower(filepath.Base(ancestor))) {
+			candidates = append(candidates, ancestor)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no parent directory matches %q", name)
+	case 1:
+		return candidates[0], nil
+	}
+	return "", fmt.Errorf("%q matches several parent directories:\n\t%v", name, strings.Join(candidates, "\n\t"))
+}
+
+// AncestorNames returns the names of the parent directories of path,
+// nearest first and without repeats, leaving out the root.
+func AncestorNames(path string) []string {
+	var names []string
+	seen := map[string]bool{}
+	ancestors := Ancestors(path)
+	for _, ancestor := range ancestors[:max(len(ancestors)-1, 0)] {
+		name := filepath.Base(ancestor)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// ErrUnavailable is wrapped by the errors of backends that cannot reach
+// their storage, such as a server that is down. The fallback chain treats
+// these errors as a reason to use the next backend instead of failing.
+var ErrUnavailable = errors.New("backend unavailable")
+
+// backendOpeners create the storage backends that can be named in the
+// backends setting.
+var backendOpeners = map[string]func(config *Config) (MarkDB, error){
+	"local":     openLocalBackend,
+	"memory":    openMemoryBackend,
+	"remote":    openRemoteBackend,
+	"redis":     openRedisBackend,
+	"s3":        openS3Backend,
+	"encrypted": openEncryptedBackend,
+}
+
+func openMemoryBackend(config *Config) (MarkDB, error) {
+	return markdb.NewMemoryMarkDB(), nil
+}
+
+func openRemoteBackend(config *Config) (MarkDB, error) {
+	if config.RemoteURL == "" {
+		return nil, errors.New("remote_url is not set")
+	}
+	token := config.RemoteToken
+	if token == "" {
+		token = os.Getenv("MARK_REMOTE_TOKEN")
+	}
+	return NewRemoteMarkDB(config.RemoteURL, token, config.Timeout)
+}
+
+func openRedisBackend(config *Config) (MarkDB, error) {
+	redisURL := config.RedisURL
+	if redisURL == "" {
+		redisURL = os.Getenv("MARK_REDIS_URL")
+	}
+	if redisURL == "" {
+		return nil, errors.New("redis_url is not set")
+	}
+	return NewRedisMarkDB(redisURL, config.RedisKey, config.Timeout)
+}
+
+func openS3Backend(config *Config) (MarkDB, error) {
+	return NewS3MarkDB(config.S3Endpoint, config.S3Region, config.S3Bucket, config.S3Key, config.Timeout)
+}
+
+func openEncryptedBackend(config *Config) (MarkDB, error) {
+	file := config.EncryptedDBFile
+	if file == "" {
+		var err error
+		if file, err = SidecarFile(config, ".enc"); err != nil {
+			return nil, err
+		}
+	}
+	return NewEncryptedMarkDB(file, config.EncryptionKey)
+}
+
+func openLocalBackend(config *Config) (MarkDB, error) {
+	dbFile := config.DBFile
+	if dbFile == "" {
+		var err error
+		if dbFile, err = GetLocalMarkFile(); err != nil {
+			return nil, err
+		}
+	}
+	return markdb.NewLocalMarkDB(dbFile)
+}
+
+// OpenConfiguredDB opens the backends of the backends setting, or only
+// the memory backend in ephemeral mode, publishing the marks added and
+// removed to the event log and the configured webhooks, and journaling
+// the changes for undo.
+func OpenConfiguredDB(config *Config) (MarkDB, error) {
+	var db MarkDB = markdb.NewMemoryMarkDB()
+	var err error
+	if !config.Ephemeral {
+		db, err = openBackends(config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if config.DryRun {
+		marks, err := db.List()
+		if err != nil {
+			return nil, err
+		}
+		memory := markdb.NewMemoryMarkDB()
+		return memory, memory.Replace(marks)
+	}
+	eventFile, err := SidecarFile(config, "_events")
+	if err != nil {
+		return nil, err
+	}
+	bus := &EventBus{}
+	bus.Subscribe(logEvent(NewEventLog(eventFile)))
+	for _, webhook := range ConfiguredWebhooks(config) {
+		bus.Subscribe(webhook.Send)
+	}
+	db = NewEventMarkDB(db, bus)
+	if config.Ephemeral {
+		return db, nil
+	}
+	undoDir, err := SidecarFile(config, "_undo")
+	if err != nil {
+		return nil, err
+	}
+	return NewUndoMarkDB(db, undoDir), nil
+}
+
+// logEvent returns an event subscriber appending to log. Failing to log
+// an event only warns, the change itself has been made.
+func logEvent(log *EventLog) func(event Event) {
+	return func(event Event) {
+		if err := log.Append(event); err != nil {
+			fmt.Fprintf(os.Stderr, "event log: %v\n", err)
+		}
+	}
+}
+
+// openBackends opens the backends of the backends setting. The local
+// backend on its own is used directly; otherwise the backends are combined
+// into a ChainMarkDB, so that writes to an unreachable backend are queued
+// rather than lost.
+func openBackends(config *Config) (MarkDB, error) {
+	names := config.Backends
+	if len(names) == 0 {
+		names = []string{"local"}
+	}
+	var backends []MarkDB
+	for _, name := range names {
+		open, ok := backendOpeners[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown backend %q, use one of %v", name, strings.Join(slices.Sorted(maps.Keys(backendOpeners)), ", "))
+		}
+		backend, err := open(config)
+		if err != nil {
+			return nil, fmt.Errorf("%v backend: %v", name, err)
+		}
+		backends = append(backends, backend)
+	}
+	if len(names) == 1 && names[0] == "local" {
+		return backends[0], nil
+	}
+	queueFile, err := SidecarFile(config, "_queue")
+	if err != nil {
+		return nil, err
+	}
+	return NewChainMarkDB(backends, NewWriteQueue(queueFile)), nil
+}
+
+// SidecarFile returns the location of a file stored alongside the local
+// db, such as ~/.mark_visits. In ephemeral mode it is the null device, so
+// that nothing is written.
+func SidecarFile(config *Config, suffix string) (string, error) {
+	if config.Ephemeral {
+		return os.DevNull, nil
+	}
+	if config.DBFile != "" {
+		return config.DBFile + suffix, nil
+	}
+	dbFile, err := GetLocalMarkFile()
+	if err != nil {
+		return "", err
+	}
+	return dbFile + suffix, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// The names of backups start with a prefix followed by the time. Those
+// taken before destructive changes are rotated, unlike those taken by
+// backup.
+const (
+	manualBackupPrefix = "backup-"
+	autoBackupPrefix   = "auto-"
+)
+
+// BackupStore keeps snapshots of the db, with all of the marks' metadata,
+// in Dir, one file in the format of the local db per backup.
+type BackupStore struct {
+	Dir string
+	// Keep is how many automatic backups are kept, the oldest being
+	// removed first.
+	Keep int
+}
+
+func NewBackupStore(dir string, keep int) *BackupStore {
+	return &BackupStore{Dir: dir, Keep: keep}
+}
+
+// Save writes marks to a new backup named after the time, and returns its
+// path. Automatic backups beyond Keep are removed afterwards.
+func (b *BackupStore) Save(marks []Mark, auto bool) (string, error) {
+	if err := markdb.MkdirAllOwned(b.Dir, 0700); err != nil {
+		return "", err
+	}
+	prefix := manualBackupPrefix
+	if auto {
+		prefix = autoBackupPrefix
+	}
+	// The time is made unique across both kinds of backups, so that they
+	// sort in the order they were taken.
+	now := time.Now().Format("20060102-150405")
+	stamp := now
+	for n := 2; b.taken(stamp); n++ {
+		stamp = fmt.Sprintf("%v-%v", now, n)
+	}
+	path := filepath.Join(b.Dir, prefix+stamp)
+	if err := WriteBackup(path, marks); err != nil {
+		return "", err
+	}
+	if auto {
+		return path, b.rotate()
+	}
+	return path, nil
+}
+
+// taken reports whether a backup was taken at stamp.
+func (b *BackupStore) taken(stamp string) bool {
+	for _, prefix := range []string{manualBackupPrefix, autoBackupPrefix} {
+		if _, err := os.Lstat(filepath.Join(b.Dir, prefix+stamp)); !errors.Is(err, os.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the paths of the backups, oldest first.
+func (b *BackupStore) List() ([]string, error) {
+	entries, err := os.ReadDir(b.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			paths = append(paths, filepath.Join(b.Dir, entry.Name()))
+		}
+	}
+	// The names end with the time, which sorts them whatever their prefix.
+	slices.SortFunc(paths, func(a, b string) int {
+		return strings.Compare(backupTime(a), backupTime(b))
+	})
+	return paths, nil
+}
+
+// Latest returns the path of the newest backup.
+func (b *BackupStore) Latest() (string, error) {
+	paths, err := b.List()
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "", fmt.Errorf("there are no backups in %v", b.Dir)
+	}
+	return paths[len(paths)-1], nil
+}
+
+// rotate removes the oldest automatic backups beyond Keep.
+func (b *BackupStore) rotate() error {
+	paths, err := b.List()
+	if err != nil {
+		return err
+	}
+	paths = slices.DeleteFunc(paths, func(path string) bool {
+		return !strings.HasPrefix(filepath.Base(path), autoBackupPrefix)
+	})
+	for len(paths) > b.Keep {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// backupTime returns the part of the name of a backup from its time on.
+func backupTime(path string) string {
+	name := filepath.Base(path)
+	if _, rest, ok := strings.Cut(name, "-"); ok {
+		return rest
+	}
+	return name
+}
+
+// WriteBackup writes marks to path in the format of the local db.
+func WriteBackup(path string, marks []Mark) error {
+	return markdb.WriteFileAtomic(path, 0600, func(w io.Writer) error {
+		return markdb.WriteDB(w, marks)
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// benchBackend creates an empty, throwaway instance of a storage backend
+// for benchmarking, and a function removing it afterwards.
+type benchBackend struct {
+	name   string
+	create func() (MarkDB, func(), error)
+}
+
+var benchBackends = []benchBackend{
+	{name: "local", create: newBenchLocalDB},
+	{name: "memory", create: newBenchMemoryDB},
+}
+
+func newBenchMemoryDB() (MarkDB, func(), error) {
+	return markdb.NewMemoryMarkDB(), func() {}, nil
+}
+
+func newBenchLocalDB() (MarkDB, func(), error) {
+	dir, err := os.MkdirTemp("", "mark-bench")
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := markdb.NewLocalMarkDB(filepath.Join(dir, "marks"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	return db, func() { os.RemoveAll(dir) }, nil
+}
+
+type benchResult struct {
+	backend    string
+	size       int
+	operation  string
+	operations int
+	elapsed    time.Duration
+}
+
+// RunBench measures add, get, list and delete against each backend
+// seeded with each dataset size, running operations of each, and writes
+// a table of the results to w.
+func RunBench(w io.Writer, backends []benchBackend, sizes []int, operations int) error {
+	var results []benchResult
+	for _, backend := range backends {
+		for _, size := range sizes {
+			backendResults, err := benchBackendSize(backend, size, operations)
+			if err != nil {
+				return fmt.Errorf("%v: %v", backend.name, err)
+			}
+			results = append(results, backendResults...)
+		}
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "backend\tmarks\toperation\tops\tavg\tops/s\t")
+	for _, result := range results {
+		average := result.elapsed / time.Duration(result.operations)
+		throughput := float64(result.operations) / result.elapsed.Seconds()
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\t%.0f\t\n", result.backend, result.size, result.operation, result.operations, average, throughput)
+	}
+	return table.Flush()
+}
+
+func benchBackendSize(backend benchBackend, size int, operations int) ([]benchResult, error) {
+	db, cleanup, err := backend.create()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	for index := range size {
+		if err := db.Add(Mark{Path: benchPath(index)}); err != nil {
+			return nil, err
+		}
+	}
+
+	measure := func(operation string, run func(iteration int) error) (benchResult, error) {
+		start := time.Now()
+		for iteration := range operations {
+			if err := run(iteration); err != nil {
+				return benchResult{}, fmt.Errorf("%v: %v", operation, err)
+			}
+		}
+		return benchResult{backend.name, size, operation, operations, time.Since(start)}, nil
+	}
+	steps := []struct {
+		operation string
+		run       func(iteration int) error
+	}{
+		{"add", func(iteration int) error {
+			return db.Add(Mark{Path: benchPath(size + iteration)})
+		}},
+		{"get", func(int) error {
+			_, err := db.Get(rand.Intn(size + operations))
+			return err
+		}},
+		{"list", func(int) error {
+			_, err := db.List()
+			return err
+		}},
+		{"delete", func(int) error {
+			return db.Delete(0)
+		}},
+	}
+	var results []benchResult
+	for _, step := range steps {
+		result, err := measure(step.operation, step.run)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func benchPath(index int) string {
+	return filepath.Join(os.TempDir(), "mark-bench", "project-"+strconv.Itoa(index))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// ChainMarkDB combines an ordered list of backends, such as a remote
+// server followed by a local cache. Reads are served by the first backend
+// that is available, and the backends after it are refreshed with the
+// result. Writes go to the first backend; while it is unavailable they are
+// queued, and applied to the first available backend after it, then
+// replayed once the first backend can be reached again. It is safe for
+// concurrent use: each operation holds a lock for its whole list, write and
+// replay, so that a queued write is not replayed twice.
+type ChainMarkDB struct {
+	backends []MarkDB
+	queue    *WriteQueue
+	// mu is shared with the copies bound to a context.
+	mu *sync.Mutex
+}
+
+func NewChainMarkDB(backends []MarkDB, queue *WriteQueue) *ChainMarkDB {
+	return &ChainMarkDB{backends: backends, queue: queue, mu: &sync.Mutex{}}
+}
+
+func (c *ChainMarkDB) WithContext(ctx context.Context) MarkDB {
+	backends := make([]MarkDB, len(c.backends))
+	for i, backend := range c.backends {
+		backends[i] = markdb.BindContext(ctx, backend)
+	}
+	return &ChainMarkDB{backends: backends, queue: c.queue, mu: c.mu}
+}
+
+func (c *ChainMarkDB) Get(index int) (Mark, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	marks, err := c.list()
+	if err != nil {
+		return Mark{}, err
+	}
+	if index < 0 || index >= len(marks) {
+		return Mark{}, errors.New("invalid index")
+	}
+	return marks[index], nil
+}
+
+func (c *ChainMarkDB) GetByName(name string) (int, Mark, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	marks, err := c.list()
+	if err != nil {
+		return 0, Mark{}, err
+	}
+	return markdb.FindName(marks, name)
+}
+
+func (c *ChainMarkDB) List() ([]Mark, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.list()
+}
+
+func (c *ChainMarkDB) list() ([]Mark, error) {
+	c.replay()
+	var lastErr error
+	for position, backend := range c.backends {
+		marks, err := backend.List()
+		if errors.Is(err, ErrUnavailable) {
+			lastErr = err
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, cache := range c.backends[position+1:] {
+			if err := syncMarks(cache, marks); err != nil && !errors.Is(err, ErrUnavailable) {
+				return nil, err
+			}
+		}
+		return marks, nil
+	}
+	return nil, lastErr
+}
+
+func (c *ChainMarkDB) Add(mark Mark) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(QueuedWrite{Op: "add", Mark: mark}, func(db MarkDB) error {
+		return db.Add(mark)
+	})
+}
+
+func (c *ChainMarkDB) Insert(index int, mark Mark) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(QueuedWrite{Op: "insert", Mark: mark, To: index}, func(db MarkDB) error {
+		return db.Insert(index, mark)
+	})
+}
+
+func (c *ChainMarkDB) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(QueuedWrite{Op: "clear"}, func(db MarkDB) error {
+		return db.Clear()
+	})
+}
+
+func (c *ChainMarkDB) Replace(marks []Mark) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(QueuedWrite{Op: "replace", Marks: marks}, func(db MarkDB) error {
+		return db.Replace(marks)
+	})
+}
+
+func (c *ChainMarkDB) Delete(index int) error {
+	return c.DeleteMany([]int{index})
+}
+
+func (c *ChainMarkDB) DeleteMany(indexes []int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	marks, err := c.list()
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, index := range indexes {
+		if index < 0 || index >= len(marks) {
+			return errors.New("invalid index")
+		}
+		paths = append(paths, marks[index].Path)
+	}
+	return c.write(QueuedWrite{Op: "delete", Paths: paths}, func(db MarkDB) error {
+		return db.DeleteMany(indexes)
+	})
+}
+
+func (c *ChainMarkDB) Update(index int, mark Mark) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(QueuedWrite{Op: "update", Mark: mark}, func(db MarkDB) error {
+		return db.Update(index, mark)
+	})
+}
+
+func (c *ChainMarkDB) Move(from int, to int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	marks, err := c.list()
+	if err != nil {
+		return err
+	}
+	if from < 0 || from >= len(marks) {
+		return errors.New("invalid index")
+	}
+	return c.write(QueuedWrite{Op: "move", Mark: marks[from], To: to}, func(db MarkDB) error {
+		return db.Move(from, to)
+	})
+}
+
+// write applies a write to the first backend, refreshing the backends
+// after it. While the first backend is unavailable the write is queued for
+// it, and applied to the first available backend after it so that it is
+// visible straight away.
+func (c *ChainMarkDB) write(queued QueuedWrite, apply func(db MarkDB) error) error {
+	c.replay()
+	err := apply(c.backends[0])
+	if err == nil {
+		marks, err := c.backends[0].List()
+		if err != nil {
+			return nil
+		}
+		for _, cache := range c.backends[1:] {
+			syncMarks(cache, marks)
+		}
+		return nil
+	} else if !errors.Is(err, ErrUnavailable) {
+		return err
+	}
+	for _, fallback := range c.backends[1:] {
+		fallbackErr := apply(fallback)
+		if errors.Is(fallbackErr, ErrUnavailable) {
+			continue
+		} else if fallbackErr != nil {
+			return fallbackErr
+		}
+		break
+	}
+	if err := c.queue.Append(queued); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%v, the change is queued until it is back (see mark flush)\n", err)
+	return nil
+}
+
+// Flush replays the queued writes to the first backend, returning how many
+// were replayed and how many are still queued.
+func (c *ChainMarkDB) Flush() (replayed int, queued int, conflicts []error, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	replayed, conflicts, err = c.queue.Replay(c.backends[0])
+	writes, listErr := c.queue.List()
+	if err == nil {
+		err = listErr
+	}
+	return replayed, len(writes), conflicts, err
+}
+
+// replay replays the queued writes to the first backend when it is
+// available. Writes that conflict with changes made since are reported
+// and dropped.
+func (c *ChainMarkDB) replay() {
+	_, conflicts, err := c.queue.Replay(c.backends[0])
+	for _, conflict := range conflicts {
+		fmt.Fprintln(os.Stderr, conflict)
+	}
+	if err != nil && !errors.Is(err, ErrUnavailable) {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// syncMarks makes the marks of db equal to marks, leaving db untouched
+// when they already are.
+func syncMarks(db MarkDB, marks []Mark) error {
+	current, err := db.List()
+	if err != nil {
+		return err
+	}
+	if slices.EqualFunc(current, marks, marksEqual) {
+		return nil
+	}
+	return db.Replace(marks)
+}
+
+func marksEqual(a Mark, b Mark) bool {
+	encodedA, errA := markdb.EncodeMark(a)
+	encodedB, errB := markdb.EncodeMark(b)
+	return errA == nil && errB == nil && encodedA == encodedB
+}
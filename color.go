@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+var colorCodes = map[string]string{
+	"black":   "30",
+	"red":     "31",
+	"green":   "32",
+	"yellow":  "33",
+	"blue":    "34",
+	"magenta": "35",
+	"cyan":    "36",
+	"white":   "37",
+}
+
+// ColorNames returns the supported color names in sorted order.
+func ColorNames() []string {
+	var names []string
+	for name := range colorCodes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func ValidateColor(color string) error {
+	if _, ok := colorCodes[color]; !ok {
+		return fmt.Errorf("unknown color %q, use one of %v", color, strings.Join(ColorNames(), ", "))
+	}
+	return nil
+}
+
+// Colorize wraps text in the escape codes for color. Unknown colors leave
+// the text unchanged.
+func Colorize(text string, color string) string {
+	code, ok := colorCodes[color]
+	if !ok {
+		return text
+	}
+	return "\x1b[" + code + "m" + text + "\x1b[0m"
+}
+
+// ColorEnabled reports whether output to stdout should be colored: stdout
+// must be a terminal and NO_COLOR must not be set, unless the color setting
+// is always or never.
+func ColorEnabled() bool {
+	switch colorSetting {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return stdoutIsTerminal
+}
+
+// colorSetting is the color setting, set at startup.
+var colorSetting = "auto"
+
+// stdoutIsTerminal is decided at startup, before a pager replaces
+// os.Stdout with a pipe.
+var stdoutIsTerminal = IsTerminal(os.Stdout)
+
+// IsTerminal reports whether file is a terminal.
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is a character device too, as in cron jobs and CI.
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// MarkColor returns the color a mark is displayed in: its own color, or
+// else the color configured for the first of its tags that has one.
+func MarkColor(mark Mark, tagColors map[string]string) string {
+	if mark.Color != "" {
+		return mark.Color
+	}
+	for _, tag := range mark.Tags {
+		if color, ok := tagColors[tag]; ok {
+			return color
+		}
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// markArgCommands are the commands whose first argument is a mark, which
+// the completions complete with the indexes and names of the marks.
+var markArgCommands = []string{"clone", "color", "delete", "exec", "exists", "get", "mount", "move-to", "pin", "rel", "relink", "rename", "swap", "tag", "top", "unarchive", "zellij"}
+
+// dirArgCommands are the commands whose argument is a directory.
+var dirArgCommands = []string{"add", "scan"}
+
+// shellArgCommands are the commands whose argument is a shell.
+var shellArgCommands = []string{"completion", "init", "install"}
+
+// ancestorArgCommands are the commands whose argument is a parent
+// directory, which the completions complete with the names of the parents
+// of the current directory.
+var ancestorArgCommands = []string{"back", "up"}
+
+// completionShells are the shells "mark completion" writes scripts for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// bashCompletion completes the commands of mark and, by asking "mark
+// completion --marks", the marks for the commands taking one and for move,
+// and by asking "mark completion --ancestors", the parent directories for
+// back and up. The %[n]v verbs are replaced by the commands, the mark,
+// directory and shell taking commands, the shells, and the parent directory
+// taking commands.
+const bashCompletion = `_mark_marks() {
+	local IFS=$'\n'
+	COMPREPLY=($(compgen -W "$(mark completion --marks 2>/dev/null | cut -f1)" -- "$1"))
+}
+
+_mark_ancestors() {
+	local IFS=$'\n'
+	COMPREPLY=($(compgen -W "$(mark completion --ancestors 2>/dev/null)" -- "$1"))
+}
+
+_mark() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if (( COMP_CWORD == 1 )); then
+		COMPREPLY=($(compgen -W '%[1]v' -- "$cur"))
+		return
+	fi
+	(( COMP_CWORD == 2 )) || return
+	case ${COMP_WORDS[1]} in
+	%[2]v) _mark_marks "$cur" ;;
+	%[3]v) COMPREPLY=($(compgen -d -- "$cur")) ;;
+	%[4]v) COMPREPLY=($(compgen -W '%[5]v' -- "$cur")) ;;
+	%[6]v) _mark_ancestors "$cur" ;;
+	esac
+}
+
+_mark_move() {
+	(( COMP_CWORD == 1 )) && _mark_marks "${COMP_WORDS[1]}"
+}
+
+_mark_parent() {
+	(( COMP_CWORD == 1 )) && _mark_ancestors "${COMP_WORDS[1]}"
+}
+
+complete -F _mark mark
+complete -F _mark_move move
+complete -F _mark_parent back up
+`
+
+// zshCompletion is the zsh version of bashCompletion, describing each mark
+// with its path. It works both sourced and autoloaded from $fpath as _mark.
+const zshCompletion = `#compdef mark move back up
+
+_mark_marks() {
+	local line
+	local -a marks
+	for line in ${(f)"$(mark completion --marks 2>/dev/null)"}; do
+		marks+=("${${line%%%%$'\t'*}//:/\\:}:${line#*$'\t'}")
+	done
+	_describe -t marks mark marks
+}
+
+_mark_ancestors() {
+	local -a names=(${(f)"$(mark completion --ancestors 2>/dev/null)"})
+	compadd -a names
+}
+
+_mark() {
+	if [[ $service == move ]]; then
+		(( CURRENT == 2 )) && _mark_marks
+		return
+	fi
+	if [[ $service == (back|up) ]]; then
+		(( CURRENT == 2 )) && _mark_ancestors
+		return
+	fi
+	if (( CURRENT == 2 )); then
+		local -a commands=(%[1]v)
+		_describe -t commands command commands
+		return
+	fi
+	(( CURRENT == 3 )) || return
+	case $words[2] in
+	%[2]v) _mark_marks ;;
+	%[3]v) _files -/ ;;
+	%[4]v) _values shell %[5]v ;;
+	%[6]v) _mark_ancestors ;;
+	esac
+}
+
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+	_mark "$@"
+else
+	compdef _mark mark move back up
+fi
+`
+
+// fishCompletion is the fish version of bashCompletion. Fish shows the
+// path after the tab as the description of a mark.
+const fishCompletion = `function __mark_marks
+	mark completion --marks 2>/dev/null
+end
+
+complete -c mark -f
+complete -c mark -n __fish_use_subcommand -a '%[1]v'
+complete -c mark -n '__fish_seen_subcommand_from %[2]v' -a '(__mark_marks)'
+complete -c mark -n '__fish_seen_subcommand_from %[3]v' -F
+complete -c mark -n '__fish_seen_subcommand_from %[4]v' -a '%[5]v'
+complete -c mark -n '__fish_seen_subcommand_from %[6]v' -a '(mark completion --ancestors 2>/dev/null)'
+complete -c move -f -a '(__mark_marks)'
+complete -c back -f -a '(mark completion --ancestors 2>/dev/null)'
+complete -c up -f -a '(mark completion --ancestors 2>/dev/null)'
+`
+
+// CompletionScript returns the completion script for shell completing the
+// given commands.
+func CompletionScript(shell string, commands []string) (string, error) {
+	var script, separator string
+	switch shell {
+	case "bash":
+		script, separator = bashCompletion, "|"
+	case "zsh":
+		script, separator = zshCompletion, "|"
+	case "fish":
+		script, separator = fishCompletion, " "
+	default:
+		return "", fmt.Errorf("unsupported shell %q, completions are available for %v", shell, strings.Join(completionShells, ", "))
+	}
+	shells := append(completionShells[:len(completionShells):len(completionShells)], "powershell")
+	return fmt.Sprintf(script,
+		strings.Join(commands, " "),
+		strings.Join(markArgCommands, separator),
+		strings.Join(dirArgCommands, separator),
+		strings.Join(shellArgCommands, separator),
+		strings.Join(shells, " "),
+		strings.Join(ancestorArgCommands, separator)), nil
+}
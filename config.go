@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// Config holds the user settings read from the mark config file.
+type Config struct {
+	// DBFile is the location of the local db, ~/.mark by default.
+	DBFile string
+	// Exclude lists glob patterns for directories that are never
+	// auto-tracked or suggested.
+	Exclude []string
+	// Roots maps a display name to a directory. Paths beneath a root are
+	// displayed relative to its name, e.g. SRC/web-api.
+	Roots map[string]string
+	// Logical makes add use $PWD, keeping symlinks, instead of the
+	// resolved working directory.
+	Logical bool
+	// TagColors maps a tag to the color marks with that tag are listed in.
+	TagColors map[string]string
+	// Notifier reports marks that fail to resolve in get.
+	Notifier Notifier
+	// AutoArchiveAfter is how long a mark can go unused before gc archives
+	// it. Zero disables archiving.
+	AutoArchiveAfter time.Duration
+	// PurgeDeletedAfter is how long deleted marks are kept before gc
+	// purges them. Zero keeps them forever.
+	PurgeDeletedAfter time.Duration
+	// MountCommand is run by the shell to mount the volume of a mark,
+	// with MARK_MOUNT_POINT and MARK_MOUNT_SOURCE set.
+	MountCommand string
+	// Backends lists the storage backends in the order they are tried,
+	// e.g. a remote server followed by the local db as its cache.
+	Backends []string
+	// RemoteURL and RemoteToken locate the server of the remote backend.
+	// The token may also be given as $MARK_REMOTE_TOKEN.
+	RemoteURL   string
+	RemoteToken string
+	// RedisURL and RedisKey locate the list of the redis backend. The URL
+	// may also be given as $MARK_REDIS_URL.
+	RedisURL string
+	RedisKey string
+	// S3Bucket and S3Key locate the object of the s3 backend, at
+	// S3Endpoint, AWS in S3Region by default.
+	S3Endpoint string
+	S3Region   string
+	S3Bucket   string
+	S3Key      string
+	// EncryptedDBFile is where the encrypted backend keeps the marks,
+	// ~/.mark.enc by default, and EncryptionKey where a new file's key
+	// comes from: "passphrase" or "keyring".
+	EncryptedDBFile string
+	EncryptionKey   string
+	// MaxEntries is how many marks there may be before the least recently
+	// used are moved to the trash; zero allows any number.
+	MaxEntries int
+	// AutoBackups is how many backups of the marks taken before
+	// destructive changes, such as gc purging the trash, are kept; zero disables them.
+	AutoBackups int
+	// Deny lists glob patterns for directories add refuses to mark, or
+	// only warns about when DenyWarn is set, unless forced.
+	Deny     []string
+	DenyWarn bool
+	// ReplaceDescendants makes add delete the marks beneath a newly marked
+	// directory instead of keeping both.
+	ReplaceDescendants bool
+	// ShowRemap makes the commands that renumber the marks print the old
+	// and new indexes.
+	ShowRemap bool
+	// Timeout limits how long storage operations may take, zero waits
+	// forever.
+	Timeout time.Duration
+	// Vars defines the placeholders, such as {project_root}, that can be
+	// used in marked paths.
+	Vars map[string]string
+	// Webhooks and SlackWebhooks are posted to when marks are added or
+	// removed.
+	Webhooks      []string
+	SlackWebhooks []string
+	// UsageJournal records every jump locally for mark report.
+	UsageJournal bool
+	// Pager is the program long listings are paged through, $PAGER or
+	// less by default. NoPager disables paging.
+	Pager   string
+	NoPager bool
+	// MonorepoDetectors chooses how scan --monorepo recognises packages:
+	// go, node, bazel, or the name of a file found in each package.
+	MonorepoDetectors []string
+	// Sort is the order list shows the marks in, by index by default.
+	Sort string
+	// Color is when output is colored: auto, on a terminal unless NO_COLOR
+	// is set, always or never.
+	Color string
+	// DefaultCommand is run when mark is given no command, add by default.
+	DefaultCommand string
+	// ZshJumpKey is the key the zsh mark-jump widget is bound to, ctrl-g
+	// by default.
+	ZshJumpKey string
+	// Ephemeral keeps the marks in memory, never writing to the disk. It
+	// is set by --ephemeral or MARK_EPHEMERAL=1 rather than the config
+	// file.
+	Ephemeral bool
+	// DryRun works on a copy of the marks in memory, so that a command
+	// prints what it would change without changing anything. It is set by
+	// --dry-run.
+	DryRun bool
+	// NonInteractive skips prompts, colors and paging and prints errors on
+	// a single line. It is set by --non-interactive, MARK_NON_INTERACTIVE=1
+	// or when stdin is not a terminal.
+	NonInteractive bool
+	// Profile is the profile whose marks are used, set by --profile,
+	// $MARK_PROFILE or "mark use". ProfilesDir holds the profiles.
+	Profile     string
+	ProfilesDir string
+}
+
+func NewDefaultConfig() *Config {
+	return &Config{
+		Roots:             map[string]string{},
+		TagColors:         map[string]string{},
+		Vars:              map[string]string{},
+		Deny:              []string{"/", "/tmp"},
+		DefaultCommand:    "add",
+		Sort:              "index",
+		Color:             "auto",
+		AutoBackups:       3,
+		PurgeDeletedAfter: 30 * 24 * time.Hour,
+	}
+}
+
+func GetConfigFile() (string, error) {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "mark", "config.toml"), nil
+	}
+	if runtime.GOOS == "windows" {
+		configHome, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(configHome, "mark", "config.toml"), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "mark", "config.toml"), nil
+}
+
+// LoadConfig reads the config file. A missing file is not an error and
+// results in the default config.
+func LoadConfig() (*Config, error) {
+	config := NewDefaultConfig()
+	configFile, err := GetConfigFile()
+	if err != nil {
+		return config, nil
+	}
+	file, err := os.Open(configFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values, err := parseConfig(file.Name(), bufio.NewScanner(file))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		if err := config.set(key, value); err != nil {
+			return nil, fmt.Errorf("%v: %v", configFile, err)
+		}
+	}
+	return config, nil
+}
+
+func (c *Config) set(key string, value any) error {
+	var err error
+	switch {
+	case key == "db_file":
+		var dbFile string
+		dbFile, err = configString(key, value)
+		c.DBFile = markdb.ExpandHome(dbFile)
+	case key == "notify":
+		c.Notifier.Desktop, err = configBool(key, value)
+	case key == "notify_command":
+		c.Notifier.Command, err = configString(key, value)
+	case key == "auto_archive_after":
+		var age string
+		age, err = configString(key, value)
+		if err == nil {
+			c.AutoArchiveAfter, err = ParseAge(age)
+		}
+	case key == "purge_deleted_after":
+		var age string
+		age, err = configString(key, value)
+		if err == nil {
+			c.PurgeDeletedAfter, err = ParseAge(age)
+		}
+	case key == "mount_command":
+		c.MountCommand, err = configString(key, value)
+	case key == "backends":
+		c.Backends, err = configStrings(key, value)
+	case key == "remote_url":
+		c.RemoteURL, err = configString(key, value)
+	case key == "remote_token":
+		c.RemoteToken, err = configString(key, value)
+	case key == "redis_url":
+		c.RedisURL, err = configString(key, value)
+	case key == "redis_key":
+		c.RedisKey, err = configString(key, value)
+	case key == "s3_endpoint":
+		c.S3Endpoint, err = configString(key, value)
+	case key == "s3_region":
+		c.S3Region, err = configString(key, value)
+	case key == "s3_bucket":
+		c.S3Bucket, err = configString(key, value)
+	case key == "s3_key":
+		c.S3Key, err = configString(key, value)
+	case key == "encrypted_db_file":
+		var file string
+		file, err = configString(key, value)
+		c.EncryptedDBFile = markdb.ExpandHome(file)
+	case key == "encryption_key":
+		c.EncryptionKey, err = configString(key, value)
+	case key == "max_entries":
+		c.MaxEntries, err = configInt(key, value)
+		if err == nil && c.MaxEntries < 0 {
+			err = fmt.Errorf("%v must not be negative", key)
+		}
+	case key == "auto_backups":
+		c.AutoBackups, err = configInt(key, value)
+		if err == nil && c.AutoBackups < 0 {
+			err = fmt.Errorf("%v must not be negative", key)
+		}
+	case key == "deny":
+		c.Deny, err = configStrings(key, value)
+	case key == "deny_action":
+		var action string
+		action, err = configString(key, value)
+		if err == nil && action != "refuse" && action != "warn" {
+			err = fmt.Errorf("%v must be refuse or warn", key)
+		}
+		c.DenyWarn = action == "warn"
+	case key == "on_nested":
+		var action string
+		action, err = configString(key, value)
+		if err == nil && action != "keep-both" && action != "replace-descendants" {
+			err = fmt.Errorf("%v must be keep-both or replace-descendants", key)
+		}
+		c.ReplaceDescendants = action == "replace-descendants"
+	case key == "show_remap":
+		c.ShowRemap, err = configBool(key, value)
+	case key == "timeout":
+		var timeout string
+		timeout, err = configString(key, value)
+		if err == nil {
+			c.Timeout, err = time.ParseDuration(timeout)
+		}
+	case key == "webhooks":
+		c.Webhooks, err = configStrings(key, value)
+	case key == "slack_webhooks":
+		c.SlackWebhooks, err = configStrings(key, value)
+	case key == "usage_journal":
+		c.UsageJournal, err = configBool(key, value)
+	case key == "pager":
+		c.Pager, err = configString(key, value)
+	case key == "paging":
+		var paging bool
+		paging, err = configBool(key, value)
+		c.NoPager = !paging
+	case key == "monorepo_detectors":
+		c.MonorepoDetectors, err = configStrings(key, value)
+	case key == "sort":
+		c.Sort, err = configString(key, value)
+		if _, ok := sortOrders[c.Sort]; err == nil && !ok {
+			err = fmt.Errorf("%v must be one of %v", key, strings.Join(slices.Sorted(maps.Keys(sortOrders)), ", "))
+		}
+	case key == "color":
+		c.Color, err = configString(key, value)
+		if err == nil && c.Color != "auto" && c.Color != "always" && c.Color != "never" {
+			err = fmt.Errorf("%v must be auto, always or never", key)
+		}
+	case key == "default_command":
+		c.DefaultCommand, err = configString(key, value)
+	case key == "zsh_jump_key":
+		c.ZshJumpKey, err = configString(key, value)
+	case key == "exclude":
+		c.Exclude, err = configStrings(key, value)
+	case key == "logical":
+		c.Logical, err = configBool(key, value)
+	case strings.HasPrefix(key, "roots."):
+		root, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%v must be a string", key)
+		}
+		c.Roots[strings.TrimPrefix(key, "roots.")] = markdb.ExpandHome(root)
+	case strings.HasPrefix(key, "vars."):
+		variable, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%v must be a string", key)
+		}
+		c.Vars[strings.TrimPrefix(key, "vars.")] = markdb.ExpandHome(variable)
+	case strings.HasPrefix(key, "tag_colors."):
+		color, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%v must be a string", key)
+		}
+		if err := ValidateColor(color); err != nil {
+			return fmt.Errorf("%v: %v", key, err)
+		}
+		c.TagColors[strings.TrimPrefix(key, "tag_colors.")] = color
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return err
+}
+
+// Setting is a setting and its value, formatted as in the config file.
+type Setting struct {
+	Key   string
+	Value string
+}
+
+// Settings returns the value of every setting, defaults included, in the
+// order the README documents them. Settings in sections are returned as
+// "section.key".
+func (c *Config) Settings() []Setting {
+	backends, zshJumpKey, detectors := c.Backends, c.ZshJumpKey, c.MonorepoDetectors
+	if len(backends) == 0 {
+		backends = []string{"local"}
+	}
+	if zshJumpKey == "" {
+		zshJumpKey = defaultZshJumpKey
+	}
+	if len(detectors) == 0 {
+		detectors = defaultMonorepoDetectors
+	}
+	// The token is a secret, only whether it is set is shown.
+	remoteToken := c.RemoteToken
+	if remoteToken != "" {
+		remoteToken = "********"
+	}
+	// The password of the Redis URL is a secret too.
+	redisURL, redisKey := c.RedisURL, c.RedisKey
+	if parsed, err := url.Parse(redisURL); err == nil {
+		redisURL = parsed.Redacted()
+	}
+	if redisKey == "" {
+		redisKey = defaultRedisKey
+	}
+	encryptionKey := c.EncryptionKey
+	if encryptionKey == "" {
+		encryptionKey = keyFromPassphrase
+	}
+	s3Key := c.S3Key
+	if s3Key == "" {
+		s3Key = defaultS3Key
+	}
+	denyAction, onNested := "refuse", "keep-both"
+	if c.DenyWarn {
+		denyAction = "warn"
+	}
+	if c.ReplaceDescendants {
+		onNested = "replace-descendants"
+	}
+	settings := []Setting{
+		{"db_file", formatString(c.DBFile)},
+		{"exclude", formatStrings(c.Exclude)},
+		{"deny", formatStrings(c.Deny)},
+		{"deny_action", formatString(denyAction)},
+		{"on_nested", formatString(onNested)},
+		{"show_remap", strconv.FormatBool(c.ShowRemap)},
+		{"timeout", formatString(c.Timeout.String())},
+		{"webhooks", formatStrings(c.Webhooks)},
+		{"slack_webhooks", formatStrings(c.SlackWebhooks)},
+		{"usage_journal", strconv.FormatBool(c.UsageJournal)},
+		{"pager", formatString(c.Pager)},
+		{"paging", strconv.FormatBool(!c.NoPager)},
+		{"sort", formatString(c.Sort)},
+		{"color", formatString(c.Color)},
+		{"default_command", formatString(c.DefaultCommand)},
+		{"zsh_jump_key", formatString(zshJumpKey)},
+		{"logical", strconv.FormatBool(c.Logical)},
+		{"notify", strconv.FormatBool(c.Notifier.Desktop)},
+		{"notify_command", formatString(c.Notifier.Command)},
+		{"auto_archive_after", formatString(FormatAge(c.AutoArchiveAfter))},
+		{"purge_deleted_after", formatString(FormatAge(c.PurgeDeletedAfter))},
+		{"mount_command", formatString(c.MountCommand)},
+		{"backends", formatStrings(backends)},
+		{"remote_url", formatString(c.RemoteURL)},
+		{"remote_token", formatString(remoteToken)},
+		{"redis_url", formatString(redisURL)},
+		{"redis_key", formatString(redisKey)},
+		{"s3_endpoint", formatString(c.S3Endpoint)},
+		{"s3_region", formatString(c.S3Region)},
+		{"s3_bucket", formatString(c.S3Bucket)},
+		{"s3_key", formatString(s3Key)},
+		{"encrypted_db_file", formatString(c.EncryptedDBFile)},
+		{"encryption_key", formatString(encryptionKey)},
+		{"max_entries", strconv.Itoa(c.MaxEntries)},
+		{"auto_backups", strconv.Itoa(c.AutoBackups)},
+		{"monorepo_detectors", formatStrings(detectors)},
+	}
+	for _, section := range []struct {
+		name   string
+		values map[string]string
+	}{{"tag_colors", c.TagColors}, {"vars", c.Vars}, {"roots", c.Roots}} {
+		for _, key := range slices.Sorted(maps.Keys(section.values)) {
+			settings = append(settings, Setting{section.name + "." + key, formatString(section.values[key])})
+		}
+	}
+	return settings
+}
+
+func formatString(s string) string {
+	return strconv.Quote(s)
+}
+
+func formatStrings(items []string) string {
+	quoted := make([]string, len(items))
+	for index, item := range items {
+		quoted[index] = strconv.Quote(item)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// FormatAge formats a duration the way ParseAge reads it, in days when it
+// is a whole number of them.
+func FormatAge(age time.Duration) string {
+	day := 24 * time.Hour
+	if age > 0 && age%day == 0 {
+		return fmt.Sprintf("%vd", int64(age/day))
+	}
+	return age.String()
+}
+
+// parseConfig understands the small subset of TOML used by the config
+// file: [sections], and key = value pairs where the value is a quoted
+// string, an integer, a boolean or a single-line array of strings.
+// Keys inside a section are returned as "section.key".
+func parseConfig(name string, scanner *bufio.Scanner) (map[string]any, error) {
+	values := map[string]any{}
+	section := ""
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, rawValue, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%v:%v: expected key = value", name, lineNumber)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value, err := parseConfigValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", name, lineNumber, err)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+func parseConfigValue(raw string) (any, error) {
+	switch {
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return nil, errors.New("unterminated array")
+		}
+		var items []string
+		for _, item := range splitConfigArray(raw[1 : len(raw)-1]) {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			value, err := parseConfigString(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case strings.HasPrefix(raw, `"`), strings.HasPrefix(raw, "'"):
+		return parseConfigString(raw)
+	case raw == "true" || raw == "false":
+		return raw == "true", nil
+	}
+	number, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %v", raw)
+	}
+	return number, nil
+}
+
+func parseConfigString(raw string) (string, error) {
+	if strings.HasPrefix(raw, "'") && strings.HasSuffix(raw, "'") && len(raw) >= 2 {
+		return raw[1 : len(raw)-1], nil
+	}
+	value, err := strconv.Unquote(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %v", raw)
+	}
+	return value, nil
+}
+
+// splitConfigArray splits the inside of an array on commas that are not
+// within quotes.
+func splitConfigArray(raw string) []string {
+	var items []string
+	var quote rune
+	start := 0
+	for i, r := range raw {
+		switch {
+		case quote != 0 && r == quote && (i == 0 || raw[i-1] != '\\'):
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			items = append(items, raw[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, raw[start:])
+}
+
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func configStrings(key string, value any) ([]string, error) {
+	items, ok := value.([]string)
+	if !ok {
+		return nil, fmt.Errorf("%v must be an array of strings", key)
+	}
+	return items, nil
+}
+
+func configString(key string, value any) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%v must be a string", key)
+	}
+	return s, nil
+}
+
+func configInt(key string, value any) (int, error) {
+	n, ok := value.(int)
+	if !ok {
+		return 0, fmt.Errorf("%v must be an integer", key)
+	}
+	return n, nil
+}
+
+func configBool(key string, value any) (bool, error) {
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%v must be true or false", key)
+	}
+	return b, nil
+}
+
+// ParseAge parses a duration such as "90d", "2w" or "36h". Days and weeks
+// are accepted in addition to the units of time.ParseDuration.
+func ParseAge(age string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if count, ok := strings.CutSuffix(age, suffix); ok {
+			number, err := strconv.Atoi(count)
+			if err != nil || number < 0 {
+				return 0, fmt.Errorf("invalid duration %q", age)
+			}
+			return time.Duration(number) * unit, nil
+		}
+	}
+	duration, err := time.ParseDuration(age)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid duration %q", age)
+	}
+	return duration, nil
+}
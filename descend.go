@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a single pattern read from a .gitignore file.
+type ignoreRule struct {
+	dir     string
+	pattern string
+}
+
+func (r ignoreRule) match(path string) bool {
+	if !strings.Contains(r.pattern, "/") {
+		matched, _ := filepath.Match(r.pattern, filepath.Base(path))
+		return matched
+	}
+	relative, err := filepath.Rel(r.dir, path)
+	if err != nil {
+		return false
+	}
+	matched, _ := filepath.Match(strings.TrimPrefix(r.pattern, "/"), filepath.ToSlash(relative))
+	return matched
+}
+
+// readIgnoreRules reads the directory patterns of the .gitignore file in
+// dir. Negated patterns are not supported and are skipped.
+func readIgnoreRules(dir string) []ignoreRule {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		rules = append(rules, ignoreRule{dir: dir, pattern: strings.TrimSuffix(line, "/")})
+	}
+	return rules
+}
+
+// FindDescendant searches the directories beneath root, at most maxDepth
+// levels deep, for the best match of pattern. Hidden directories,
+// directories ignored by .gitignore files and directories matching the
+// exclude globs are skipped. An exact name beats a name prefix, which
+// beats a substring, which beats a fuzzy match; shallower directories win
+// ties.
+func FindDescendant(root string, pattern string, maxDepth int, exclude []string) (string, error) {
+	type queued struct {
+		path  string
+		rules []ignoreRule
+	}
+	lowerPattern := strings.ToLower(pattern)
+	bestPath, bestScore := "", 0
+	level := []queued{{path: root, rules: readIgnoreRules(root)}}
+	for depth := 1; depth <= maxDepth && len(level) > 0; depth++ {
+		var next []queued
+		for _, parent := range level {
+			entries, err := os.ReadDir(parent.path)
+			if err != nil {
+				continue
+			}
+			for _, entry := range entries {
+				name := entry.Name()
+				path := filepath.Join(parent.path, name)
+				if !entry.IsDir() || strings.HasPrefix(name, ".") || isIgnored(path, parent.rules) || MatchesAny(path, exclude) {
+					continue
+				}
+				if score := matchScore(lowerPattern, strings.ToLower(name)); score > bestScore {
+					bestPath, bestScore = path, score
+				}
+				rules := append(parent.rules[:len(parent.rules):len(parent.rules)], readIgnoreRules(path)...)
+				next = append(next, queued{path: path, rules: rules})
+			}
+		}
+		// A deeper directory can only win with a better kind of match.
+		if bestScore == exactMatch {
+			break
+		}
+		level = next
+	}
+	if bestPath == "" {
+		return "", fmt.Errorf("no directory beneath %v matches %q", root, pattern)
+	}
+	return bestPath, nil
+}
+
+const (
+	fuzzyMatch = iota + 1
+	substringMatch
+	prefixMatch
+	exactMatch
+)
+
+func matchScore(pattern string, name string) int {
+	switch {
+	case name == pattern:
+		return exactMatch
+	case strings.HasPrefix(name, pattern):
+		return prefixMatch
+	case strings.Contains(name, pattern):
+		return substringMatch
+	case isSubsequence(pattern, name):
+		return fuzzyMatch
+	}
+	return 0
+}
+
+func isIgnored(path string, rules []ignoreRule) bool {
+	for _, rule := range rules {
+		if rule.match(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ShortenPath renders path for display. Paths beneath one of the roots are
+// shown relative to the root's name, using the deepest matching root, and
+// paths beneath the home directory are shown relative to "~".
+func ShortenPath(path string, roots map[string]string) string {
+	bestName, bestRoot := "", ""
+	for name, root := range roots {
+		root = filepath.Clean(root)
+		if isWithin(path, root) && len(root) > len(bestRoot) {
+			bestName, bestRoot = name, root
+		}
+	}
+	if bestRoot != "" {
+		return joinDisplay(bestName, path, bestRoot)
+	}
+	if homeDir, err := os.UserHomeDir(); err == nil && isWithin(path, homeDir) {
+		return joinDisplay("~", path, homeDir)
+	}
+	return path
+}
+
+func joinDisplay(prefix string, path string, root string) string {
+	rest := path[len(filepath.Clean(root)):]
+	rest = strings.TrimLeft(rest, string(filepath.Separator))
+	if rest == "" {
+		return prefix
+	}
+	return prefix + string(filepath.Separator) + rest
+}
+
+// isWithin reports whether path is dir or is beneath dir. Paths are
+// compared ignoring case on Windows, like its file systems do.
+func isWithin(path string, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	if runtime.GOOS == "windows" {
+		path, dir = strings.ToLower(path), strings.ToLower(dir)
+	}
+	if path == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
+
+// FormatMark renders a mark as a line of list output:
+//
+//	[0] ~/src/web-api (api) #work [pinned] - main service
+func FormatMark(index int, mark Mark) string {
+	return FormatListMark(index, mark, "", MarkColumns{}, "", false)
+}
+
+// MarkColumns are the widths list pads the index and the path of the marks
+// to, so that their names, tags and notes line up.
+type MarkColumns struct {
+	Index int
+	Path  int
+}
+
+// Fit widens the columns to fit the mark at index, with its path as
+// displayed.
+func (c *MarkColumns) Fit(index int, path string) {
+	c.Index = max(c.Index, len(fmt.Sprintf("[%v]", index)))
+	c.Path = max(c.Path, utf8.RuneCountInString(path))
+}
+
+// FormatListMark renders a mark like FormatMark, padded to columns and
+// followed by status, why its directory cannot be used, if any. When
+// colored, the index is cyan, the path red when status is set or else in
+// color, and the pinned flag yellow.
+func FormatListMark(index int, mark Mark, status string, columns MarkColumns, color string, colored bool) string {
+	paint := func(text string, color string) string {
+		if !colored {
+			return text
+		}
+		return Colorize(text, color)
+	}
+	var rest strings.Builder
+	if mark.Name != "" {
+		fmt.Fprintf(&rest, " (%v)", mark.Name)
+	}
+	for _, tag := range mark.Tags {
+		fmt.Fprintf(&rest, " #%v", tag)
+	}
+	if mark.Pinned {
+		rest.WriteString(" " + paint("[pinned]", "yellow"))
+	}
+	if mark.IsDeleted() {
+		fmt.Fprintf(&rest, " [deleted %v]", mark.Deleted.Format(time.DateOnly))
+	} else if mark.IsExpired() {
+		rest.WriteString(" [expired]")
+	}
+	if mark.Note != "" {
+		fmt.Fprintf(&rest, " - %v", mark.Note)
+	}
+	if status != "" {
+		rest.WriteString(" " + paint("["+status+"]", "red"))
+		color = "red"
+	}
+	indexText := fmt.Sprintf("[%v]", index)
+	line := paint(indexText, "cyan") + strings.Repeat(" ", max(columns.Index-len(indexText), 0)) + " " + paint(mark.Path, color)
+	if rest.Len() == 0 {
+		return line
+	}
+	return line + strings.Repeat(" ", max(columns.Path-utf8.RuneCountInString(mark.Path), 0)) + rest.String()
+}
+
+// FormatTimestamps renders when a mark was created and last used, for
+// list --long, with - for the times marks stored before timestamps were
+// recorded lack:
+//
+//	2026-10-01 12:00  2026-10-15 09:30
+func FormatTimestamps(mark Mark) string {
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return fmt.Sprintf("%-16v", "-")
+		}
+		return t.Local().Format("2006-01-02 15:04")
+	}
+	return format(mark.Created) + "  " + format(mark.LastUsed)
+}
+
+// writePaths writes raw paths for scripts, one per line, or terminated by
+// NUL when null is set so that paths containing newlines survive.
+func writePaths(w io.Writer, paths []string, null bool) error {
+	terminator := "\n"
+	if null {
+		terminator = "\x00"
+	}
+	for _, path := range paths {
+		if _, err := io.WriteString(w, path+terminator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+)
+
+// dryRunCommands are the commands --dry-run can be given to. They change
+// nothing but the marks, which --dry-run keeps in memory.
+var dryRunCommands = map[string]bool{
+	"add":    true,
+	"clear":  true,
+	"delete": true,
+	"import": true,
+	"prune":  true,
+}
+
+// cutDryRunFlag removes --dry-run from args, the command and its
+// arguments, reporting whether it was given. Arguments after "--" are left
+// alone.
+func cutDryRunFlag(args []string) ([]string, bool) {
+	index := slices.Index(args, "--dry-run")
+	if index < 0 || slices.Contains(args[:index], "--") {
+		return args, false
+	}
+	return slices.Delete(slices.Clone(args), index, index+1), true
+}
+
+// dryRun runs command on the copy of the marks --dry-run works on and
+// prints how it would have changed them.
+func (m *MarkCli) dryRun(command func(args []string), args []string) {
+	before, err := m.db.List()
+	m.handleError(err)
+	command(args)
+	after, err := m.db.List()
+	m.handleError(err)
+	WriteDryRun(os.Stdout, before, after, m.config.Roots)
+}
+
+// WriteDryRun prints how a command would have changed the marks, from
+// before to after, as "would add", "would delete", "would restore", "would
+// move" and "would update" lines. Marks shifted only by the marks added or
+// deleted around them are not reported as moved.
+func WriteDryRun(w io.Writer, before []Mark, after []Mark, roots map[string]string) {
+	live := func(marks []Mark) map[string]int {
+		indexes := map[string]int{}
+		for index, mark := range marks {
+			if !mark.IsDeleted() {
+				indexes[mark.Path] = index
+			}
+		}
+		return indexes
+	}
+	liveBefore, liveAfter := live(before), live(after)
+	known := map[string]bool{}
+	for _, mark := range before {
+		known[mark.Path] = true
+	}
+	changed := false
+	report := func(format string, args ...any) {
+		fmt.Fprintf(w, "would "+format+"\n", args...)
+		changed = true
+	}
+	for _, mark := range before {
+		if _, ok := liveBefore[mark.Path]; ok {
+			if _, ok := liveAfter[mark.Path]; !ok {
+				report("delete %v", ShortenPath(mark.Path, roots))
+			}
+		}
+	}
+	var kept []string
+	for _, mark := range after {
+		if mark.IsDeleted() {
+			continue
+		}
+		if _, ok := liveBefore[mark.Path]; ok {
+			kept = append(kept, mark.Path)
+		} else if known[mark.Path] {
+			report("restore %v", ShortenPath(mark.Path, roots))
+		} else {
+			report("add %v at [%v]", ShortenPath(mark.Path, roots), liveAfter[mark.Path])
+		}
+	}
+	// The marks kept in their order relative to each other are the longest
+	// run of them in the same order before; the others were moved.
+	for _, path := range reordered(kept, liveBefore) {
+		report("move %v from [%v] to [%v]", ShortenPath(path, roots), liveBefore[path], liveAfter[path])
+	}
+	for _, mark := range after {
+		if index, ok := liveBefore[mark.Path]; ok && !mark.IsDeleted() {
+			old := before[index]
+			old.LastUsed = mark.LastUsed
+			if !marksEqual(old, mark) {
+				report("update %v", ShortenPath(mark.Path, roots))
+			}
+		}
+	}
+	if !changed {
+		fmt.Fprintln(w, "nothing would change")
+	}
+}
+
+// reordered returns the paths, in their new order, that are not part of
+// the longest subsequence of paths still in the order of their indexes.
+func reordered(paths []string, indexes map[string]int) []string {
+	// tails[length-1] is the position in paths of the smallest index
+	// ending an increasing subsequence of that length, and previous links
+	// each position to the one before it in its subsequence.
+	var tails []int
+	previous := make([]int, len(paths))
+	for position, path := range paths {
+		length := sort.Search(len(tails), func(i int) bool {
+			return indexes[paths[tails[i]]] >= indexes[path]
+		})
+		previous[position] = -1
+		if length > 0 {
+			previous[position] = tails[length-1]
+		}
+		if length == len(tails) {
+			tails = append(tails, position)
+		} else {
+			tails[length] = position
+		}
+	}
+	inOrder := make([]bool, len(paths))
+	if len(tails) > 0 {
+		for position := tails[len(tails)-1]; position >= 0; position = previous[position] {
+			inOrder[position] = true
+		}
+	}
+	var moved []string
+	for position, path := range paths {
+		if !inOrder[position] {
+			moved = append(moved, path)
+		}
+	}
+	return moved
+}
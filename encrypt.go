@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// encryptedHeaderPrefix starts the first line of an encrypted db, which
+// goes on with how the key is obtained: "keyring", or "pbkdf2-sha256",
+// the iterations and the salt for a passphrase.
+const encryptedHeaderPrefix = "# mark-db encrypted v1 "
+
+// pbkdf2Iterations is the cost of deriving the key from a passphrase for
+// new encrypted dbs.
+const pbkdf2Iterations = 600000
+
+// Key sources of the encryption_key setting.
+const (
+	keyFromPassphrase = "passphrase"
+	keyFromKeyring    = "keyring"
+)
+
+// EncryptedMarkDB keeps the db encrypted with AES-256-GCM, for marks whose
+// directory names are sensitive. The key comes from a passphrase, taken
+// from $MARK_PASSPHRASE or asked on the terminal, or from a random key
+// kept in the OS keyring. The marks are decrypted on every read and the
+// whole file encrypted again on every change.
+type EncryptedMarkDB struct {
+	File string
+	// KeySource is how the key of a new file is obtained, keyFromPassphrase
+	// or keyFromKeyring. Existing files record their own.
+	KeySource string
+	filePerm  os.FileMode
+	// ctx bounds the wait for the lock, see WithContext.
+	ctx   context.Context
+	state *encryptedState
+}
+
+// encryptedState is the state of an EncryptedMarkDB, shared with its
+// copies bound to a context.
+type encryptedState struct {
+	mu sync.Mutex
+	// header and key are those of the file once read or created.
+	header string
+	key    []byte
+}
+
+func NewEncryptedMarkDB(file string, keySource string) (*EncryptedMarkDB, error) {
+	if keySource == "" {
+		keySource = keyFromPassphrase
+	}
+	if keySource != keyFromPassphrase && keySource != keyFromKeyring {
+		return nil, fmt.Errorf("invalid encryption_key %q, use %q or %q", keySource, keyFromPassphrase, keyFromKeyring)
+	}
+	if err := markdb.MkdirAllOwned(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	return &EncryptedMarkDB{File: file, KeySource: keySource, filePerm: 0600, ctx: context.Background(), state: &encryptedState{}}, nil
+}
+
+func (e *EncryptedMarkDB) WithContext(ctx context.Context) MarkDB {
+	bound := *e
+	bound.ctx = ctx
+	return &bound
+}
+
+func (e *EncryptedMarkDB) Get(index int) (Mark, error) {
+	marks, err := e.List()
+	if err != nil {
+		return Mark{}, err
+	}
+	if index < 0 || index >= len(marks) {
+		return Mark{}, errors.New("invalid index")
+	}
+	return marks[index], nil
+}
+
+func (e *EncryptedMarkDB) GetByName(name string) (int, Mark, error) {
+	marks, err := e.List()
+	if err != nil {
+		return 0, Mark{}, err
+	}
+	return markdb.FindName(marks, name)
+}
+
+func (e *EncryptedMarkDB) Add(mark Mark) error {
+	return e.Insert(0, mark)
+}
+
+func (e *EncryptedMarkDB) Insert(index int, mark Mark) error {
+	return e.change(func(marks []Mark) ([]Mark, error) {
+		if index < 0 || index > len(marks) {
+			return nil, errors.New("invalid index")
+		}
+		return slices.Insert(marks, index, mark), nil
+	})
+}
+
+func (e *EncryptedMarkDB) List() ([]Mark, error) {
+	e.state.mu.Lock()
+	defer e.state.mu.Unlock()
+	unlock, err := markdb.LockFileContext(e.ctx, e.File+".lock", false)
+	if err != nil {
+		return nil, fmt.Errorf("locking %v: %v", e.File, err)
+	}
+	defer unlock()
+	return e.read()
+}
+
+func (e *EncryptedMarkDB) Clear() error {
+	return e.change(func(marks []Mark) ([]Mark, error) {
+		return nil, nil
+	})
+}
+
+func (e *EncryptedMarkDB) Replace(marks []Mark) error {
+	return e.change(func([]Mark) ([]Mark, error) {
+		return slices.Clone(marks), nil
+	})
+}
+
+func (e *EncryptedMarkDB) Delete(index int) error {
+	return e.DeleteMany([]int{index})
+}
+
+func (e *EncryptedMarkDB) DeleteMany(indexes []int) error {
+	return e.change(func(marks []Mark) ([]Mark, error) {
+		for _, index := range indexes {
+			if index < 0 || index >= len(marks) {
+				return nil, errors.New("invalid index")
+			}
+		}
+		var remaining []Mark
+		for index, mark := range marks {
+			if !slices.Contains(indexes, index) {
+				remaining = append(remaining, mark)
+			}
+		}
+		return remaining, nil
+	})
+}
+
+func (e *EncryptedMarkDB) Update(index int, mark Mark) error {
+	return e.change(func(marks []Mark) ([]Mark, error) {
+		if index < 0 || index >= len(marks) {
+			return nil, errors.New("invalid index")
+		}
+		marks[index] = mark
+		return marks, nil
+	})
+}
+
+func (e *EncryptedMarkDB) Move(from int, to int) error {
+	return e.change(func(marks []Mark) ([]Mark, error) {
+		if from < 0 || from >= len(marks) || to < 0 || to >= len(marks) {
+			return nil, errors.New("invalid index")
+		}
+		mark := marks[from]
+		marks = slices.Delete(marks, from, from+1)
+		return slices.Insert(marks, to, mark), nil
+	})
+}
+
+// change replaces the marks with the result of apply, holding the lock
+// throughout like the local db.
+func (e *EncryptedMarkDB) change(apply func(marks []Mark) ([]Mark, error)) error {
+	e.state.mu.Lock()
+	defer e.state.mu.Unlock()
+	unlock, err := markdb.LockFileContext(e.ctx, e.File+".lock", true)
+	if err != nil {
+		return fmt.Errorf("locking %v: %v", e.File, err)
+	}
+	defer unlock()
+	marks, err := e.read()
+	if err != nil {
+		return err
+	}
+	if marks, err = apply(marks); err != nil {
+		return err
+	}
+	if e.state.key == nil {
+		if err := e.create(); err != nil {
+			return err
+		}
+	}
+	var plain bytes.Buffer
+	if err := markdb.WriteDB(&plain, marks); err != nil {
+		return err
+	}
+	gcm, err := newGCM(e.state.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain.Bytes(), []byte(e.state.header))
+	return markdb.WriteFileAtomic(e.File, e.filePerm, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%v\n%v\n", e.state.header, base64.StdEncoding.EncodeToString(sealed))
+		return err
+	})
+}
+
+// read decrypts the marks of the file, which are none when it does not
+// exist yet.
+func (e *EncryptedMarkDB) read() ([]Mark, error) {
+	contents, err := os.ReadFile(e.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	header, body, _ := strings.Cut(string(contents), "\n")
+	if !strings.HasPrefix(header, encryptedHeaderPrefix) {
+		return nil, fmt.Errorf("%v is not an encrypted mark db", e.File)
+	}
+	if header != e.state.header {
+		key, err := unlockKey(header)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", e.File, err)
+		}
+		e.state.header, e.state.key = header, key
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(body))
+	if err != nil {
+		return nil, fmt.Errorf("%v is corrupt: %v", e.File, err)
+	}
+	gcm, err := newGCM(e.state.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%v is corrupt", e.File)
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(header))
+	if err != nil {
+		e.state.header, e.state.key = "", nil
+		return nil, fmt.Errorf("cannot decrypt %v: wrong passphrase or key, or the file is corrupt", e.File)
+	}
+	marks, err := markdb.ReadDB(bytes.NewReader(plain))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", e.File, err)
+	}
+	return marks, nil
+}
+
+// create obtains the key of a new file from KeySource.
+func (e *EncryptedMarkDB) create() error {
+	if e.KeySource == keyFromKeyring {
+		key, err := keyringKey(true)
+		if err != nil {
+			return err
+		}
+		e.state.header, e.state.key = encryptedHeaderPrefix+keyFromKeyring, key
+		return nil
+	}
+	passphrase, err := readPassphrase(true)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	e.state.header = fmt.Sprintf("%vpbkdf2-sha256 %v %v", encryptedHeaderPrefix, pbkdf2Iterations, hex.EncodeToString(salt))
+	e.state.key = pbkdf2SHA256([]byte(passphrase), salt, pbkdf2Iterations, 32)
+	return nil
+}
+
+// unlockKey returns the key of a file with header.
+func unlockKey(header string) ([]byte, error) {
+	fields := strings.Fields(strings.TrimPrefix(header, encryptedHeaderPrefix))
+	switch {
+	case len(fields) == 1 && fields[0] == keyFromKeyring:
+		return keyringKey(false)
+	case len(fields) == 3 && fields[0] == "pbkdf2-sha256":
+		iterations, err := strconv.Atoi(fields[1])
+		if err != nil || iterations < 1 {
+			return nil, fmt.Errorf("invalid iterations %q", fields[1])
+		}
+		salt, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid salt %q", fields[2])
+		}
+		passphrase, err := readPassphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		return pbkdf2SHA256([]byte(passphrase), salt, iterations, 32), nil
+	}
+	return nil, fmt.Errorf("unsupported encryption %q, it may need a newer version of mark", strings.Join(fields, " "))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readPassphrase returns $MARK_PASSPHRASE or else asks for the passphrase
+// on the terminal without echoing it, twice when confirm is set.
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv("MARK_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("the encrypted db needs a passphrase, set MARK_PASSPHRASE")
+	}
+	defer tty.Close()
+	state, err := stty(tty, "-g")
+	if err != nil {
+		return "", err
+	}
+	if _, err := stty(tty, "-echo"); err != nil {
+		return "", err
+	}
+	defer stty(tty, strings.TrimSpace(state))
+	reader := bufio.NewReader(tty)
+	ask := func(prompt string) string {
+		fmt.Fprint(tty, prompt)
+		answer, _ := reader.ReadString('\n')
+		fmt.Fprintln(tty)
+		return strings.TrimRight(answer, "\r\n")
+	}
+	passphrase := ask("mark passphrase: ")
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm && ask("repeat the passphrase: ") != passphrase {
+		return "", errors.New("the passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// The key of the keyring is stored as the secret of this service and
+// account, hex encoded.
+const (
+	keyringService = "mark"
+	keyringAccount = "db-encryption-key"
+)
+
+// keyringKey returns the key kept in the OS keyring, with security on
+// macOS and secret-tool, of libsecret, elsewhere. With create, a random
+// key is stored when there is none yet.
+func keyringKey(create bool) ([]byte, error) {
+	var lookup *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		lookup = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	case "windows":
+		return nil, errors.New("the keyring is not supported on Windows, set encryption_key to passphrase")
+	default:
+		lookup = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	}
+	output, err := lookup.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("%v is needed to use the keyring", lookup.Path)
+	}
+	if secret := strings.TrimSpace(string(output)); err == nil && secret != "" {
+		key, err := hex.DecodeString(secret)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("the %v key in the keyring is not a mark key", keyringService)
+		}
+		return key, nil
+	}
+	if !create {
+		return nil, fmt.Errorf("the key is not in the keyring (service %v, account %v)", keyringService, keyringAccount)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	var store *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		store = exec.Command("security", "add-generic-password", "-s", keyringService, "-a", keyringAccount, "-w", hex.EncodeToString(key))
+	} else {
+		store = exec.Command("secret-tool", "store", "--label=mark db encryption key", "service", keyringService, "account", keyringAccount)
+		store.Stdin = strings.NewReader(hex.EncodeToString(key))
+	}
+	if output, err := store.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("storing the key in the keyring: %v %v", err, strings.TrimSpace(string(output)))
+	}
+	return key, nil
+}
+
+// pbkdf2SHA256 derives a key of length bytes from password with PBKDF2
+// using HMAC-SHA256, as in RFC 8018.
+func pbkdf2SHA256(password []byte, salt []byte, iterations int, length int) []byte {
+	mac := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < length; block++ {
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := mac.Sum(nil)
+		t := slices.Clone(u)
+		for range iterations - 1 {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:length]
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// Event describes a change to the marks.
+type Event struct {
+	Type string    `json:"type"`
+	Mark Mark      `json:"mark"`
+	Time time.Time `json:"time"`
+}
+
+const (
+	EventAdded   = "added"
+	EventRemoved = "removed"
+	EventJumped  = "jumped"
+	EventDeleted = "deleted"
+)
+
+// EventBus delivers events to its subscribers, in the order they
+// subscribed.
+type EventBus struct {
+	subscribers []func(event Event)
+}
+
+func (b *EventBus) Subscribe(subscriber func(event Event)) {
+	b.subscribers = append(b.subscribers, subscriber)
+}
+
+func (b *EventBus) Publish(event Event) {
+	for _, subscriber := range b.subscribers {
+		subscriber(event)
+	}
+}
+
+// EventMarkDB wraps a MarkDB, publishing an event for every mark added or
+// removed through it, and for every mark moved to the trash by Update or
+// Replace. Replace otherwise rewrites the marks wholesale, to restore or
+// reorder them, and publishes nothing else.
+type EventMarkDB struct {
+	MarkDB
+	bus *EventBus
+}
+
+func NewEventMarkDB(db MarkDB, bus *EventBus) *EventMarkDB {
+	return &EventMarkDB{MarkDB: db, bus: bus}
+}
+
+func (e *EventMarkDB) WithContext(ctx context.Context) MarkDB {
+	return &EventMarkDB{MarkDB: markdb.BindContext(ctx, e.MarkDB), bus: e.bus}
+}
+
+func (e *EventMarkDB) Add(mark Mark) error {
+	if err := e.MarkDB.Add(mark); err != nil {
+		return err
+	}
+	e.publish(EventAdded, mark)
+	return nil
+}
+
+func (e *EventMarkDB) Insert(index int, mark Mark) error {
+	if err := e.MarkDB.Insert(index, mark); err != nil {
+		return err
+	}
+	e.publish(EventAdded, mark)
+	return nil
+}
+
+func (e *EventMarkDB) Clear() error {
+	marks, err := e.MarkDB.List()
+	if err != nil {
+		return err
+	}
+	if err := e.MarkDB.Clear(); err != nil {
+		return err
+	}
+	for _, mark := range marks {
+		e.publish(EventRemoved, mark)
+	}
+	return nil
+}
+
+func (e *EventMarkDB) Delete(index int) error {
+	return e.DeleteMany([]int{index})
+}
+
+func (e *EventMarkDB) DeleteMany(indexes []int) error {
+	marks, err := e.MarkDB.List()
+	if err != nil {
+		return err
+	}
+	if err := e.MarkDB.DeleteMany(indexes); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		e.publish(EventRemoved, marks[index])
+	}
+	return nil
+}
+
+func (e *EventMarkDB) Update(index int, mark Mark) error {
+	if !mark.IsDeleted() {
+		return e.MarkDB.Update(index, mark)
+	}
+	// When no backend can be reached the update is queued, and the mark
+	// taken to have been live.
+	old, err := e.MarkDB.Get(index)
+	if err != nil && !errors.Is(err, ErrUnavailable) {
+		return err
+	}
+	if err := e.MarkDB.Update(index, mark); err != nil {
+		return err
+	}
+	if !old.IsDeleted() {
+		e.publish(EventDeleted, mark)
+	}
+	return nil
+}
+
+func (e *EventMarkDB) Replace(marks []Mark) error {
+	old, err := e.MarkDB.List()
+	if err != nil && !errors.Is(err, ErrUnavailable) {
+		return err
+	}
+	if err := e.MarkDB.Replace(marks); err != nil {
+		return err
+	}
+	live := map[string]bool{}
+	for _, mark := range old {
+		live[mark.Path] = !mark.IsDeleted()
+	}
+	for _, mark := range marks {
+		if mark.IsDeleted() && live[mark.Path] {
+			e.publish(EventDeleted, mark)
+		}
+	}
+	return nil
+}
+
+func (e *EventMarkDB) publish(eventType string, mark Mark) {
+	e.bus.Publish(Event{Type: eventType, Mark: mark, Time: time.Now()})
+}
+
+// EventLog is the file every mark process appends its events to, one JSON
+// encoded Event per line, for mark events. It is emptied once it grows past
+// maxEventLogSize, which followers notice by the file shrinking.
+type EventLog struct {
+	File string
+}
+
+const maxEventLogSize = 1 << 20
+
+func NewEventLog(file string) *EventLog {
+	return &EventLog{File: file}
+}
+
+func (l *EventLog) Append(event Event) error {
+	if l.File == os.DevNull {
+		return nil
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	flags := os.O_APPEND | os.O_WRONLY | os.O_CREATE
+	if info, err := os.Stat(l.File); err == nil && info.Size() > maxEventLogSize {
+		flags |= os.O_TRUNC
+	}
+	file, err := markdb.OpenOwned(l.File, flags, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Copy writes the events in the log to w.
+func (l *EventLog) Copy(w io.Writer) error {
+	info, err := os.Stat(l.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = l.copyFrom(w, 0, info.Size(), nil)
+	return err
+}
+
+// Follow copies the events appended to the log from now on to w, checking
+// for new ones every interval, until ctx is done or writing fails.
+func (l *EventLog) Follow(ctx context.Context, w io.Writer, interval time.Duration) error {
+	var offset int64
+	if info, err := os.Stat(l.File); err == nil {
+		offset = info.Size()
+	}
+	var partial []byte
+	for {
+		info, err := os.Stat(l.File)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err == nil && info.Size() < offset {
+			offset, partial = 0, nil
+		}
+		if err == nil && info.Size() > offset {
+			partial, err = l.copyFrom(w, offset, info.Size(), partial)
+			if err != nil {
+				return err
+			}
+			offset = info.Size()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// copyFrom writes the complete lines between offset and end to w, returning
+// the incomplete last line to be finished by the next call.
+func (l *EventLog) copyFrom(w io.Writer, offset int64, end int64, partial []byte) ([]byte, error) {
+	file, err := os.Open(l.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(io.NewSectionReader(file, offset, end-offset))
+	for {
+		line, err := reader.ReadBytes('\n')
+		partial = append(partial, line...)
+		if errors.Is(err, io.EOF) {
+			return partial, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(partial); err != nil {
+			return nil, err
+		}
+		partial = nil
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// exportFormats are the formats marks can be exported in.
+var exportFormats = map[string]func(w io.Writer, marks []Mark) error{
+	"csv":   ExportCSV,
+	"json":  ExportJSON,
+	"plain": ExportPlain,
+	"yaml":  ExportYAML,
+}
+
+// exportColumns are the columns of ExportCSV and the fields of ExportYAML,
+// in order. New columns are only ever added at the end.
+var exportColumns = []string{"index", "path", "name", "tags", "note", "pinned", "color", "created", "last_used", "archived", "deleted"}
+
+// exportFields returns the values of exportColumns for the mark at index.
+// Tags are separated by spaces, which tags cannot contain, and times are
+// RFC 3339, empty when not recorded.
+func exportFields(index int, mark Mark) []string {
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	return []string{
+		strconv.Itoa(index),
+		mark.Path,
+		mark.Name,
+		strings.Join(mark.Tags, " "),
+		mark.Note,
+		strconv.FormatBool(mark.Pinned),
+		mark.Color,
+		formatTime(mark.Created),
+		formatTime(mark.LastUsed),
+		strconv.FormatBool(mark.Archived),
+		formatTime(mark.Deleted),
+	}
+}
+
+// ExportCSV writes marks as CSV with a header row of exportColumns.
+func ExportCSV(w io.Writer, marks []Mark) error {
+	writer := csv.NewWriter(w)
+	writer.Write(exportColumns)
+	for index, mark := range marks {
+		writer.Write(exportFields(index, mark))
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ExportYAML writes marks as a YAML document with a "marks" list, fit to
+// be used as Ansible vars. Every mark has all of exportColumns, with tags
+// as a list, and strings quoted.
+func ExportYAML(w io.Writer, marks []Mark) error {
+	if len(marks) == 0 {
+		_, err := fmt.Fprintln(w, "marks: []")
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("marks:\n")
+	for index, mark := range marks {
+		for column, field := range exportFields(index, mark) {
+			prefix := "    "
+			if column == 0 {
+				prefix = "  - "
+			}
+			name := exportColumns[column]
+			switch name {
+			case "index", "pinned", "archived":
+			case "tags":
+				quoted := make([]string, len(mark.Tags))
+				for i, tag := range mark.Tags {
+					quoted[i] = yamlString(tag)
+				}
+				field = "[" + strings.Join(quoted, ", ") + "]"
+			default:
+				field = yamlString(field)
+			}
+			fmt.Fprintf(&b, "%v%v: %v\n", prefix, name, field)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// yamlString quotes s as a JSON string, which YAML reads as a double
+// quoted string.
+func yamlString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// ExportPlain writes the paths of the marks that are not deleted, one per
+// line, for shell pipelines.
+func ExportPlain(w io.Writer, marks []Mark) error {
+	for _, mark := range marks {
+		if mark.IsDeleted() {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, mark.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportJSON writes marks as an indented JSON array, with all their
+// metadata.
+func ExportJSON(w io.Writer, marks []Mark) error {
+	if marks == nil {
+		marks = []Mark{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(marks)
+}
+
+// ImportJSON reads marks written by ExportJSON.
+func ImportJSON(r io.Reader) ([]Mark, error) {
+	var marks []Mark
+	if err := json.NewDecoder(r).Decode(&marks); err != nil {
+		return nil, err
+	}
+	for index, mark := range marks {
+		if mark.Path == "" {
+			return nil, fmt.Errorf("mark %v has no path", index)
+		}
+		if !mark.Template && markdb.LooksLikeTemplate(mark.Path) {
+			// Exported before templated marks were flagged.
+			marks[index].Template = true
+		} else if !mark.Template && !filepath.IsAbs(mark.Path) {
+			return nil, fmt.Errorf("mark %v: path must be absolute: %v", index, mark.Path)
+		}
+		if mark.Name != "" {
+			if err := ValidateName(mark.Name); err != nil {
+				return nil, fmt.Errorf("mark %v: %v", index, err)
+			}
+		}
+	}
+	return marks, nil
+}
+
+// MergeMarks merges imported into marks. Imported marks that are already
+// marked have their metadata merged into the existing mark; the others
+// are returned as added, in order. A name already used by another mark is
+// dropped, reported in the returned warnings.
+func MergeMarks(marks []Mark, imported []Mark) (merged []Mark, added []Mark, warnings []error) {
+	merged = append([]Mark(nil), marks...)
+	for _, mark := range imported {
+		existing := -1
+		for index, other := range merged {
+			if other.Path == mark.Path {
+				existing = index
+				break
+			}
+		}
+		if err := CheckNameFree(merged, mark.Name, existing); err != nil {
+			warnings = append(warnings, fmt.Errorf("%v: %v, importing it without a name", mark.Path, err))
+			mark.Name = ""
+		}
+		if existing >= 0 {
+			merged[existing] = merged[existing].Merge(mark)
+			continue
+		}
+		merged = append(merged, mark)
+		added = append(added, mark)
+	}
+	return merged, added, warnings
+}
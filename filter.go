@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// Filter selects paths using include and exclude glob patterns. A path
+// matches when it matches any include pattern (or there are none) and
+// does not match any exclude pattern. Marks are also kept out of the
+// selection when pinned, with KeepPinned, or tagged with one of KeepTags.
+type Filter struct {
+	Include    []string
+	Exclude    []string
+	KeepPinned bool
+	KeepTags   []string
+}
+
+func (f Filter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0 && !f.KeepPinned && len(f.KeepTags) == 0
+}
+
+// MatchMark reports whether the filter selects mark.
+func (f Filter) MatchMark(mark Mark) bool {
+	if f.KeepPinned && mark.Pinned {
+		return false
+	}
+	for _, tag := range mark.Tags {
+		if slices.Contains(f.KeepTags, tag) {
+			return false
+		}
+	}
+	return f.Match(mark.Path)
+}
+
+func (f Filter) Match(path string) bool {
+	if len(f.Include) > 0 && !MatchesAny(path, f.Include) {
+		return false
+	}
+	return !MatchesAny(path, f.Exclude)
+}
+
+// AddFlags registers the --include and --exclude flags on flags.
+func (f *Filter) AddFlags(flags *flag.FlagSet) {
+	flags.Var((*stringList)(&f.Include), "include", "only match paths matching the glob")
+	flags.Var((*stringList)(&f.Exclude), "exclude", "skip paths matching the glob")
+}
+
+// AddKeepFlags registers the --keep-pinned and --keep-tag flags on flags.
+func (f *Filter) AddKeepFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&f.KeepPinned, "keep-pinned", false, "skip pinned marks")
+	flags.Var((*stringList)(&f.KeepTags), "keep-tag", "skip marks with the tag")
+}
+
+// MatchesAny reports whether path matches any of the glob patterns.
+func MatchesAny(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if MatchGlob(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob matches a path against a glob pattern. A pattern without a
+// separator, such as "node_modules", matches any component of the path,
+// except "~" which matches the home directory.
+// Otherwise the pattern is matched against the whole path, with "**"
+// matching any number of directories and a leading "~" expanding to the
+// home directory. Relative patterns such as "work/**" may match starting
+// at any directory.
+func MatchGlob(pattern string, path string) bool {
+	path = filepath.Clean(path)
+	if !strings.ContainsRune(pattern, '/') && pattern != "~" {
+		for _, component := range strings.Split(path, string(filepath.Separator)) {
+			if matched, _ := filepath.Match(pattern, component); matched {
+				return true
+			}
+		}
+		return false
+	}
+	pattern = markdb.ExpandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = "**/" + pattern
+	}
+	return matchComponents(splitPath(pattern), splitPath(path))
+}
+
+func matchComponents(pattern []string, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(path); skip++ {
+				if matchComponents(pattern[1:], path[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if matched, _ := filepath.Match(pattern[0], path[0]); !matched {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(filepath.ToSlash(path), "/"), "/")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// stringList is a flag.Value that collects every occurrence of a flag.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+// parseFlags parses flags that appear before, after or between the
+// positional arguments and returns the positional arguments. Negative
+// numbers such as "-1" are positional unless they are the value of a flag,
+// or a flag of that name, such as -0, is defined. Everything after "--" is
+// returned as is.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	if index := slices.Index(args, "--"); index >= 0 {
+		args, rest = args[:index], args[index+1:]
+	}
+	var positional []string
+	for len(args) > 0 {
+		end := len(args)
+		for index, arg := range args {
+			if isNegativeNumber(arg) && flags.Lookup(arg[1:]) == nil && (index == 0 || !isValueFlag(flags, args[index-1])) {
+				end = index
+				break
+			}
+		}
+		if err := flags.Parse(args[:end]); err != nil {
+			return nil, err
+		}
+		remaining := append(flags.Args(), args[end:]...)
+		if len(remaining) == 0 {
+			break
+		}
+		positional = append(positional, remaining[0])
+		args = remaining[1:]
+	}
+	return append(positional, rest...), nil
+}
+
+// flagWasSet reports whether the flag called name was given on the command
+// line.
+func flagWasSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func isNegativeNumber(arg string) bool {
+	_, err := strconv.Atoi(arg)
+	return err == nil && strings.HasPrefix(arg, "-")
+}
+
+// isValueFlag reports whether arg is a flag that consumes the following
+// argument as its value.
+func isValueFlag(flags *flag.FlagSet, arg string) bool {
+	if !strings.HasPrefix(arg, "-") || strings.Contains(arg, "=") {
+		return false
+	}
+	f := flags.Lookup(strings.TrimLeft(arg, "-"))
+	if f == nil {
+		return false
+	}
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !boolFlag.IsBoolFlag()
+}
+
+// optionalCount is a flag.Value for flags that may be given without a
+// value, such as --parent, meaning 1, or with a count, such as --parent=3.
+type optionalCount int
+
+func (c *optionalCount) String() string {
+	return strconv.Itoa(int(*c))
+}
+
+func (c *optionalCount) Set(value string) error {
+	if value == "true" {
+		*c = 1
+		return nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return errors.New("must be a positive number")
+	}
+	*c = optionalCount(count)
+	return nil
+}
+
+func (c *optionalCount) IsBoolFlag() bool {
+	return true
+}
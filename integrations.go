@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// WriteBrootVerbs writes broot verb definitions, in the hjson format of
+// broot's conf.hjson, to jump to each mark and to mark the directory
+// selected in broot. Marks are reached with "m<index>" or "m-<name>".
+func WriteBrootVerbs(w io.Writer, marks []Mark) {
+	fmt.Fprintln(w, "# Generated by `mark init broot`. Add these verbs to the verbs list in")
+	fmt.Fprintln(w, "# ~/.config/broot/conf.hjson and rerun after changing your marks.")
+	fmt.Fprintln(w, "verbs: [")
+	fmt.Fprintln(w, "    {")
+	fmt.Fprintln(w, `        invocation: "mark"`)
+	fmt.Fprintln(w, `        external: "mark add {directory}"`)
+	fmt.Fprintln(w, "        leave_broot: false")
+	fmt.Fprintln(w, "    }")
+	for index, mark := range marks {
+		if mark.IsDeleted() {
+			continue
+		}
+		invocations := []string{"m" + strconv.Itoa(index)}
+		if mark.Name != "" {
+			invocations = append(invocations, "m-"+mark.Name)
+		}
+		for _, invocation := range invocations {
+			fmt.Fprintln(w, "    {")
+			fmt.Fprintf(w, "        invocation: %v\n", strconv.Quote(invocation))
+			fmt.Fprintf(w, "        execution: %v\n", strconv.Quote(":focus "+mark.Path))
+			fmt.Fprintln(w, "    }")
+		}
+	}
+	fmt.Fprintln(w, "]")
+}
+
+// OpenZellij opens a new zellij pane, or tab, whose working directory is
+// path. It must be run from inside a zellij session.
+func OpenZellij(path string, tab bool) error {
+	if os.Getenv("ZELLIJ") == "" {
+		return errors.New("not running inside a zellij session")
+	}
+	action := []string{"action", "new-pane", "--cwd", path}
+	if tab {
+		action = []string{"action", "new-tab", "--cwd", path}
+	}
+	command := exec.Command("zellij", action...)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("zellij %v: %v", strings.Join(action, " "), err)
+	}
+	return nil
+}
+
+// errPickCancelled is returned when the fzf selection is aborted.
+var errPickCancelled = errors.New("nothing picked")
+
+// PickWithFzf lets the user choose one of lines with fzf, starting from
+// query, and returns the chosen line.
+func PickWithFzf(lines []string, query string) (string, error) {
+	if _, err := exec.LookPath("fzf"); err != nil {
+		return "", errors.New("fzf is not installed, see https://github.com/junegunn/fzf")
+	}
+	command := exec.Command("fzf", "--ansi", "--no-sort", "--select-1", "--exit-0", "--query", query, "--prompt", "mark> ")
+	command.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	command.Stderr = os.Stderr
+	output, err := command.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && (exitErr.ExitCode() == 1 || exitErr.ExitCode() == 130) {
+		return "", errPickCancelled
+	} else if err != nil {
+		return "", fmt.Errorf("fzf: %v", err)
+	}
+	return strings.TrimSuffix(string(output), "\n"), nil
+}
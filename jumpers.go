@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JumpDir is a directory in the database of another directory jumper.
+type JumpDir struct {
+	Path string
+	// Score is how often, and how recently, the directory was visited.
+	// Its scale is the jumper's own.
+	Score float64
+	// LastUsed is zero for jumpers that do not record it.
+	LastUsed time.Time
+}
+
+// jumper reads the database of another directory jumper, for import
+// --from.
+type jumper struct {
+	// DataFile returns where the jumper keeps its database by default.
+	DataFile func() (string, error)
+	Read     func(r io.Reader) ([]JumpDir, error)
+}
+
+// jumpers are the directory jumpers whose databases can be imported.
+var jumpers = map[string]jumper{
+	"autojump": {autojumpDataFile, ReadAutojump},
+	"fasd":     {fasdDataFile, ReadFasd},
+	"zoxide":   {zoxideDataFile, ReadZoxide},
+}
+
+// JumpMarks returns marks for dirs, most recently used first or, with
+// byScore, highest scored first. Directories used equally recently, as all
+// are for jumpers that do not record it, are ordered by score.
+func JumpMarks(dirs []JumpDir, byScore bool) []Mark {
+	dirs = slices.Clone(dirs)
+	slices.SortStableFunc(dirs, func(a, b JumpDir) int {
+		if byScore {
+			return cmp.Compare(b.Score, a.Score)
+		}
+		return cmp.Or(b.LastUsed.Compare(a.LastUsed), cmp.Compare(b.Score, a.Score))
+	})
+	marks := make([]Mark, 0, len(dirs))
+	for _, dir := range dirs {
+		// The jumper does not know when the directory was first visited,
+		// the last visit is as close as it gets.
+		marks = append(marks, Mark{Path: dir.Path, Created: dir.LastUsed, LastUsed: dir.LastUsed})
+	}
+	return marks
+}
+
+// zoxideDataFile returns the location of zoxide's db.zo, in $_ZO_DATA_DIR
+// or else the platform's local data directory.
+func zoxideDataFile() (string, error) {
+	if dir := os.Getenv("_ZO_DATA_DIR"); dir != "" {
+		return filepath.Join(dir, "db.zo"), nil
+	}
+	dir, err := localDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "zoxide", "db.zo"), nil
+}
+
+// localDataDir returns where applications keep their data on this
+// platform: $XDG_DATA_HOME or ~/.local/share, ~/Library/Application
+// Support on macOS and %LOCALAPPDATA% on Windows.
+func localDataDir() (string, error) {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return dir, nil
+		}
+		return "", errors.New("%LOCALAPPDATA% is not set")
+	case "darwin":
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(homeDir, "Library", "Application Support"), nil
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".local", "share"), nil
+}
+
+// zoxideVersion is the version of the zoxide database format ReadZoxide
+// reads, that of zoxide 0.8 and later.
+const zoxideVersion = 3
+
+// ReadZoxide reads a zoxide database: a little-endian uint32 version
+// followed by the directories in bincode, a uint64 count and, for each,
+// the path as a uint64 length and bytes, the score as a float64 and the
+// last access as uint64 seconds since the epoch.
+func ReadZoxide(r io.Reader) ([]JumpDir, error) {
+	reader := bufio.NewReader(r)
+	var version uint32
+	if err := binary.Read(reader, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("not a zoxide database: %v", err)
+	}
+	if version != zoxideVersion {
+		return nil, fmt.Errorf("not a zoxide database, or one of an unsupported version (%v), which running zoxide 0.8 or later once converts", version)
+	}
+	var count uint64
+	if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("truncated zoxide database: %v", err)
+	}
+	var dirs []JumpDir
+	for range count {
+		var length uint64
+		if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+			return nil, fmt.Errorf("truncated zoxide database: %v", err)
+		}
+		if length > math.MaxInt32 {
+			return nil, fmt.Errorf("corrupt zoxide database: path of %v bytes", length)
+		}
+		path := make([]byte, length)
+		if _, err := io.ReadFull(reader, path); err != nil {
+			return nil, fmt.Errorf("truncated zoxide database: %v", err)
+		}
+		var entry struct {
+			Score        float64
+			LastAccessed uint64
+		}
+		if err := binary.Read(reader, binary.LittleEndian, &entry); err != nil {
+			return nil, fmt.Errorf("truncated zoxide database: %v", err)
+		}
+		dir := JumpDir{Path: string(path), Score: entry.Score}
+		if entry.LastAccessed > 0 {
+			dir.LastUsed = time.Unix(int64(entry.LastAccessed), 0)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// autojumpDataFile returns the location of autojump's autojump.txt.
+func autojumpDataFile() (string, error) {
+	var dir string
+	switch runtime.GOOS {
+	case "windows":
+		dir = os.Getenv("APPDATA")
+		if dir == "" {
+			return "", errors.New("%APPDATA% is not set")
+		}
+	case "darwin":
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(homeDir, "Library")
+	default:
+		var err error
+		if dir, err = localDataDir(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "autojump", "autojump.txt"), nil
+}
+
+// ReadAutojump reads autojump's database, lines of a weight and a path
+// separated by a tab. autojump does not record when directories were
+// used.
+func ReadAutojump(r io.Reader) ([]JumpDir, error) {
+	var dirs []JumpDir
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		weight, path, ok := strings.Cut(scanner.Text(), "\t")
+		score, err := strconv.ParseFloat(weight, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("line %v: expected a weight and a path separated by a tab", line)
+		}
+		dirs = append(dirs, JumpDir{Path: path, Score: score})
+	}
+	return dirs, scanner.Err()
+}
+
+// fasdDataFile returns the location of fasd's database, $_FASD_DATA or
+// ~/.fasd.
+func fasdDataFile() (string, error) {
+	if file := os.Getenv("_FASD_DATA"); file != "" {
+		return file, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".fasd"), nil
+}
+
+// ReadFasd reads fasd's database, lines of a path, a rank and the last
+// access in seconds since the epoch separated by "|". fasd tracks files
+// as well as directories.
+func ReadFasd(r io.Reader) ([]JumpDir, error) {
+	var dirs []JumpDir
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		// The path may itself contain "|", the rank and time may not.
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %v: expected a path, a rank and a time separated by |", line)
+		}
+		path := strings.Join(fields[:len(fields)-2], "|")
+		rank, err := strconv.ParseFloat(fields[len(fields)-2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid rank %q", line, fields[len(fields)-2])
+		}
+		seconds, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid time %q", line, fields[len(fields)-1])
+		}
+		dir := JumpDir{Path: path, Score: rank}
+		if seconds > 0 {
+			dir.LastUsed = time.Unix(seconds, 0)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, scanner.Err()
+}
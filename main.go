@@ -1,302 +1,3323 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"maps"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// Mark and MarkDB are those of the markdb package, which stores the marks.
+type (
+	Mark   = markdb.Mark
+	MarkDB = markdb.MarkDB
 )
 
-type MarkDB interface {
-	Get(index int) (string, error)
-	Add(path string) error
-	List() ([]string, error)
-	Clear() error
-	Delete(index int) error
+// GetLocalMarkFile returns the default location of the local db:
+// $MARK_HOME/.mark, ~/.mark, $XDG_DATA_HOME/mark/marks or, as a last
+// resort for environments without a home directory such as minimal
+// containers, a directory in /tmp with a warning.
+func GetLocalMarkFile() (string, error) {
+	if markHome := os.Getenv("MARK_HOME"); markHome != "" {
+		return filepath.Join(markHome, ".mark"), nil
+	}
+	homeDir, homeErr := os.UserHomeDir()
+	if homeErr == nil {
+		return filepath.Join(homeDir, ".mark"), nil
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "mark", "marks"), nil
+	}
+	// Windows has no uids, but its temporary directory is per user.
+	tempDir := filepath.Join(os.TempDir(), "mark")
+	if uid := os.Getuid(); uid >= 0 {
+		tempDir = fmt.Sprintf("%v-%v", tempDir, uid)
+	}
+	if err := os.MkdirAll(tempDir, 0700); err != nil {
+		return "", fmt.Errorf("cannot find a directory to store the marks in (%v, %v): set $HOME or $MARK_HOME, or db_file in the config", homeErr, err)
+	}
+	tempDirWarning.Do(func() {
+		fmt.Fprintf(os.Stderr, "warning: %v, storing the marks in %v which may be cleared. Set $HOME or $MARK_HOME, or db_file in the config, to keep them.\n", homeErr, tempDir)
+	})
+	return filepath.Join(tempDir, ".mark"), nil
+}
+
+var tempDirWarning sync.Once
+
+type MarkCli struct {
+	db     MarkDB
+	config *Config
+	mounts []MountInfo
+	// commands are the names of the commands, for the completions.
+	commands []string
+	// pager is the running pager, closed before exiting on an error.
+	pager *Pager
+}
+
+func NewMarkCli(db MarkDB, config *Config) (*MarkCli, error) {
+	return &MarkCli{db: db, config: config}, nil
+}
+
+// NewMarkCliWithLocalDB loads the config and opens the backends it names,
+// the local db unless configured otherwise.
+func NewMarkCliWithLocalDB() (*MarkCli, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewMarkCliFromConfig(config)
+}
+
+// NewMarkCliFromConfig opens the backends config names.
+func NewMarkCliFromConfig(config *Config) (*MarkCli, error) {
+	db, err := OpenConfiguredDB(config)
+	if err != nil {
+		return nil, err
+	}
+	mark, err := NewMarkCli(db, config)
+	if err != nil {
+		return nil, err
+	}
+	return mark, nil
+}
+
+// switchUser keeps root from writing root owned files into another user's
+// db, which that user could then no longer change. With --user, root uses
+// the marks of that user and gives them the files it creates. Without it,
+// root uses its own marks even under sudo, where $HOME may still be the
+// home directory of the user who ran sudo.
+func switchUser(name string) error {
+	if name != "" {
+		owner, err := markdb.LookupOwner(name)
+		if err != nil {
+			return err
+		}
+		if os.Geteuid() != 0 && os.Geteuid() != owner.UID {
+			return fmt.Errorf("only root can use the marks of %v", name)
+		}
+		markdb.RunAs(owner, os.Geteuid() == 0)
+		return nil
+	}
+	sudoUser := markdb.SudoUser()
+	if sudoUser == "" {
+		return nil
+	}
+	root, err := markdb.LookupOwner("root")
+	if err != nil {
+		return err
+	}
+	if home, _ := os.UserHomeDir(); home != root.HomeDir {
+		fmt.Fprintf(os.Stderr, "mark: running through sudo, using root's marks. Use mark --user %v for the marks of %v.\n", sudoUser, sudoUser)
+	}
+	markdb.RunAs(root, false)
+	return nil
+}
+
+func (m *MarkCli) DisplayHelp(args []string) {
+	fmt.Print(`
+Marks the current location.
+If no command is specified, the current working directory is saved to the mark db.
+
+Usage:
+	mark [--timeout <duration>] [--ephemeral] [--dry-run] [--non-interactive] [--user <name>] [--profile <name>] [command]
+
+	--timeout <duration>  Fail when storage does not respond in time, e.g. 2s (default: timeout setting)
+	--ephemeral           Keep the marks in memory only, never writing to disk (or MARK_EPHEMERAL=1)
+	--dry-run             Print what add, delete, clear, prune or import would change without changing
+	                      anything; it may also be given after the command
+	--non-interactive     Never prompt, color or page, and print errors on a single line
+	                      (default when stdin is not a terminal, or MARK_NON_INTERACTIVE=1)
+	--user <name>         As root, use the marks of the user, keeping their files owned by them.
+	                      Otherwise root, even through sudo, uses its own marks
+	--profile <name>      Use the marks of the profile (or MARK_PROFILE, default: the one chosen with use)
+
+Available Commands:
+	help            Displays help menu
+	add    [path]   Adds the current working directory, or path, to mark db (default action, see default_command).
+	                The path may start with ~ or be relative to the current directory
+	                  --logical     Use $PWD and path as given, keeping symlinks in the path
+	                  --parent[=n]  Add the nth parent directory instead (default 1)
+	                  --name <name> Name the mark
+	                  --tag <tag>   Tag the mark, may be repeated
+	                  --note <note> Describe the mark
+	                  --pin         Pin the mark to its index
+	                  --force       Mark the directory even if the deny setting matches it
+	                  --replace-descendants  Delete the marks beneath the directory
+	                  --keep-both   Keep the marks beneath the directory (default, see on_nested)
+	                  --at <index>  Insert the mark at the index instead of the top, -1 being the end
+	                  --ttl <age>   Move the mark to the trash after age, such as 2h or 3d
+	back   <index>  Prints out the number of directories back based on the index provided
+	back   <name>   Prints out the nearest parent directory named name, or else starting with, containing or fuzzily matching it
+	backup [file]   Snapshots the marks, with their metadata, to file or the backups directory
+	                  --list  List the backups instead
+	bench           Measures add, get, list and delete against throwaway dbs of each backend
+	                  --size <n>        Number of marks to seed the db with, may be repeated (default 10, 100, 1000)
+	                  --ops <n>         Number of operations to measure (default 100)
+	                  --backend <name>  Only benchmark this backend
+	clone  <mark>   Adds a new mark with the tags, note, color and pin of the mark
+	                  --path-suffix <dir>  Mark this directory beneath the mark
+	                  --name <name>  Name the new mark
+	                  --tag <tag>    Add a tag, may be repeated
+	                  --note <note>  Replace the note
+	clear           Clears out the paths in the mark db, moving them to the trash
+	                  --include <glob>  Only clear paths matching the glob
+	                  --exclude <glob>  Keep paths matching the glob
+	                  --keep-pinned     Keep the pinned marks
+	                  --keep-tag <tag>  Keep the marks with the tag, may be repeated
+	                  -f, --force       Clear without asking for confirmation
+	down   <pattern> Prints out the best matching subdirectory beneath the current directory
+	                  --depth <n>  Maximum number of directories to descend (default 4)
+	config [setting] Prints the settings in effect, including the defaults, or the value of one
+	color  <mark> <color> Sets the color the mark is listed in, or none to remove it
+	completion <shell>  Prints the completion script for bash, zsh or fish, completing
+	                the commands, the indexes and names of the marks and, for back and up,
+	                the names of the parent directories
+	current         Prints the name, or index, of the mark containing the current directory
+	                  --format <format>  Output format using {index}, {name}, {label}, {path} and {short}
+	delete <mark>...  Deletes out the paths in mark db based on the marks provided, moving them to the trash
+	                until they are purged after the purge_deleted_after setting; adding them again restores them.
+	                Ranges of indexes such as 3-8 may be given, all resolved before any is deleted
+	                  --path <path>  Delete the mark of exactly this path instead
+	                  --name <name>  Delete the mark with exactly this name instead
+	                  -i, --interactive  Ask about each of the marks given, or all of them, and delete
+	                                     those chosen together once confirmed
+	events          Prints the marks added, removed and jumped to, as JSON lines
+	                  -f, --follow  Keep printing the events as they happen
+	export          Writes all the marks, with their metadata, to stdout
+	                  --format <format>  Output format: json (default), csv, yaml or plain paths
+	                  --output <file>    Write to the file instead
+	flush           Replays the writes queued while a remote backend was unavailable
+	gc              Trashes the marks whose --ttl is up, archives the marks unused for longer than the
+	                auto_archive_after setting and purges the marks deleted longer ago than the
+	                purge_deleted_after setting
+	                  --dry-run  Print the marks that would be trashed, archived and purged
+	get    <mark>[/subpath] Get the path in mark db based on the mark provided
+	                  --no-check  Do not check that the directory exists
+	                  --quote     Quote the path for the shell
+	                  --all       Print the paths of all the marks, without decoration
+	                  --null, -z, -0  End paths with NUL instead of a newline, for xargs -0 and fzf --read0
+	list            List out the all the marked paths by index
+	                  --absolute  Print absolute paths instead of shortening them to ~ and roots
+	                  --by-tag    Group the marks under their tags
+	                  --tree      Group the marks under the directories they share
+	                  --long      Also print the ids of the marks and when they were created and last jumped to
+	                  --archived  List the archived marks instead
+	                  --deleted   List the deleted marks awaiting their purge instead
+	                  --sort <order>  List by index, name, path, recent or created (default: sort setting)
+	                  --tag <tag>  Only list the marks with the tag, may be repeated
+	                  --no-pager   Do not page long output through $PAGER
+	                  --no-color   Do not color the output (or NO_COLOR, see the color setting)
+	                  --paths-only  Print only the absolute paths, without decoration
+	                  -0, -z, --null  Print only the absolute paths, each ended with NUL instead of a newline
+	exec   <mark> -- <command>...  Runs the command in the directory of the mark, exiting with its status
+	exists <mark>   Exits with status 0 if the index or path is marked, 1 otherwise
+	                  --dir  Also require the marked directory to exist
+	import <file>   Merges the marks exported to file (- for stdin) into the marks
+	                  --replace  Replace the marks instead
+	                  --from <jumper>  Import the directories of the database of zoxide, autojump
+	                                   or fasd, at file if given
+	                  --by-score       With --from, order them by the jumper's score rather than last use
+	init   <shell>  Prints the move, back, up and down functions, for eval "$(mark init bash)" (bash, zsh, fish, powershell)
+	init   broot    Prints broot verbs to jump to the marks from inside broot
+	install [shell] Prints out directions to create move, back, up and down commands in your .bashrc,
+	                or with zsh in your .zshrc along with a fzf jump widget, or with fish
+	                in your config.fish along with completions, or with powershell in your $PROFILE
+	                  --key <key>   The key the zsh widget is bound to (default: zsh_jump_key or ^G)
+	migrate-legacy  Merges the legacy ~/.mark file into the db set with db_file and
+	                renames it to ~/.mark.migrated-<time>
+	                  --dry-run  Print what would be merged
+	relink <mark> [dir] Points the mark at the directory it moved to, confirmed by its device and inode
+	                  --depth <n>  Maximum number of directories to descend when searching (default 4)
+	                  --force      Relink to dir even if it cannot be confirmed to be the same directory
+	pick   [query]  Chooses a mark with fzf and prints its path
+	                  --quote  Quote the path for the shell
+	rel    <mark> <path> Prints the path relative to the mark
+	                  --abs  Print the path, relative to the mark, as an absolute path instead
+	rename <mark> <name> Names the mark, replacing its name, and keeps the rest of it
+	report          Summarises the jumps recorded with the usage_journal setting
+	                  --top <n>  Number of most used marks to show (default 10)
+	topN   [n]      Ranks the n (default 10) marks jumped to the most, from the usage journal
+	                  --window <window>  Count the jumps of the last week, month (default), all or e.g. 14d
+	restore [file]  Replaces the marks with those of the newest backup, or of file, backing up
+	                the marks replaced first
+	resolve-old <index> Prints the mark that had index before the marks were last renumbered
+	scan   <dir>    Marks every directory beneath dir containing a .markrc file
+	                  --monorepo          Mark the services and packages detected beneath dir instead,
+	                                      as <namespace>/<package>, deleting the ones that are gone
+	                  --namespace <name>  The namespace (default: the name of dir)
+	search <text>   Lists the marks whose path, name, note or tags contain text, ignoring case,
+	                exiting with status 1 when none does
+	                  --regex     Match text as a regular expression instead
+	                  --archived  Also search the archived marks
+	                  --absolute  Print absolute paths
+	profile list    Lists the profiles, starring the one in use
+	profile create <name> Creates a profile, a separate set of marks in a db of its own
+	profile delete <name> Deletes a profile and its marks
+	                  --force  Do not ask for confirmation
+	serve           Serves the marks over HTTP to other machines using the remote backend
+	                  --listen <address>  The address to listen on (default :8745)
+	                  --token <token>     The token clients must send (default: $MARK_SERVE_TOKEN)
+	session save <name> <mark>... Saves the marks, in order, as a session
+	session open <name> Opens a tmux window (or zellij tab) for each mark of the session
+	                  --print  Print the tmux commands instead
+	session list    Lists the sessions
+	session delete <name> Deletes a session
+	setup           Interactively sets up the shell functions and the config file
+	mount  <mark>   Runs the mount_command setting to mount the volume the mark is on
+	prune           Deletes the paths that no longer exist, moving them to the trash
+	                  --include <glob>  Only prune paths matching the glob
+	                  --exclude <glob>  Never prune paths matching the glob
+	sync            Commits the db to the git repository it is kept in, pulls the marks changed
+	                on other machines, merging them when both sides changed, and pushes the result
+	tag    <mark> <tag>... Adds the tags to the mark
+	                  --remove  Remove the tags instead
+	tag             Lists the tags in use
+	top    <mark>   Moves the mark to the top of the list
+	pin    <mark>   Pins the mark to its index, which it keeps when marks are added or moved to the top
+	                  --remove  Unpin the mark instead
+	move-to <mark> <index> Moves the mark to the index, shifting the marks in between
+	swap   <mark> <mark> Swaps the places of the two marks
+	suggest [count] Lists frequently visited directories that are not marked
+	trash list      Lists the deleted marks, most recently deleted first, with when they will be purged
+	                  --absolute  Print absolute paths
+	trash restore <n>... Restores the marks numbered n in trash list to the top of the list
+	trash empty     Purges the deleted marks now
+	ui              Browses, reorders, deletes, renames and jumps to marks in a terminal UI
+	                  --quote  Quote the path jumped to for the shell
+	unarchive <mark> Restores an archived mark to the top of the list
+	undo            Reverts the last command that changed the marks, repeatedly up to the last 10
+	up     [n|name] Prints out the nth parent directory (default 1), or the one matching name as back does
+	use    <profile> Uses the marks of the profile from now on, or of the default profile with "default"
+	vars            Lists the variables that can be used in templated paths, e.g. {arch}
+	visit           Records the current working directory as visited (used by the shell hook)
+	zellij <mark>   Opens a zellij pane in the marked directory
+	                  --tab  Open a new tab instead of a pane
+
+Commands that renumber the marks print the old and new indexes with
+--show-remap, or always with the show_remap setting.
+
+A <mark> is an index (negative indexes count from the end, e.g. -1 is the
+oldest mark), @ and the id list --long prints, or the start of it, the name
+given with add --name, a marked path, or a query matched against the marked
+paths, taking the best match.
+`)
+}
+
+func (m *MarkCli) Back(args []string) {
+	cwd, err := os.Getwd()
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("invalid number of args"))
+	}
+	index, err := strconv.Atoi(args[0])
+	if err != nil {
+		ancestor, err := FindAncestor(cwd, args[0])
+		m.handleError(err)
+		fmt.Println(ancestor)
+		return
+	}
+	// Ancestors goes up with filepath.Dir, which stops at the root of the
+	// volume, such as C:\ or \\server\share\ on Windows.
+	ancestors := append([]string{filepath.Clean(cwd)}, Ancestors(cwd)...)
+	if index < 0 || index >= len(ancestors) {
+		m.handleError(errors.New("invalid index"))
+	}
+	fmt.Println(ancestors[index])
+}
+
+// Up prints the nth parent directory, the parent by default, or the parent
+// matching a name as back does.
+func (m *MarkCli) Up(args []string) {
+	if len(args) > 1 {
+		m.handleError(errors.New("invalid number of args"))
+	}
+	if len(args) == 0 {
+		args = []string{"1"}
+	}
+	m.Back(args)
+}
+
+func (m *MarkCli) Down(args []string) {
+	flags := newFlagSet("down")
+	depth := flags.Int("depth", 4, "maximum number of directories to descend")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	cwd, err := os.Getwd()
+	m.handleError(err)
+	path, err := FindDescendant(cwd, args[0], *depth, m.config.Exclude)
+	m.handleError(err)
+	fmt.Println(path)
+}
+
+func (m *MarkCli) List(args []string) {
+	flags := newFlagSet("list")
+	absolute := flags.Bool("absolute", false, "print absolute paths")
+	byTag := flags.Bool("by-tag", false, "group the marks under their tags")
+	archived := flags.Bool("archived", false, "list the archived marks instead")
+	deleted := flags.Bool("deleted", false, "list the deleted marks awaiting their purge instead")
+	pathsOnly := flags.Bool("paths-only", false, "print only the absolute paths")
+	long := flags.Bool("long", false, "also print when the marks were created and last used")
+	tree := flags.Bool("tree", false, "group the marks under the directories they share")
+	var tags []string
+	flags.Var((*stringList)(&tags), "tag", "only list the marks with the tag")
+	noPager := flags.Bool("no-pager", false, "do not page the output")
+	noColor := flags.Bool("no-color", false, "do not color the output")
+	sort := flags.String("sort", m.config.Sort, "the order to list the marks in")
+	var null bool
+	flags.BoolVar(&null, "z", false, "end the paths with NUL instead of a newline")
+	flags.BoolVar(&null, "0", false, "end the paths with NUL instead of a newline")
+	flags.BoolVar(&null, "null", false, "end the paths with NUL instead of a newline")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	if null {
+		// Only the paths make sense as NUL terminated records.
+		*pathsOnly = true
+	}
+	if *tree && (*byTag || *long || *pathsOnly) {
+		m.handleError(errors.New("--tree cannot be combined with --by-tag, --long or --paths-only"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	order, err := SortedIndexes(marks, *sort)
+	m.handleError(err)
+	listed := func(mark Mark) bool {
+		if *deleted {
+			return mark.IsDeleted() && mark.HasTags(tags)
+		}
+		return !mark.IsDeleted() && mark.Archived == *archived && mark.HasTags(tags)
+	}
+	if *pathsOnly {
+		var sorted []Mark
+		for _, index := range order {
+			sorted = append(sorted, marks[index])
+		}
+		m.handleError(writePaths(os.Stdout, m.expandPaths(slices.Collect(filterMarks(sorted, listed))), null))
+		return
+	}
+	if *noColor {
+		colorSetting = "never"
+	}
+	if command := PagerCommand(m.config); command != "" && !*noPager && stdoutIsTerminal {
+		pager, err := StartPager(command)
+		m.handleError(err)
+		m.pager = pager
+		defer m.closePager()
+	}
+	columns := m.markColumns(marks, slices.DeleteFunc(slices.Clone(order), func(index int) bool {
+		return !listed(marks[index])
+	}), *absolute)
+	format := func(index int) string {
+		line := m.formatColumns(index, marks[index], *absolute, columns)
+		if *long {
+			line = marks[index].ID() + "  " + FormatTimestamps(marks[index]) + "  " + line
+		}
+		return line
+	}
+	if *long && !*byTag {
+		fmt.Printf("%-8v  %-16v  %-16v  %v\n", "ID", "CREATED", "LAST USED", "MARK")
+	}
+	if *byTag {
+		m.listByTag(marks, order, listed, format)
+		return
+	}
+	if *tree {
+		m.listTree(marks, order, listed, *absolute)
+		return
+	}
+	for _, index := range order {
+		if !listed(marks[index]) {
+			continue
+		}
+		fmt.Println(format(index))
+	}
+}
+
+// Search lists the marks whose path, name, note or tags contain the given
+// text, ignoring case, or with --regex match a regular expression. It
+// exits with status 1 when no mark matches.
+func (m *MarkCli) Search(args []string) {
+	flags := newFlagSet("search")
+	useRegex := flags.Bool("regex", false, "match a regular expression instead")
+	absolute := flags.Bool("absolute", false, "print absolute paths")
+	archived := flags.Bool("archived", false, "also search the archived marks")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("specify the text to search for"))
+	}
+	match := func(s string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(args[0]))
+	}
+	if *useRegex {
+		pattern, err := regexp.Compile(args[0])
+		m.handleError(err)
+		match = pattern.MatchString
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	order, err := SortedIndexes(marks, m.config.Sort)
+	m.handleError(err)
+	var found []int
+	for _, index := range order {
+		mark := marks[index]
+		if !mark.IsDeleted() && (!mark.Archived || *archived) && mark.Matches(match) {
+			found = append(found, index)
+		}
+	}
+	if len(found) == 0 {
+		os.Exit(1)
+	}
+	columns := m.markColumns(marks, found, *absolute)
+	for _, index := range found {
+		fmt.Println(m.formatColumns(index, marks[index], *absolute, columns))
+	}
+}
+
+// formatMark formats a mark for list output, flagging marks whose
+// directory is missing or on an unmounted volume, in color when color
+// output is enabled. Unless absolute is set the path is shortened.
+func (m *MarkCli) formatMark(index int, mark Mark, absolute bool) string {
+	return m.formatColumns(index, mark, absolute, MarkColumns{})
+}
+
+// formatColumns is formatMark padding the mark to columns.
+func (m *MarkCli) formatColumns(index int, mark Mark, absolute bool, columns MarkColumns) string {
+	status := m.targetStatus(mark)
+	if !absolute {
+		mark.Path = ShortenPath(mark.Path, m.config.Roots)
+	}
+	return FormatListMark(index, mark, status, columns, MarkColor(mark, m.config.TagColors), ColorEnabled())
+}
+
+// targetStatus returns why the directory of mark cannot be used, such as
+// "missing", or "" when it can.
+func (m *MarkCli) targetStatus(mark Mark) string {
+	if m.mounts == nil {
+		m.mounts, _ = Mounts()
+	}
+	target, err := m.expandPath(mark)
+	if err != nil {
+		return "unresolved"
+	}
+	mark.Path = target
+	if err := CheckTarget(mark, m.mounts, m.stat); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// stat is os.Stat bounded by --timeout like the storage, since the
+// directory of a mark can be on a dead network mount too.
+func (m *MarkCli) stat(path string) (os.FileInfo, error) {
+	if db, ok := m.db.(*markdb.TimeoutMarkDB); ok {
+		return statWithin(db.Timeout(), path)
+	}
+	return os.Stat(path)
+}
+
+// statWithin is os.Stat giving up once timeout has passed, as stating a
+// directory on a dead network mount can hang. A zero timeout waits as
+// long as os.Stat does.
+func statWithin(timeout time.Duration, path string) (os.FileInfo, error) {
+	if timeout <= 0 {
+		return os.Stat(path)
+	}
+	type result struct {
+		info os.FileInfo
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		info, err := os.Stat(path)
+		done <- result{info, err}
+	}()
+	select {
+	case result := <-done:
+		return result.info, result.err
+	case <-time.After(timeout):
+		return nil, &os.PathError{Op: "stat", Path: path, Err: context.DeadlineExceeded}
+	}
+}
+
+// markColumns returns the columns fitting the marks at indexes.
+func (m *MarkCli) markColumns(marks []Mark, indexes []int, absolute bool) MarkColumns {
+	var columns MarkColumns
+	for _, index := range indexes {
+		path := marks[index].Path
+		if !absolute {
+			path = ShortenPath(path, m.config.Roots)
+		}
+		columns.Fit(index, path)
+	}
+	return columns
+}
+
+// expandPath returns the path of mark, with the placeholders of a templated
+// path replaced by their values.
+func (m *MarkCli) expandPath(mark Mark) (string, error) {
+	if !mark.Template {
+		return mark.Path, nil
+	}
+	return markdb.ExpandTemplate(mark.Path, m.config.Vars)
+}
+
+// expandPaths returns the paths of marks with their placeholders replaced,
+// skipping, with a warning, the paths that cannot be expanded.
+func (m *MarkCli) expandPaths(marks []Mark) []string {
+	var paths []string
+	for _, mark := range marks {
+		path, err := m.expandPath(mark)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// listTree prints the listed marks as a tree of the directories they
+// share.
+func (m *MarkCli) listTree(marks []Mark, order []int, listed func(Mark) bool, absolute bool) {
+	var indexes []int
+	var paths []string
+	for _, index := range order {
+		if !listed(marks[index]) {
+			continue
+		}
+		path := marks[index].Path
+		if !absolute {
+			path = ShortenPath(path, m.config.Roots)
+		}
+		indexes = append(indexes, index)
+		paths = append(paths, path)
+	}
+	WriteTree(os.Stdout, BuildTree(indexes, paths), func(index int, name string) string {
+		mark := marks[index]
+		status := m.targetStatus(mark)
+		mark.Path = name
+		return FormatListMark(index, mark, status, MarkColumns{}, MarkColor(mark, m.config.TagColors), ColorEnabled())
+	})
+}
+
+// listByTag prints the marks grouped under a header for each tag. Marks
+// with several tags appear under each of them and untagged marks are
+// listed last.
+func (m *MarkCli) listByTag(marks []Mark, order []int, listed func(Mark) bool, format func(index int) string) {
+	groups := map[string][]int{}
+	var untagged []int
+	for _, index := range order {
+		mark := marks[index]
+		if !listed(mark) {
+			continue
+		}
+		if len(mark.Tags) == 0 {
+			untagged = append(untagged, index)
+		}
+		for _, tag := range mark.Tags {
+			groups[tag] = append(groups[tag], index)
+		}
+	}
+	tags := slices.Sorted(maps.Keys(groups))
+	printGroup := func(header string, indexes []int) {
+		fmt.Println(header)
+		for _, index := range indexes {
+			fmt.Printf("  %v\n", format(index))
+		}
+	}
+	for _, tag := range tags {
+		printGroup("#"+tag, groups[tag])
+	}
+	if len(untagged) > 0 {
+		printGroup("untagged", untagged)
+	}
+}
+
+func (m *MarkCli) Add(args []string) {
+	flags := newFlagSet("add")
+	logical := flags.Bool("logical", m.config.Logical, "use $PWD instead of resolving symlinks")
+	var parent optionalCount
+	flags.Var(&parent, "parent", "mark the nth parent directory instead")
+	var mark Mark
+	flags.StringVar(&mark.Name, "name", "", "name of the mark")
+	flags.Var((*stringList)(&mark.Tags), "tag", "tag the mark")
+	flags.StringVar(&mark.Note, "note", "", "note describing the mark")
+	flags.BoolVar(&mark.Pinned, "pin", false, "pin the mark")
+	force := flags.Bool("force", false, "mark the directory even if the deny setting matches it")
+	replaceDescendants := flags.Bool("replace-descendants", m.config.ReplaceDescendants, "delete the marks beneath the directory")
+	keepBoth := flags.Bool("keep-both", false, "keep the marks beneath the directory")
+	at := flags.Int("at", 0, "insert the mark at the index instead of the top")
+	ttl := flags.String("ttl", "", "move the mark to the trash after this long, such as 2h")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if flagWasSet(flags, "ttl") {
+		age, err := ParseAge(*ttl)
+		if err == nil && age == 0 {
+			err = fmt.Errorf("invalid duration %q", *ttl)
+		}
+		m.handleError(err)
+		mark.Expires = time.Now().Add(age)
+	}
+	if len(args) > 1 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	if *keepBoth {
+		if flagWasSet(flags, "replace-descendants") {
+			m.handleError(errors.New("--keep-both and --replace-descendants cannot be combined"))
+		}
+		*replaceDescendants = false
+	}
+	var path, template string
+	if len(args) == 1 && markdb.LooksLikeTemplate(args[0]) {
+		// Templated paths are stored as given and checked against their
+		// value on this machine.
+		template = args[0]
+		if parent > 0 {
+			m.handleError(errors.New("--parent cannot be used with a templated path"))
+		}
+		path, err = markdb.ExpandTemplate(template, m.config.Vars)
+		m.handleError(err)
+		if !filepath.IsAbs(path) {
+			m.handleError(fmt.Errorf("%v must expand to an absolute path, not %v", template, path))
+		}
+	} else if len(args) == 1 {
+		path, err = canonicalDir(args[0], *logical)
+		m.handleError(err)
+	} else {
+		path, err = workingDir(*logical)
+		m.handleError(err)
+	}
+	if len(args) == 1 {
+		info, err := os.Stat(path)
+		m.handleError(err)
+		if !info.IsDir() {
+			m.handleError(fmt.Errorf("not a directory: %v", path))
+		}
+	}
+	if parent > 0 {
+		ancestors := Ancestors(path)
+		if int(parent) > len(ancestors) {
+			m.handleError(errors.New("invalid parent"))
+		}
+		path = ancestors[parent-1]
+	}
+	if !*force && MatchesAny(path, m.config.Deny) {
+		if !m.config.DenyWarn {
+			m.handleError(fmt.Errorf("refusing to mark %v, it matches the deny setting (use --force to mark it anyway)", path))
+		}
+		fmt.Fprintf(os.Stderr, "warning: %v matches the deny setting\n", path)
+	}
+	if rcMark, ok, err := ReadMarkRC(path); err != nil {
+		m.handleError(err)
+	} else if ok {
+		mark = rcMark.Merge(mark)
+	}
+	if template != "" {
+		path = template
+		mark.Template = true
+	}
+	mark.Path = path
+	mark.Created = time.Now()
+	if template == "" {
+		RecordFileID(&mark, path)
+	}
+	if mounts, err := Mounts(); err == nil && template == "" {
+		if mount, ok := MountOf(path, mounts); ok && mount.Point != "/" {
+			mark.MountPoint, mark.MountSource = mount.Point, mount.Source
+		}
+	}
+	if flagWasSet(flags, "name") {
+		m.handleError(ValidateName(mark.Name))
+	}
+	for _, tag := range mark.Tags {
+		m.handleError(ValidateTag(tag))
+	}
+
+	m.trashExpired(false)
+	marks, err := m.db.List()
+	if errors.Is(err, ErrUnavailable) {
+		// No backend can be reached: the mark is queued to be added once
+		// one is, where the queue merges it with a mark of the same path.
+		if *at < 0 {
+			m.handleError(fmt.Errorf("%w, a negative --at needs the marks", err))
+		}
+		m.handleError(m.db.Insert(*at, mark))
+		return
+	}
+	m.handleError(err)
+	existing := slices.IndexFunc(marks, func(other Mark) bool { return other.Path == path })
+	m.handleError(CheckNameFree(marks, mark.Name, existing))
+	// A mark already in the list takes up one of the places it can move to.
+	places := liveLength(marks) + 1
+	if existing >= 0 && !marks[existing].IsDeleted() {
+		places--
+	}
+	position, err := resolveIndex(places, *at)
+	if err != nil {
+		m.handleError(fmt.Errorf("invalid index %v, the mark can be added at 0 to %v, or -%v to -1", *at, places-1, places))
+	}
+	if existing < 0 {
+		var descendants []int
+		nearest := -1
+		for index, other := range marks {
+			if other.IsDeleted() {
+				continue
+			}
+			if isWithin(path, other.Path) {
+				if nearest < 0 || len(other.Path) > len(marks[nearest].Path) {
+					nearest = index
+				}
+			} else if isWithin(other.Path, path) {
+				descendants = append(descendants, index)
+				if !*replaceDescendants {
+					fmt.Fprintf(os.Stderr, "note: %v is inside %v, use --replace-descendants to delete it\n", FormatMark(index, other), path)
+				}
+			}
+		}
+		if nearest >= 0 {
+			fmt.Fprintf(os.Stderr, "note: %v is inside %v\n", path, FormatMark(nearest, marks[nearest]))
+		}
+		if *replaceDescendants && len(descendants) > 0 {
+			for _, index := range descendants {
+				fmt.Printf("replaced %v\n", marks[index].Path)
+			}
+			m.handleError(m.db.DeleteMany(descendants))
+			position = min(position, places-1-len(descendants))
+		}
+		m.handleError(m.db.Insert(position, mark))
+		m.evictOverflow()
+		m.keepPinned(marks)
+		return
+	}
+	restored := marks[existing]
+	if restored.IsDeleted() && CheckNameFree(marks, restored.Name, existing) != nil {
+		fmt.Printf("restoring the deleted mark without its name, %q is now used by another mark.\n", restored.Name)
+		restored.Name = ""
+	}
+	merged := restored.Merge(mark)
+	merged.LastUsed = time.Now()
+	merged.Archived = false
+	merged.Deleted = time.Time{}
+	if merged.IsExpired() {
+		// Marking the path again makes it last, unless given a new time to
+		// live.
+		merged.Expires = time.Time{}
+	}
+	if restored.Pinned && !restored.IsDeleted() && !flagWasSet(flags, "at") {
+		fmt.Printf("path already exists. Keeping it pinned at index %v.\n", existing)
+		m.handleError(m.db.Update(existing, merged))
+		return
+	}
+	if position == 0 {
+		fmt.Println("path already exists. Moving to top.")
+	} else {
+		fmt.Printf("path already exists. Moving to index %v.\n", position)
+	}
+	m.handleError(m.db.Update(existing, merged))
+	m.handleError(m.db.Move(existing, position))
+	if restored.IsDeleted() {
+		m.evictOverflow()
+	}
+	m.keepPinned(marks, path)
+}
+
+// GC moves the marks whose time to live is up to the trash, archives the
+// marks that have not been used for longer than the auto_archive_after
+// setting, and purges the marks deleted longer ago than the
+// purge_deleted_after setting. Archived marks are moved to the end of the
+// list, before the deleted ones, so the indexes of the active marks stay
+// contiguous.
+func (m *MarkCli) GC(args []string) {
+	flags := newFlagSet("gc")
+	dryRun := flags.Bool("dry-run", false, "print the marks that would be trashed, archived and purged")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	expired := m.trashExpired(*dryRun)
+	if m.config.AutoArchiveAfter == 0 && m.config.PurgeDeletedAfter == 0 {
+		if expired == 0 {
+			fmt.Println("auto_archive_after and purge_deleted_after are not set, nothing to do.")
+		}
+		return
+	}
+	if m.config.AutoArchiveAfter > 0 {
+		m.archiveUnused(*dryRun)
+	}
+	if m.config.PurgeDeletedAfter > 0 {
+		m.purgeDeleted(*dryRun)
+	}
+}
+
+func (m *MarkCli) archiveUnused(dryRun bool) {
+	marks, err := m.db.List()
+	m.handleError(err)
+	now := time.Now()
+	var archive []int
+	for index, mark := range marks {
+		switch {
+		case mark.Archived || mark.Pinned || mark.IsDeleted():
+		case mark.LastActive().IsZero():
+			// Marks stored before timestamps were recorded start
+			// aging now.
+			if !dryRun {
+				mark.LastUsed = now
+				m.handleError(m.db.Update(index, mark))
+			}
+		case now.Sub(mark.LastActive()) > m.config.AutoArchiveAfter:
+			archive = append(archive, index)
+		}
+	}
+	for _, index := range archive {
+		fmt.Printf("archived %v\n", marks[index].Path)
+		if dryRun {
+			continue
+		}
+		mark := marks[index]
+		mark.Archived = true
+		m.handleError(m.db.Update(index, mark))
+	}
+	if dryRun {
+		return
+	}
+	live := liveLength(marks)
+	for moved, index := range slices.Backward(archive) {
+		m.handleError(m.db.Move(index, live-len(archive)+moved))
+	}
+}
+
+func (m *MarkCli) purgeDeleted(dryRun bool) {
+	marks, err := m.db.List()
+	m.handleError(err)
+	purge := m.expiredTrash(marks)
+	for _, index := range purge {
+		fmt.Printf("purged %v\n", marks[index].Path)
+	}
+	if !dryRun && len(purge) > 0 {
+		m.autoBackup()
+		m.handleError(m.db.DeleteMany(purge))
+	}
+}
+
+// expiredTrash returns the indexes of the marks deleted longer ago than
+// the purge_deleted_after setting.
+func (m *MarkCli) expiredTrash(marks []Mark) []int {
+	if m.config.PurgeDeletedAfter == 0 {
+		return nil
+	}
+	now := time.Now()
+	var expired []int
+	for index, mark := range marks {
+		if mark.IsDeleted() && now.Sub(mark.Deleted) > m.config.PurgeDeletedAfter {
+			expired = append(expired, index)
+		}
+	}
+	return expired
+}
+
+// trashExpired moves the marks whose time to live is up to the trash,
+// returning how many there were, or only prints them with dryRun.
+func (m *MarkCli) trashExpired(dryRun bool) int {
+	marks, err := m.db.List()
+	if errors.Is(err, ErrUnavailable) {
+		// They are trashed once the marks can be reached again, rather than
+		// keeping writes from being queued meanwhile.
+		return 0
+	}
+	m.handleError(err)
+	var expired []int
+	for index, mark := range marks {
+		if !mark.IsDeleted() && mark.IsExpired() {
+			fmt.Printf("expired %v\n", ShortenPath(mark.Path, m.config.Roots))
+			expired = append(expired, index)
+		}
+	}
+	if !dryRun && len(expired) > 0 {
+		m.trashMarks(marks, expired)
+	}
+	return len(expired)
+}
+
+// purgeExpiredTrash purges the marks whose time in the trash is up, as
+// gc does, without waiting for gc to be run.
+func (m *MarkCli) purgeExpiredTrash() {
+	marks, err := m.db.List()
+	m.handleError(err)
+	if expired := m.expiredTrash(marks); len(expired) > 0 {
+		m.autoBackup()
+		m.handleError(m.db.DeleteMany(expired))
+	}
+}
+
+// trashOrder returns the indexes of the deleted marks, the most recently
+// deleted first. Their position in it is their number in the trash.
+func trashOrder(marks []Mark) []int {
+	var trash []int
+	for index, mark := range marks {
+		if mark.IsDeleted() {
+			trash = append(trash, index)
+		}
+	}
+	slices.SortStableFunc(trash, func(a, b int) int {
+		return marks[b].Deleted.Compare(marks[a].Deleted)
+	})
+	return trash
+}
+
+// Trash lists the deleted marks awaiting their purge, restores them or
+// purges them at once.
+func (m *MarkCli) Trash(args []string) {
+	flags := newFlagSet("trash")
+	absolute := flags.Bool("absolute", false, "print absolute paths")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) == 0 {
+		m.handleError(errors.New("specify list, restore or empty"))
+	}
+	m.trashExpired(false)
+	m.purgeExpiredTrash()
+	marks, err := m.db.List()
+	m.handleError(err)
+	trash := trashOrder(marks)
+	switch command, args := args[0], args[1:]; command {
+	case "list":
+		if len(args) != 0 {
+			m.handleError(errors.New("invalid number of arguments"))
+		}
+		for number, index := range trash {
+			mark := marks[index]
+			path := mark.Path
+			if !*absolute {
+				path = ShortenPath(mark.Path, m.config.Roots)
+			}
+			purge := ""
+			if m.config.PurgeDeletedAfter > 0 {
+				purge = fmt.Sprintf(", purged after %v", mark.Deleted.Add(m.config.PurgeDeletedAfter).Format("2006-01-02"))
+			}
+			fmt.Printf("[%v] %v (deleted %v%v)\n", number, path, mark.Deleted.Format("2006-01-02 15:04"), purge)
+		}
+	case "restore":
+		if len(args) == 0 {
+			m.handleError(errors.New("specify the numbers of the marks to restore, as listed by trash list"))
+		}
+		var restore []int
+		for _, arg := range args {
+			number, err := strconv.Atoi(arg)
+			if err != nil || number < 0 || number >= len(trash) {
+				m.handleError(fmt.Errorf("there is no mark %v in the trash", arg))
+			}
+			if !slices.Contains(restore, trash[number]) {
+				restore = append(restore, trash[number])
+			}
+		}
+		for _, index := range restore {
+			mark := marks[index]
+			if CheckNameFree(marks, mark.Name, index) != nil {
+				fmt.Printf("restoring %v without its name, %q is now used by another mark.\n", mark.Path, mark.Name)
+				mark.Name = ""
+			}
+			mark.Deleted = time.Time{}
+			if mark.IsExpired() {
+				mark.Expires = time.Time{}
+			}
+			marks[index] = mark
+			m.handleError(m.db.Update(index, mark))
+			fmt.Printf("restored %v\n", mark.Path)
+		}
+		// Moving the last given to the top first leaves the marks at the
+		// top in the order they were given. Each move shifts the marks
+		// above the one moved down by one.
+		var restored []string
+		for position := len(restore) - 1; position >= 0; position-- {
+			index := restore[position]
+			m.handleError(m.db.Move(index, 0))
+			restored = append(restored, marks[index].Path)
+			for other := range restore[:position] {
+				if restore[other] < index {
+					restore[other]++
+				}
+			}
+		}
+		m.keepPinned(marks, restored...)
+	case "empty":
+		if len(args) != 0 {
+			m.handleError(errors.New("invalid number of arguments"))
+		}
+		if len(trash) == 0 {
+			return
+		}
+		m.autoBackup()
+		m.handleError(m.db.DeleteMany(trash))
+		fmt.Printf("purged %v marks\n", len(trash))
+	default:
+		m.handleError(fmt.Errorf("unknown trash command %q, use list, restore or empty", command))
+	}
+}
+
+func (m *MarkCli) Unarchive(args []string) {
+	args, err := parseFlags(newFlagSet("unarchive"), args)
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("specify a mark"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	index, err := m.resolve(args[0])
+	m.handleError(err)
+	mark := marks[index]
+	mark.Archived = false
+	mark.LastUsed = time.Now()
+	m.handleError(m.db.Update(index, mark))
+	m.handleError(m.db.Move(index, 0))
+	m.keepPinned(marks)
+}
+
+// Mount runs the configured mount_command for the volume a mark was made
+// on.
+func (m *MarkCli) Mount(args []string) {
+	args, err := parseFlags(newFlagSet("mount"), args)
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("specify a mark"))
+	}
+	index, err := m.resolve(args[0])
+	m.handleError(err)
+	mark, err := m.db.Get(index)
+	m.handleError(err)
+	if mark.MountPoint == "" {
+		m.handleError(fmt.Errorf("%v was not marked on a separate volume", mark.Path))
+	}
+	if m.config.MountCommand == "" {
+		m.handleError(fmt.Errorf("mount_command is not set, mount %v manually", mark.MountPoint))
+	}
+	command := exec.Command("sh", "-c", m.config.MountCommand)
+	command.Env = append(os.Environ(), "MARK_MOUNT_POINT="+mark.MountPoint, "MARK_MOUNT_SOURCE="+mark.MountSource)
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	m.handleError(command.Run())
+}
+
+// Exec runs a command in the directory of a mark, exiting with the status
+// of the command.
+func (m *MarkCli) Exec(args []string) {
+	separator := slices.Index(args, "--")
+	if separator < 0 || separator == len(args)-1 {
+		m.handleError(errors.New("specify the command after --, e.g. mark exec build -- make test"))
+	}
+	commandArgs := args[separator+1:]
+	args, err := parseFlags(newFlagSet("exec"), args[:separator])
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("specify a mark"))
+	}
+	identifier, subpath := m.splitSubpath(args[0])
+	index, err := m.resolve(identifier)
+	m.handleError(err)
+	mark, err := m.db.Get(index)
+	m.handleError(err)
+	target, err := m.expandPath(mark)
+	m.handleError(err)
+	dir := filepath.Join(target, subpath)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		m.handleError(fmt.Errorf("directory does not exist: %v", dir))
+	}
+	mark.LastUsed = time.Now()
+	m.handleError(m.db.Update(index, mark))
+	command := exec.Command(commandArgs[0], commandArgs[1:]...)
+	command.Dir = dir
+	command.Env = append(os.Environ(), "PWD="+dir)
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// Interrupts reach the command, which decides whether to stop, while
+	// mark waits to pass on its exit status.
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+	err = command.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(max(exitErr.ExitCode(), 1))
+	}
+	m.handleError(err)
+}
+
+func (m *MarkCli) Top(args []string) {
+	args, err := parseFlags(newFlagSet("top"), args)
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("specify a mark"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	index, err := m.resolve(args[0])
+	m.handleError(err)
+	m.handleError(m.db.Move(index, 0))
+	m.keepPinned(marks, marks[index].Path)
+}
+
+// MoveTo moves a mark to the given index, shifting the marks in between.
+func (m *MarkCli) MoveTo(args []string) {
+	args, err := parseFlags(newFlagSet("move-to"), args)
+	m.handleError(err)
+	if len(args) != 2 {
+		m.handleError(errors.New("specify a mark and the index to move it to"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	from, err := m.resolve(args[0])
+	m.handleError(err)
+	to, err := m.liveIndex(args[1])
+	m.handleError(err)
+	m.handleError(m.db.Move(from, to))
+	m.keepPinned(marks, marks[from].Path)
+}
+
+// Swap swaps the places of two marks in the list.
+func (m *MarkCli) Swap(args []string) {
+	args, err := parseFlags(newFlagSet("swap"), args)
+	m.handleError(err)
+	if len(args) != 2 {
+		m.handleError(errors.New("specify the two marks to swap"))
+	}
+	first, err := m.resolve(args[0])
+	m.handleError(err)
+	second, err := m.resolve(args[1])
+	m.handleError(err)
+	if first == second {
+		return
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	first, second = min(first, second), max(first, second)
+	// Moving the second mark into the place of the first shifts the first
+	// down by one, from where it moves into the place of the second.
+	m.handleError(m.db.Move(second, first))
+	m.handleError(m.db.Move(first+1, second))
+	m.keepPinned(marks, marks[first].Path, marks[second].Path)
+}
+
+// keepPinned moves the pinned marks that a change shifted, such as adding a
+// mark above them, back to the indexes they had in before, the marks before
+// the change, so that indexes learned by heart keep working. moved are the
+// paths of the marks moved on purpose, which keep their new place.
+func (m *MarkCli) keepPinned(before []Mark, moved ...string) {
+	current, err := m.db.List()
+	m.handleError(err)
+	for index, mark := range KeepPinned(before, current, moved...) {
+		from := slices.IndexFunc(current, func(other Mark) bool { return other.Path == mark.Path })
+		if from == index {
+			continue
+		}
+		m.handleError(m.db.Move(from, index))
+		current = slices.Insert(slices.Delete(current, from, from+1), index, mark)
+	}
+}
+
+// Pin pins a mark to its index, which it keeps when marks are added or
+// moved to the top above it, or unpins it with --remove.
+func (m *MarkCli) Pin(args []string) {
+	flags := newFlagSet("pin")
+	remove := flags.Bool("remove", false, "unpin the mark instead")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("specify a mark"))
+	}
+	index, err := m.resolve(args[0])
+	m.handleError(err)
+	mark, err := m.db.Get(index)
+	m.handleError(err)
+	mark.Pinned = !*remove
+	m.handleError(m.db.Update(index, mark))
+}
+
+// liveIndex parses an index into the marks that are not deleted, counting
+// from the end when negative.
+func (m *MarkCli) liveIndex(arg string) (int, error) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid index: %v", arg)
+	}
+	marks, err := m.db.List()
+	if err != nil {
+		return 0, err
+	}
+	length := liveLength(marks)
+	resolved, err := resolveIndex(length, index)
+	if err != nil {
+		return 0, errors.New(indexRangeMessage(length, index))
+	}
+	return resolved, nil
+}
+
+func (m *MarkCli) Get(args []string) {
+	flags := newFlagSet("get")
+	noCheck := flags.Bool("no-check", false, "do not check that the directory exists")
+	quote := flags.Bool("quote", false, "quote the path for the shell")
+	all := flags.Bool("all", false, "print the paths of all the marks")
+	var null bool
+	flags.BoolVar(&null, "null", false, "end the paths with NUL instead of a newline")
+	flags.BoolVar(&null, "z", false, "end the paths with NUL instead of a newline")
+	flags.BoolVar(&null, "0", false, "end the paths with NUL instead of a newline")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) > 1 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	if *all {
+		if len(args) != 0 || *quote {
+			m.handleError(errors.New("--all takes no mark and cannot be combined with --quote"))
+		}
+		marks, err := m.db.List()
+		m.handleError(err)
+		var active []Mark
+		for _, mark := range marks {
+			if !mark.Archived && !mark.IsDeleted() {
+				active = append(active, mark)
+			}
+		}
+		m.handleError(writePaths(os.Stdout, m.expandPaths(active), null))
+		return
+	}
+	index := 0
+	identifier, subpath := "0", ""
+	if len(args) == 1 {
+		identifier, subpath = m.splitSubpath(args[0])
+		index, err = m.resolve(identifier)
+		m.handleGetError(args[0], "", err)
+	}
+	mark, err := m.db.Get(index)
+	m.handleGetError(identifier, "", err)
+	target, err := m.expandPath(mark)
+	m.handleGetError(identifier, mark.Path, err)
+	path := filepath.Join(target, subpath)
+	if _, err := m.stat(path); err != nil && !*noCheck {
+		if errors.Is(err, context.DeadlineExceeded) {
+			m.handleGetError(identifier, path, fmt.Errorf("%v did not respond in time (see --timeout)", path))
+		}
+		mounts, _ := Mounts()
+		expanded := mark
+		expanded.Path = target
+		if CheckTarget(expanded, mounts, m.stat) == errTargetUnmounted {
+			m.handleGetError(identifier, path, fmt.Errorf("%v is on %v which is not mounted, run: mark mount %v", path, mark.MountPoint, index))
+		}
+		m.handleGetError(identifier, path, fmt.Errorf("directory does not exist: %v", path))
+	}
+	mark.LastUsed = time.Now()
+	m.handleError(m.db.Update(index, mark))
+	if m.config.UsageJournal {
+		m.recordUsage(mark)
+	}
+	m.logEvent(EventJumped, mark)
+	if *quote {
+		path = ShellQuote(path)
+	}
+	m.handleError(writePaths(os.Stdout, []string{path}, null))
+}
+
+// recordUsage journals a jump to mark. Failures are reported but do not
+// stop the jump.
+func (m *MarkCli) recordUsage(mark Mark) {
+	journalFile, err := m.sidecarFile("_journal")
+	if err == nil {
+		var marks []Mark
+		marks, err = m.db.List()
+		if err == nil {
+			err = NewUsageJournal(journalFile).Record(UsageEntry{Time: time.Now(), Path: mark.Path, Marks: len(marks)})
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "usage journal: %v\n", err)
+	}
+}
+
+// logEvent adds an event the db does not publish itself, such as a jump
+// to a mark, to the event log.
+func (m *MarkCli) logEvent(eventType string, mark Mark) {
+	if m.config.DryRun {
+		return
+	}
+	eventFile, err := m.sidecarFile("_events")
+	if err == nil {
+		err = NewEventLog(eventFile).Append(Event{Type: eventType, Mark: mark, Time: time.Now()})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "event log: %v\n", err)
+	}
+}
+
+// Events prints the marks added, removed and jumped to by every mark
+// process as JSON lines, and with --follow keeps printing them as they
+// happen.
+func (m *MarkCli) Events(args []string) {
+	flags := newFlagSet("events")
+	var follow bool
+	flags.BoolVar(&follow, "follow", false, "keep printing events as they happen")
+	flags.BoolVar(&follow, "f", false, "keep printing events as they happen")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	eventFile, err := m.sidecarFile("_events")
+	m.handleError(err)
+	log := NewEventLog(eventFile)
+	if !follow {
+		m.handleError(log.Copy(os.Stdout))
+		return
+	}
+	m.handleError(log.Follow(context.Background(), os.Stdout, 250*time.Millisecond))
+}
+
+// Config prints the settings in effect, defaults included, in the format
+// of the config file, or the value of a single setting.
+func (m *MarkCli) Config(args []string) {
+	args, err := parseFlags(newFlagSet("config"), args)
+	m.handleError(err)
+	if len(args) > 1 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	settings := m.config.Settings()
+	if m.config.DBFile == "" {
+		dbFile, err := SidecarFile(m.config, "")
+		m.handleError(err)
+		settings[0].Value = strconv.Quote(dbFile)
+	}
+	if len(args) == 1 {
+		index := slices.IndexFunc(settings, func(setting Setting) bool { return setting.Key == args[0] })
+		if index < 0 {
+			m.handleError(fmt.Errorf("unknown setting %q", args[0]))
+		}
+		value := settings[index].Value
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		fmt.Println(value)
+		return
+	}
+	configFile, err := GetConfigFile()
+	m.handleError(err)
+	if _, err := os.Stat(configFile); err != nil {
+		fmt.Printf("# %v does not exist, these are the defaults\n", configFile)
+	} else {
+		fmt.Printf("# %v\n", configFile)
+	}
+	section := ""
+	for _, setting := range settings {
+		key := setting.Key
+		if name, rest, ok := strings.Cut(key, "."); ok {
+			if name != section {
+				fmt.Printf("\n[%v]\n", name)
+				section = name
+			}
+			key = rest
+		}
+		fmt.Printf("%v = %v\n", key, setting.Value)
+	}
+}
+
+// TopN ranks the marks by the number of jumps to them recorded in the
+// usage journal over a window of time.
+func (m *MarkCli) TopN(args []string) {
+	flags := newFlagSet("topN")
+	window := flags.String("window", "month", "how far back to count: week, month, all or an age such as 14d")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) > 1 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	count := 10
+	if len(args) == 1 {
+		count, err = strconv.Atoi(args[0])
+		if err != nil || count <= 0 {
+			m.handleError(fmt.Errorf("invalid count %q", args[0]))
+		}
+	}
+	age, err := ParseWindow(*window)
+	m.handleError(err)
+	journalFile, err := m.sidecarFile("_journal")
+	m.handleError(err)
+	entries, err := NewUsageJournal(journalFile).List()
+	m.handleError(err)
+	if len(entries) == 0 && !m.config.UsageJournal {
+		m.handleError(errors.New("no usage recorded, set usage_journal = true in the config to start recording jumps"))
+	}
+	if age > 0 {
+		since := time.Now().Add(-age)
+		entries = slices.DeleteFunc(entries, func(entry UsageEntry) bool { return entry.Time.Before(since) })
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	paths, counts := RankPaths(entries)
+	if len(paths) == 0 {
+		fmt.Println("no jumps recorded in that window")
+		return
+	}
+	for rank, path := range paths[:min(count, len(paths))] {
+		line := ShortenPath(path, m.config.Roots) + " (no longer marked)"
+		if index := slices.IndexFunc(marks, func(mark Mark) bool { return mark.Path == path && !mark.IsDeleted() }); index >= 0 {
+			line = m.formatMark(index, marks[index], false)
+		}
+		fmt.Printf("%2v. %4v %v\n", rank+1, counts[path], line)
+	}
+}
+
+// Report summarises the jumps recorded in the usage journal.
+func (m *MarkCli) Report(args []string) {
+	flags := newFlagSet("report")
+	top := flags.Int("top", 10, "number of most used marks to show")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	journalFile, err := m.sidecarFile("_journal")
+	m.handleError(err)
+	entries, err := NewUsageJournal(journalFile).List()
+	m.handleError(err)
+	if len(entries) == 0 && !m.config.UsageJournal {
+		m.handleError(errors.New("no usage recorded, set usage_journal = true in the config to start recording jumps"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	WriteUsageReport(os.Stdout, entries, marks, time.Now(), *top, m.config.Roots)
+}
+
+// handleGetError notifies the configured notifier of a failure to get a
+// mark before exiting.
+func (m *MarkCli) handleGetError(identifier string, path string, err error) {
+	if err != nil {
+		m.config.Notifier.Notify(identifier, path, err)
+	}
+	m.handleError(err)
+}
+
+func (m *MarkCli) Install(args []string) {
+	flags := newFlagSet("install")
+	key := flags.String("key", m.config.ZshJumpKey, "the key the zsh mark-jump widget is bound to")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) > 1 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	shell := "bash"
+	if len(args) == 1 {
+		shell = args[0]
+	}
+	if shell == "pwsh" {
+		shell = "powershell"
+	}
+	script, err := ShellInit(shell, *key)
+	m.handleError(err)
+	hook, _ := VisitHook(shell)
+	rcFile, err := ShellRCFile(shell)
+	m.handleError(err)
+	switch shell {
+	case "bash":
+		fmt.Printf(`
+Run the following commands to create a move function based on the index provided:
+
+1. Add the following code to ~/.bashrc
+
+%v
+2. Optionally, add the following line to ~/.bashrc to track visited
+directories for "mark suggest"
+
+%v
+
+3. Run the following command
+source ~/.bashrc
+
+Alternatively, run "mark setup" to have this done for you.
+`, script, hook)
+	case "zsh":
+		if *key == "" {
+			*key = defaultZshJumpKey
+		}
+		fmt.Printf(`
+Run the following commands to create the move, back, up and down functions, and
+a mark-jump widget that picks a mark with fzf when %v is pressed:
+
+1. Add the following code to %v, or the line: eval "$(mark init zsh)"
+
+%v
+2. Optionally, add the following line to %v to track visited
+directories for "mark suggest"
+
+%v
+
+3. Run the following command
+source %v
+
+The key is set with the zsh_jump_key setting or "mark install zsh --key <key>",
+using bindkey notation, e.g. '^[m' for alt-m.
+Alternatively, run "mark setup" to have this done for you.
+`, *key, rcFile, script, rcFile, hook, rcFile)
+	case "fish":
+		fmt.Printf(`
+Run the following commands to create the move, back, up and down functions and
+the completions for mark and move:
+
+1. Add the following line to %v
+
+%v
+
+2. Optionally, add the following line to %v to track visited
+directories for "mark suggest"
+
+%v
+
+3. Run the following command
+source %v
+
+Alternatively, run "mark setup" to have this done for you.
+`, rcFile, InitLine(shell), rcFile, hook, rcFile)
+	case "powershell":
+		fmt.Printf(`
+Run the following commands to create the Move-Mark function, aliased to move,
+and the back, up and down functions:
+
+1. Add the following line to your PowerShell profile, $PROFILE:
+%v
+
+%v
+
+2. Optionally, add the following line after it to track visited
+directories for "mark suggest"
+
+%v
+
+3. Run the following command
+. $PROFILE
+
+Alternatively, run "mark setup" to have this done for you.
+`, rcFile, InitLine(shell), hook)
+	}
+}
+
+// Scan marks every directory beneath root containing a .markrc file,
+// updating the metadata of the ones already marked.
+func (m *MarkCli) Scan(args []string) {
+	flags := newFlagSet("scan")
+	monorepo := flags.Bool("monorepo", false, "mark the services and packages of a monorepo")
+	namespace := flags.String("namespace", "", "the namespace of the package names, the name of the root by default")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("specify a directory to scan"))
+	}
+	root, err := filepath.Abs(markdb.ExpandHome(args[0]))
+	m.handleError(err)
+	if *monorepo {
+		if *namespace == "" {
+			*namespace = filepath.Base(root)
+		}
+		m.scanMonorepo(root, *namespace)
+		return
+	}
+	if flagWasSet(flags, "namespace") {
+		m.handleError(errors.New("--namespace requires --monorepo"))
+	}
+	dirs, err := FindMarkRCDirs(root, m.config.Exclude)
+	m.handleError(err)
+	before, err := m.db.List()
+	m.handleError(err)
+	defer m.keepPinned(before)
+	defer m.evictOverflow()
+	for _, dir := range dirs {
+		rcMark, _, err := ReadMarkRC(dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		marks, err := m.db.List()
+		m.handleError(err)
+		existing := slices.IndexFunc(marks, func(other Mark) bool { return other.Path == dir })
+		if err := CheckNameFree(marks, rcMark.Name, existing); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", dir, err)
+			rcMark.Name = ""
+		}
+		if existing >= 0 {
+			m.handleError(m.db.Update(existing, marks[existing].Merge(rcMark)))
+			fmt.Printf("updated %v\n", dir)
+			continue
+		}
+		rcMark.Path = dir
+		rcMark.Created = time.Now()
+		m.handleError(m.db.Add(rcMark))
+		fmt.Printf("added %v\n", dir)
+	}
+}
+
+func (m *MarkCli) Session(args []string) {
+	flags := newFlagSet("session")
+	print := flags.Bool("print", false, "print the tmux commands instead of opening windows")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) == 0 {
+		m.handleError(errors.New("specify save, open, list or delete"))
+	}
+	sessionFile, err := m.sidecarFile("_sessions")
+	m.handleError(err)
+	sessions := NewSessionStore(sessionFile)
+	switch command, args := args[0], args[1:]; command {
+	case "save":
+		if len(args) < 2 {
+			m.handleError(errors.New("specify a session name and its marks"))
+		}
+		marks, err := m.db.List()
+		m.handleError(err)
+		var paths []string
+		for _, identifier := range args[1:] {
+			index, err := ResolveMark(marks, identifier)
+			m.handleError(err)
+			paths = append(paths, marks[index].Path)
+		}
+		m.handleError(sessions.Save(args[0], paths))
+	case "open":
+		if len(args) != 1 {
+			m.handleError(errors.New("specify a session name"))
+		}
+		paths, err := sessions.Get(args[0])
+		m.handleError(err)
+		m.handleError(OpenSession(os.Stdout, args[0], paths, *print))
+	case "list":
+		all, err := sessions.List()
+		m.handleError(err)
+		for _, name := range SortedSessionNames(all) {
+			fmt.Println(name)
+			for _, path := range all[name] {
+				fmt.Printf("  %v\n", ShortenPath(path, m.config.Roots))
+			}
+		}
+	case "delete":
+		if len(args) != 1 {
+			m.handleError(errors.New("specify a session name"))
+		}
+		m.handleError(sessions.Delete(args[0]))
+	default:
+		m.handleError(fmt.Errorf("unknown session command %q", command))
+	}
+}
+
+// Serve serves the marks over the API of the remote backend, so that other
+// machines can use them.
+func (m *MarkCli) Serve(args []string) {
+	flags := newFlagSet("serve")
+	listen := flags.String("listen", defaultListenAddress, "the address to listen on")
+	token := flags.String("token", os.Getenv("MARK_SERVE_TOKEN"), "the token clients must send")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	db := m.db
+	// --timeout bounds the storage calls of a command run in the shell; the
+	// server answers its clients for as long as they are willing to wait.
+	if timeout, ok := db.(*markdb.TimeoutMarkDB); ok {
+		db = timeout.Unwrap()
+	}
+	// The clients journal their own changes for undo.
+	if undo, ok := db.(*UndoMarkDB); ok {
+		db = undo.MarkDB
+	}
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "warning: no --token given, anyone who can reach the server can change the marks")
+	}
+	fmt.Fprintf(os.Stderr, "serving the marks on %v\n", *listen)
+	m.handleError(http.ListenAndServe(*listen, NewMarkServer(db, *token)))
+}
+
+// Sync commits the changes to the db to the git repository it is kept in,
+// pulls the changes made on other machines and pushes the result.
+func (m *MarkCli) Sync(args []string) {
+	args, err := parseFlags(newFlagSet("sync"), args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	if m.config.Ephemeral {
+		m.handleError(errors.New("there is nothing to sync with --ephemeral"))
+	}
+	dbFile, err := m.sidecarFile("")
+	m.handleError(err)
+	db, err := markdb.NewLocalMarkDB(dbFile)
+	m.handleError(err)
+	host, _ := os.Hostname()
+	m.handleError(NewGitSync(db, os.Stdout).Sync(fmt.Sprintf("mark sync from %v", host)))
+}
+
+// Use makes a profile the one used from now on, unless --profile or
+// $MARK_PROFILE says otherwise.
+func (m *MarkCli) Use(args []string) {
+	args, err := parseFlags(newFlagSet("use"), args)
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("specify a profile"))
+	}
+	m.handleError(m.profiles().Use(args[0]))
+	fmt.Printf("using the %v profile\n", args[0])
+}
+
+// Profile lists, creates and deletes profiles.
+func (m *MarkCli) Profile(args []string) {
+	flags := newFlagSet("profile")
+	force := flags.Bool("force", false, "delete the profile without confirmation")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) == 0 {
+		m.handleError(errors.New("specify list, create or delete"))
+	}
+	profiles := m.profiles()
+	switch command, args := args[0], args[1:]; command {
+	case "list":
+		names, err := profiles.List()
+		m.handleError(err)
+		for _, name := range SortedProfileNames(names) {
+			if name == m.config.Profile {
+				fmt.Printf("* %v\n", name)
+			} else {
+				fmt.Printf("  %v\n", name)
+			}
+		}
+	case "create":
+		if len(args) != 1 {
+			m.handleError(errors.New("specify a profile name"))
+		}
+		m.handleError(profiles.Create(args[0]))
+		fmt.Printf("created the %v profile, switch to it with: mark use %v\n", args[0], args[0])
+	case "delete":
+		if len(args) != 1 {
+			m.handleError(errors.New("specify a profile name"))
+		}
+		if args[0] == m.config.Profile {
+			m.handleError(fmt.Errorf("%v is the profile in use, switch to another one first", args[0]))
+		}
+		if !*force {
+			if m.config.NonInteractive {
+				m.handleError(errors.New("deleting a profile needs --force when non-interactive"))
+			}
+			prompter := NewPrompter(os.Stdin, os.Stdout)
+			if !prompter.Confirm(fmt.Sprintf("Delete the %v profile and all of its marks?", args[0]), false) {
+				return
+			}
+		}
+		m.handleError(profiles.Delete(args[0]))
+	default:
+		m.handleError(fmt.Errorf("unknown profile command %q", command))
+	}
+}
+
+// profiles returns the store of the profiles.
+func (m *MarkCli) profiles() *ProfileStore {
+	if m.config.ProfilesDir == "" {
+		m.handleError(errors.New("profiles are not available with --ephemeral"))
+	}
+	return NewProfileStore(m.config.ProfilesDir)
+}
+
+func (m *MarkCli) Setup(args []string) {
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	prompter := NewPrompter(os.Stdin, os.Stdout)
+	prompter.NonInteractive = m.config.NonInteractive
+	m.handleError(RunSetup(prompter))
+}
+
+func (m *MarkCli) Visit(args []string) {
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	path, err := os.Getwd()
+	m.handleError(err)
+	if MatchesAny(path, m.config.Exclude) {
+		return
+	}
+	visits, err := m.sidecarFile("_visits")
+	m.handleError(err)
+	m.handleError(NewVisitLog(visits).Record(path))
+}
+
+func (m *MarkCli) Suggest(args []string) {
+	if len(args) > 1 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	count := 10
+	var err error
+	if len(args) == 1 {
+		count, err = strconv.Atoi(args[0])
+		if err != nil {
+			m.handleError(errors.New("count is not a number"))
+		}
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	paths := PathsOf(marks)
+	visitFile, err := m.sidecarFile("_visits")
+	m.handleError(err)
+	visits, err := NewVisitLog(visitFile).List()
+	m.handleError(err)
+	for _, visit := range visits {
+		if count <= 0 {
+			break
+		}
+		if slices.Contains(paths, visit.Path) || MatchesAny(visit.Path, m.config.Exclude) {
+			continue
+		}
+		fmt.Printf("%v\t%v\n", visit.Count, visit.Path)
+		count--
+	}
+}
+
+func (m *MarkCli) Bench(args []string) {
+	flags := newFlagSet("bench")
+	var sizeArgs stringList
+	flags.Var(&sizeArgs, "size", "number of marks to seed the db with, may be repeated")
+	operations := flags.Int("ops", 100, "number of operations to measure")
+	backendName := flags.String("backend", "", "only benchmark this backend")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	if *operations <= 0 {
+		m.handleError(errors.New("ops must be positive"))
+	}
+	sizes := []int{10, 100, 1000}
+	if len(sizeArgs) > 0 {
+		sizes = nil
+		for _, arg := range sizeArgs {
+			size, err := strconv.Atoi(arg)
+			if err != nil || size < 0 {
+				m.handleError(fmt.Errorf("invalid size %q", arg))
+			}
+			sizes = append(sizes, size)
+		}
+	}
+	backends := benchBackends
+	if *backendName != "" {
+		backends = slices.DeleteFunc(slices.Clone(backends), func(b benchBackend) bool { return b.name != *backendName })
+		if len(backends) == 0 {
+			m.handleError(fmt.Errorf("unknown backend %q", *backendName))
+		}
+	}
+	m.handleError(RunBench(os.Stdout, backends, sizes, *operations))
+}
+
+func (m *MarkCli) Clear(args []string) {
+	var filter Filter
+	flags := newFlagSet("clear")
+	filter.AddFlags(flags)
+	filter.AddKeepFlags(flags)
+	var force bool
+	flags.BoolVar(&force, "force", false, "clear without asking")
+	flags.BoolVar(&force, "f", false, "clear without asking")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	// The marks already in the trash are left there for gc to purge.
+	var indexes []int
+	for index, mark := range marks {
+		if !mark.IsDeleted() && (filter.IsEmpty() || filter.MatchMark(mark)) {
+			indexes = append(indexes, index)
+		}
+	}
+	count := len(indexes)
+	if count == 0 {
+		return
+	}
+	if !force && !m.config.DryRun {
+		if m.config.NonInteractive {
+			m.handleError(errors.New("clearing the marks needs --force when non-interactive"))
+		}
+		prompter := NewPrompter(os.Stdin, os.Stdout)
+		if !prompter.Confirm(fmt.Sprintf("Delete %v marks?", count), false) {
+			return
+		}
+	}
+	m.trashMarks(marks, indexes)
+}
+
+func (m *MarkCli) Prune(args []string) {
+	var filter Filter
+	flags := newFlagSet("prune")
+	filter.AddFlags(flags)
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	m.deleteMatching(func(mark Mark) bool {
+		if !filter.Match(mark.Path) {
+			return false
+		}
+		path, err := m.expandPath(mark)
+		if err != nil {
+			return false
+		}
+		_, err = os.Stat(path)
+		return errors.Is(err, os.ErrNotExist)
+	})
+}
+
+// deleteMatching moves every mark for which match returns true to the
+// trash and prints the deleted paths. The marks already in the trash are
+// left for gc to purge.
+func (m *MarkCli) deleteMatching(match func(mark Mark) bool) {
+	marks, err := m.db.List()
+	m.handleError(err)
+	var indexes []int
+	for index, mark := range marks {
+		if !mark.IsDeleted() && match(mark) {
+			indexes = append(indexes, index)
+			fmt.Printf("deleted %v\n", mark.Path)
+		}
+	}
+	m.trashMarks(marks, indexes)
+}
+
+// Tag adds tags to a mark, or removes them with --remove. Without
+// arguments it lists the tags in use.
+func (m *MarkCli) Tag(args []string) {
+	flags := newFlagSet("tag")
+	remove := flags.Bool("remove", false, "remove the tags instead")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) == 0 && !*remove {
+		m.listTags()
+		return
+	}
+	if len(args) < 2 {
+		m.handleError(errors.New("specify a mark and at least one tag"))
+	}
+	for _, tag := range args[1:] {
+		m.handleError(ValidateTag(tag))
+	}
+	index, err := m.resolve(args[0])
+	m.handleError(err)
+	mark, err := m.db.Get(index)
+	m.handleError(err)
+	if *remove {
+		mark.Tags = slices.DeleteFunc(mark.Tags, func(tag string) bool { return slices.Contains(args[1:], tag) })
+	} else {
+		mark = mark.Merge(Mark{Tags: args[1:]})
+	}
+	m.handleError(m.db.Update(index, mark))
+}
+
+// listTags prints the tags in use and how many marks have each.
+func (m *MarkCli) listTags() {
+	marks, err := m.db.List()
+	m.handleError(err)
+	counts := map[string]int{}
+	for mark := range filterMarks(marks, func(mark Mark) bool { return !mark.IsDeleted() }) {
+		for _, tag := range mark.Tags {
+			counts[tag]++
+		}
+	}
+	for _, tag := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Printf("#%v %v\n", tag, counts[tag])
+	}
+}
+
+// Export writes all the marks, with their metadata, to stdout or a file.
+func (m *MarkCli) Export(args []string) {
+	flags := newFlagSet("export")
+	format := flags.String("format", "json", "output format")
+	output := flags.String("output", "", "write to the file instead of stdout")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	export, ok := exportFormats[*format]
+	if !ok {
+		m.handleError(fmt.Errorf("unknown format %q, use one of %v", *format, strings.Join(slices.Sorted(maps.Keys(exportFormats)), ", ")))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	if *output == "" {
+		m.handleError(export(os.Stdout, marks))
+		return
+	}
+	file, err := os.Create(*output)
+	m.handleError(err)
+	defer file.Close()
+	m.handleError(export(file, marks))
+}
+
+// Import reads marks exported with export, or with --from the database of
+// another directory jumper, merging them into the marks or, with
+// --replace, replacing them.
+func (m *MarkCli) Import(args []string) {
+	flags := newFlagSet("import")
+	replace := flags.Bool("replace", false, "replace the marks instead of merging")
+	from := flags.String("from", "", "import the database of a directory jumper: "+strings.Join(slices.Sorted(maps.Keys(jumpers)), ", "))
+	byScore := flags.Bool("by-score", false, "with --from, order the marks by the jumper's score instead of by last use")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	var imported []Mark
+	if *from != "" {
+		imported = m.importJumper(*from, args, *byScore)
+	} else {
+		if len(args) != 1 {
+			m.handleError(errors.New("specify a file, or - for stdin"))
+		}
+		input := os.Stdin
+		if args[0] != "-" {
+			input, err = os.Open(args[0])
+			m.handleError(err)
+			defer input.Close()
+		}
+		imported, err = ImportJSON(input)
+		m.handleError(err)
+	}
+	if *replace {
+		m.autoBackup()
+		fmt.Printf("imported %v marks\n", m.replaceMarks(imported))
+		return
+	}
+	added := m.mergeMarks(imported)
+	fmt.Printf("imported %v new marks, merged %v\n", added, len(imported)-added)
+}
+
+// importJumper reads the database of the directory jumper called name, at
+// the file of args or else where the jumper keeps it, and returns marks
+// for its directories that still exist.
+func (m *MarkCli) importJumper(name string, args []string, byScore bool) []Mark {
+	jumper, ok := jumpers[name]
+	if !ok {
+		m.handleError(fmt.Errorf("unknown directory jumper %q, use one of %v", name, strings.Join(slices.Sorted(maps.Keys(jumpers)), ", ")))
+	}
+	if len(args) > 1 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	var dataFile string
+	if len(args) == 1 {
+		dataFile = args[0]
+	} else {
+		var err error
+		dataFile, err = jumper.DataFile()
+		m.handleError(err)
+	}
+	file, err := os.Open(dataFile)
+	if errors.Is(err, os.ErrNotExist) && len(args) == 0 {
+		m.handleError(fmt.Errorf("no %v database at %v, give its location", name, dataFile))
+	}
+	m.handleError(err)
+	defer file.Close()
+	dirs, err := jumper.Read(file)
+	if err != nil {
+		m.handleError(fmt.Errorf("%v: %v", dataFile, err))
+	}
+	count := len(dirs)
+	dirs = slices.DeleteFunc(dirs, func(dir JumpDir) bool {
+		info, err := os.Stat(dir.Path)
+		return !filepath.IsAbs(dir.Path) || err != nil || !info.IsDir()
+	})
+	if skipped := count - len(dirs); skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %v entries that are not existing directories\n", skipped)
+	}
+	return JumpMarks(dirs, byScore)
+}
+
+// replaceMarks replaces the marks of the db with marks, returning the number
+// of marks written. Names used twice are dropped from the later marks.
+func (m *MarkCli) replaceMarks(marks []Mark) int {
+	return m.writeMerged(nil, marks)
+}
+
+// Backup snapshots the marks, with all their metadata, to file or a new
+// file in the backups directory.
+func (m *MarkCli) Backup(args []string) {
+	flags := newFlagSet("backup")
+	list := flags.Bool("list", false, "list the backups instead")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) > 1 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	store := m.backupStore()
+	if *list {
+		paths, err := store.List()
+		m.handleError(err)
+		for _, path := range paths {
+			marks, err := markdb.ReadDBFile(path)
+			if err != nil {
+				fmt.Printf("%v  (%v)\n", path, err)
+				continue
+			}
+			fmt.Printf("%v  %v marks\n", path, len(marks))
+		}
+		return
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	path := ""
+	if len(args) == 1 {
+		path = markdb.ExpandHome(args[0])
+		m.handleError(WriteBackup(path, marks))
+	} else {
+		path, err = store.Save(marks, false)
+		m.handleError(err)
+	}
+	fmt.Printf("backed up %v marks to %v\n", len(marks), path)
+}
+
+// Restore replaces the marks with those of a backup, the newest one unless
+// a file is given. The marks replaced are backed up first, so that a
+// restore can itself be undone by restoring again.
+func (m *MarkCli) Restore(args []string) {
+	args, err := parseFlags(newFlagSet("restore"), args)
+	m.handleError(err)
+	if len(args) > 1 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	var path string
+	if len(args) == 1 {
+		path = markdb.ExpandHome(args[0])
+	} else {
+		path, err = m.backupStore().Latest()
+		m.handleError(err)
+	}
+	marks, err := markdb.ReadDBFile(path)
+	m.handleError(err)
+	m.autoBackup()
+	fmt.Printf("restored %v marks from %v\n", m.replaceMarks(marks), path)
+}
+
+func (m *MarkCli) backupStore() *BackupStore {
+	dir, err := m.sidecarFile("_backups")
+	m.handleError(err)
+	return NewBackupStore(dir, m.config.AutoBackups)
+}
+
+// autoBackup backs up the marks before a destructive change, keeping the
+// number of backups set by the auto_backups setting.
+func (m *MarkCli) autoBackup() {
+	if m.config.AutoBackups == 0 || m.config.DryRun {
+		return
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	if len(marks) == 0 {
+		return
+	}
+	_, err = m.backupStore().Save(marks, true)
+	m.handleError(err)
+}
+
+// Undo reverts the last command that changed the marks. Repeated, it
+// reverts the commands before it, up to the last 10.
+func (m *MarkCli) Undo(args []string) {
+	args, err := parseFlags(newFlagSet("undo"), args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	db := m.db
+	if timeout, ok := db.(*markdb.TimeoutMarkDB); ok {
+		db = timeout.Unwrap()
+	}
+	undo, ok := db.(*UndoMarkDB)
+	if !ok {
+		m.handleError(errors.New("there is nothing to undo in ephemeral mode"))
+	}
+	changed, err := undo.Undo()
+	m.handleError(err)
+	fmt.Printf("undid the change made %v\n", changed.Format("2006-01-02 15:04:05"))
+}
+
+// mergeMarks merges imported into the db, returning the number of marks
+// added. The metadata of marks already marked is merged in, and new marks
+// go after the existing ones, leaving their indexes alone.
+func (m *MarkCli) mergeMarks(imported []Mark) int {
+	marks, err := m.db.List()
+	m.handleError(err)
+	added := m.writeMerged(marks, imported)
+	m.evictOverflow()
+	return added
+}
+
+// writeMerged merges imported into marks, the marks in the db, and writes
+// the result in a single write, returning the number of marks added. The
+// marks added go after the others but before the trash.
+func (m *MarkCli) writeMerged(marks []Mark, imported []Mark) int {
+	merged, added, warnings := MergeMarks(marks, imported)
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+	live := liveLength(marks)
+	m.handleError(m.db.Replace(slices.Concat(merged[:live], merged[len(marks):], merged[live:len(marks)])))
+	return len(added)
+}
+
+// MigrateLegacy merges the marks of the legacy ~/.mark file into the db
+// configured with db_file, and archives the legacy file so that it is not
+// merged twice.
+func (m *MarkCli) MigrateLegacy(args []string) {
+	flags := newFlagSet("migrate-legacy")
+	dryRun := flags.Bool("dry-run", false, "print what would be merged")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	legacyFile, err := GetLocalMarkFile()
+	m.handleError(err)
+	if m.config.DBFile == "" || m.config.DBFile == legacyFile {
+		fmt.Printf("the marks are stored in %v, there is nothing to migrate\n", legacyFile)
+		return
+	}
+	legacy, err := markdb.ReadDBFile(legacyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("%v does not exist, there is nothing to migrate\n", legacyFile)
+		return
+	}
+	m.handleError(err)
+	archive := fmt.Sprintf("%v.migrated-%v", legacyFile, time.Now().Format("20060102-150405"))
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(archive); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		archive = fmt.Sprintf("%v.migrated-%v-%v", legacyFile, time.Now().Format("20060102-150405"), n)
+	}
+	if *dryRun {
+		marks, err := m.db.List()
+		m.handleError(err)
+		_, added, _ := MergeMarks(marks, legacy)
+		fmt.Printf("would merge %v marks from %v into %v, %v of them new, and move %v to %v\n", len(legacy), legacyFile, m.config.DBFile, len(added), legacyFile, archive)
+		return
+	}
+	added := m.mergeMarks(legacy)
+	m.handleError(os.Rename(legacyFile, archive))
+	fmt.Printf("merged %v marks from %v into %v, %v of them new, and moved %v to %v\n", len(legacy), legacyFile, m.config.DBFile, added, legacyFile, archive)
+}
+
+// warnLegacyDB warns when the legacy ~/.mark file exists next to the db
+// configured with db_file, where marks added to it by older versions of
+// mark would go unseen.
+func (m *MarkCli) warnLegacyDB() {
+	legacyFile, err := GetLocalMarkFile()
+	if err != nil || m.config.DBFile == "" || m.config.DBFile == legacyFile || m.config.Profile != defaultProfile {
+		return
+	}
+	if _, err := os.Stat(legacyFile); err != nil {
+		return
+	}
+	if archives, _ := filepath.Glob(legacyFile + ".migrated-*"); len(archives) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %v was recreated after it was migrated, probably by an older version of mark. Run \"mark migrate-legacy\" to merge its marks into %v.\n", legacyFile, m.config.DBFile)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "warning: %v is not used since db_file is set to %v. Run \"mark migrate-legacy\" to merge its marks.\n", legacyFile, m.config.DBFile)
+}
+
+// Relink points a mark at the directory it moved to, given or searched for
+// beneath the nearest ancestor of the old path that still exists. The
+// device and inode recorded with the mark confirm that it is the same
+// directory.
+func (m *MarkCli) Relink(args []string) {
+	flags := newFlagSet("relink")
+	force := flags.Bool("force", false, "relink even if the directory cannot be confirmed to be the same")
+	depth := flags.Int("depth", 4, "maximum number of directories to descend when searching")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) < 1 || len(args) > 2 {
+		m.handleError(errors.New("specify a mark and optionally the directory it moved to"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	index, err := ResolveMark(marks, args[0])
+	m.handleError(err)
+	mark := marks[index]
+	if mark.Template {
+		m.handleError(errors.New("templated marks cannot be relinked"))
+	}
+	var path string
+	if len(args) == 2 {
+		path, err = filepath.Abs(markdb.ExpandHome(args[1]))
+		m.handleError(err)
+		if info, err := os.Stat(path); err != nil {
+			m.handleError(err)
+		} else if !info.IsDir() {
+			m.handleError(fmt.Errorf("not a directory: %v", path))
+		}
+		if same, err := SameDirectory(mark, path); !*force && err != nil {
+			m.handleError(fmt.Errorf("%v, use --force to relink anyway", err))
+		} else if !*force && !same {
+			m.handleError(fmt.Errorf("%v is not the directory that was marked, use --force to relink anyway", path))
+		}
+	} else {
+		if same, _ := SameDirectory(mark, mark.Path); same {
+			fmt.Printf("%v has not moved\n", mark.Path)
+			return
+		}
+		path, err = FindMoved(mark, nearestExisting(mark.Path), *depth, m.config.Exclude)
+		m.handleError(err)
+	}
+	if other := slices.IndexFunc(marks, func(other Mark) bool { return other.Path == path }); other >= 0 && other != index {
+		m.handleError(fmt.Errorf("%v is already marked as [%v]", path, other))
+	}
+	fmt.Printf("relinked %v -> %v\n", mark.Path, path)
+	mark.Path = path
+	RecordFileID(&mark, path)
+	mark.MountPoint, mark.MountSource = "", ""
+	if mounts, err := Mounts(); err == nil {
+		if mount, ok := MountOf(path, mounts); ok && mount.Point != "/" {
+			mark.MountPoint, mark.MountSource = mount.Point, mount.Source
+		}
+	}
+	m.handleError(m.db.Update(index, mark))
+}
+
+// UI browses the marks in a terminal UI, printing the path of the mark
+// jumped to like get.
+func (m *MarkCli) UI(args []string) {
+	flags := newFlagSet("ui")
+	quote := flags.Bool("quote", false, "quote the path for the shell")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	if m.config.NonInteractive {
+		m.handleError(errors.New("ui needs a terminal and cannot be used non-interactively"))
+	}
+	index, err := Browse(m)
+	m.handleError(err)
+	if index < 0 {
+		os.Exit(1)
+	}
+	getArgs := []string{strconv.Itoa(index)}
+	if *quote {
+		getArgs = append(getArgs, "--quote")
+	}
+	m.Get(getArgs)
+}
+
+// Pick lets the user choose a mark with fzf and prints its path, like get.
+func (m *MarkCli) Pick(args []string) {
+	flags := newFlagSet("pick")
+	quote := flags.Bool("quote", false, "quote the path for the shell")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if m.config.NonInteractive {
+		m.handleError(errors.New("pick needs a terminal and cannot be used non-interactively"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	var lines []string
+	for index, mark := range marks {
+		if !mark.Archived && !mark.IsDeleted() {
+			lines = append(lines, m.formatMark(index, mark, false))
+		}
+	}
+	line, err := PickWithFzf(lines, strings.Join(args, " "))
+	if errors.Is(err, errPickCancelled) {
+		os.Exit(1)
+	}
+	m.handleError(err)
+	var index int
+	if _, err := fmt.Sscanf(line[strings.Index(line, "["):], "[%d]", &index); err != nil {
+		m.handleError(fmt.Errorf("unexpected selection %q", line))
+	}
+	getArgs := []string{strconv.Itoa(index)}
+	if *quote {
+		getArgs = append(getArgs, "--quote")
+	}
+	m.Get(getArgs)
+}
+
+// Rel prints a path relative to a mark, or with --abs turns a path
+// relative to the mark into an absolute one.
+func (m *MarkCli) Rel(args []string) {
+	flags := newFlagSet("rel")
+	abs := flags.Bool("abs", false, "print the path relative to the mark as an absolute path")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 2 {
+		m.handleError(errors.New("specify a mark and a path"))
+	}
+	index, err := m.resolve(args[0])
+	m.handleError(err)
+	mark, err := m.db.Get(index)
+	m.handleError(err)
+	root, err := m.expandPath(mark)
+	m.handleError(err)
+	if *abs {
+		if filepath.IsAbs(args[1]) {
+			m.handleError(fmt.Errorf("not a relative path: %v", args[1]))
+		}
+		fmt.Println(filepath.Join(root, args[1]))
+		return
+	}
+	path, err := filepath.Abs(markdb.ExpandHome(args[1]))
+	m.handleError(err)
+	relative, err := filepath.Rel(root, path)
+	m.handleError(err)
+	fmt.Println(relative)
+}
+
+// scanMonorepo marks root as namespace and each package detected beneath
+// it as namespace/package, tagged with the namespace. Scanning again
+// updates the marks and deletes those of packages that are gone.
+func (m *MarkCli) scanMonorepo(root string, namespace string) {
+	m.handleError(ValidateName(namespace))
+	m.handleError(ValidateTag(namespace))
+	detectors := m.config.MonorepoDetectors
+	if len(detectors) == 0 {
+		detectors = defaultMonorepoDetectors
+	}
+	dirs, err := FindPackages(root, detectors, m.config.Exclude)
+	m.handleError(err)
+	names := PackageNames(root, namespace, dirs)
+	names[root] = namespace
+	before, err := m.db.List()
+	m.handleError(err)
+	for _, dir := range append([]string{root}, dirs...) {
+		marks, err := m.db.List()
+		m.handleError(err)
+		mark := Mark{Path: dir, Name: names[dir], Tags: []string{namespace}}
+		existing := slices.IndexFunc(marks, func(other Mark) bool { return other.Path == dir })
+		if err := CheckNameFree(marks, mark.Name, existing); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", dir, err)
+			mark.Name = ""
+		}
+		if existing >= 0 {
+			if merged := marks[existing].Merge(mark); !marksEqual(merged, marks[existing]) {
+				m.handleError(m.db.Update(existing, merged))
+				fmt.Printf("updated %v (%v)\n", dir, names[dir])
+			}
+			continue
+		}
+		mark.Created = time.Now()
+		m.handleError(m.db.Add(mark))
+		fmt.Printf("added %v (%v)\n", dir, names[dir])
+	}
+	m.evictOverflow()
+	m.keepPinned(before)
+	marks, err := m.db.List()
+	m.handleError(err)
+	var stale []int
+	for index, mark := range marks {
+		if strings.HasPrefix(mark.Name, namespace+"/") && isWithin(mark.Path, root) && !slices.Contains(dirs, mark.Path) {
+			fmt.Printf("deleted %v (%v)\n", mark.Path, mark.Name)
+			stale = append(stale, index)
+		}
+	}
+	if len(stale) > 0 {
+		m.handleError(m.db.DeleteMany(stale))
+	}
 }
 
-type LocalMarkDB struct {
-	DBFile   string
-	filePerm os.FileMode
+// Clone adds a new mark derived from an existing one, carrying over its
+// tags, note, color and pin.
+func (m *MarkCli) Clone(args []string) {
+	flags := newFlagSet("clone")
+	var override Mark
+	flags.StringVar(&override.Name, "name", "", "name of the new mark")
+	flags.Var((*stringList)(&override.Tags), "tag", "add a tag to the new mark")
+	flags.StringVar(&override.Note, "note", "", "note describing the new mark")
+	suffix := flags.String("path-suffix", "", "directory beneath the mark to mark")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("specify a mark"))
+	}
+	if !flagWasSet(flags, "name") && *suffix == "" {
+		m.handleError(errors.New("specify --name or --path-suffix, a mark cannot be cloned onto its own path"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	index, err := ResolveMark(marks, args[0])
+	m.handleError(err)
+	source := marks[index]
+	for _, tag := range override.Tags {
+		m.handleError(ValidateTag(tag))
+	}
+	if filepath.IsAbs(*suffix) {
+		m.handleError(fmt.Errorf("--path-suffix must be relative: %v", *suffix))
+	}
+	clone := Mark{
+		Path:        filepath.Join(source.Path, *suffix),
+		Template:    source.Template,
+		Tags:        slices.Clone(source.Tags),
+		Note:        source.Note,
+		Pinned:      source.Pinned,
+		Color:       source.Color,
+		Created:     time.Now(),
+		MountPoint:  source.MountPoint,
+		MountSource: source.MountSource,
+	}.Merge(override)
+	if flagWasSet(flags, "name") {
+		m.handleError(ValidateName(clone.Name))
+		m.handleError(CheckNameFree(marks, clone.Name, -1))
+	}
+	if existing := slices.IndexFunc(marks, func(other Mark) bool { return other.Path == clone.Path }); existing >= 0 {
+		m.handleError(fmt.Errorf("%v is already marked as [%v]", clone.Path, existing))
+	}
+	target, err := m.expandPath(clone)
+	m.handleError(err)
+	if info, err := os.Stat(target); err != nil {
+		m.handleError(err)
+	} else if !info.IsDir() {
+		m.handleError(fmt.Errorf("not a directory: %v", target))
+	}
+	if !clone.Template {
+		RecordFileID(&clone, target)
+	}
+	m.handleError(m.db.Add(clone))
+	m.evictOverflow()
+	m.keepPinned(marks)
 }
 
-func NewLocalMarkDB() (*LocalMarkDB, error) {
-	dbFile, err := GetLocalMarkFile()
-	if err != nil {
-		return nil, err
+func (m *MarkCli) Color(args []string) {
+	args, err := parseFlags(newFlagSet("color"), args)
+	m.handleError(err)
+	if len(args) != 2 {
+		m.handleError(errors.New("specify a mark and a color"))
 	}
-	return &LocalMarkDB{DBFile: dbFile, filePerm: 0660}, nil
+	index, err := m.resolve(args[0])
+	m.handleError(err)
+	color := args[1]
+	if color == "none" {
+		color = ""
+	} else {
+		m.handleError(ValidateColor(color))
+	}
+	mark, err := m.db.Get(index)
+	m.handleError(err)
+	mark.Color = color
+	m.handleError(m.db.Update(index, mark))
 }
 
-func (l *LocalMarkDB) Get(index int) (string, error) {
-	if index < 0 {
-		return "", errors.New("invalid index")
-	}
-	paths, err := l.List()
-	if err != nil {
-		return "", err
+// Rename names a mark, replacing its name if it has one. The rest of the
+// mark, its timestamps included, is kept.
+func (m *MarkCli) Rename(args []string) {
+	args, err := parseFlags(newFlagSet("rename"), args)
+	m.handleError(err)
+	if len(args) != 2 {
+		m.handleError(errors.New("specify a mark and its new name"))
 	}
-	if index < 0 || index > len(paths)-1 {
-		return "", errors.New("invalid index")
+	index, err := m.resolve(args[0])
+	m.handleError(err)
+	name := args[1]
+	m.handleError(ValidateName(name))
+	marks, err := m.db.List()
+	m.handleError(err)
+	m.handleError(CheckNameFree(marks, name, index))
+	mark := marks[index]
+	if mark.Name == name {
+		return
 	}
-	return paths[index], nil
+	old := mark
+	mark.Name = name
+	m.handleError(m.db.Update(index, mark))
+	fmt.Printf("renamed %v to %v\n", FormatMark(index, old), FormatMark(index, mark))
 }
 
-func (l *LocalMarkDB) Add(path string) error {
-	writtenPaths, err := l.List()
-	if err != nil {
-		return err
+// Current prints the mark containing the working directory, preferring
+// the deepest one. It exits with status 1, printing nothing, when there is
+// none so it can be used in a prompt. As it runs on every prompt, it reads
+// only the local db, which the other backends keep up to date, rather than
+// every backend in turn.
+func (m *MarkCli) Current(args []string) {
+	flags := newFlagSet("current")
+	format := flags.String("format", "{label}", "output format")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
 	}
-	var paths []string
-	paths = append(paths, path)
-	paths = append(paths, writtenPaths...)
-	l.Clear()
-	file, err := os.OpenFile(l.DBFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, l.filePerm)
-	if err != nil {
-		return err
+	cwd, err := workingDir(m.config.Logical)
+	m.handleError(err)
+	marks, err := m.localMarks()
+	m.handleError(err)
+	best := -1
+	for index, mark := range marks {
+		if !mark.IsDeleted() && isWithin(cwd, mark.Path) && (best < 0 || len(mark.Path) > len(marks[best].Path)) {
+			best = index
+		}
 	}
-	defer file.Close()
-	for _, item := range paths {
-		_, err = file.WriteString(item + "\n")
+	if best < 0 {
+		os.Exit(1)
 	}
-	return err
+	mark := marks[best]
+	label := mark.Name
+	if label == "" {
+		label = strconv.Itoa(best)
+	}
+	fmt.Println(strings.NewReplacer(
+		"{index}", strconv.Itoa(best),
+		"{name}", mark.Name,
+		"{label}", label,
+		"{path}", mark.Path,
+		"{short}", ShortenPath(mark.Path, m.config.Roots),
+	).Replace(*format))
 }
 
-func (l *LocalMarkDB) List() ([]string, error) {
-	file, err := os.OpenFile(l.DBFile, os.O_RDONLY|os.O_CREATE, l.filePerm)
+// localMarks lists the marks of the local db when it is one of the
+// backends, and otherwise those of the configured backends.
+func (m *MarkCli) localMarks() ([]Mark, error) {
+	backends := m.config.Backends
+	if m.config.Ephemeral || m.config.DryRun || len(backends) <= 1 || !slices.Contains(backends, "local") {
+		return m.db.List()
+	}
+	db, err := openLocalBackend(m.config)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	var results []string
-	for scanner.Scan() {
-		line := scanner.Text()
-		results = append(results, line)
-	}
-	return results, nil
+	return db.List()
 }
 
-func (l *LocalMarkDB) Delete(suppliedIndex int) error {
-	paths, err := l.List()
-	if err != nil {
-		return err
+// trackRemap runs a command that can renumber the marks. When it does, the
+// previous order is saved for resolve-old and, with --show-remap or the
+// show_remap setting, the changed indexes are printed.
+func (m *MarkCli) trackRemap(command func(args []string), args []string) {
+	show := m.config.ShowRemap
+	if index := slices.Index(args, "--show-remap"); index >= 0 && !slices.Contains(args[:index], "--") {
+		show = true
+		args = slices.Delete(slices.Clone(args), index, index+1)
 	}
-	if suppliedIndex < 0 || suppliedIndex >= len(paths) {
-		return errors.New("invalid index")
+	marks, err := m.db.List()
+	if errors.Is(err, ErrUnavailable) {
+		// The writes of the command are queued, there are no indexes to
+		// track until they are replayed.
+		command(args)
+		return
 	}
-	l.Clear()
-	for index, path := range paths {
-		if index == suppliedIndex {
-			continue
-		}
-		l.Add(path)
+	m.handleError(err)
+	// Deleted marks at the end of the list no longer have an index.
+	before := PathsOf(marks[:liveLength(marks)])
+	command(args)
+	marks, err = m.db.List()
+	if errors.Is(err, ErrUnavailable) {
+		return
+	}
+	m.handleError(err)
+	after := PathsOf(marks[:liveLength(marks)])
+	if slices.Equal(before, after) {
+		return
+	}
+	journalFile, err := m.sidecarFile("_remap")
+	m.handleError(err)
+	m.handleError(NewRemapJournal(journalFile).Save(before))
+	if show {
+		WriteRemap(os.Stdout, Remap(before, after), m.config.Roots)
 	}
-	return nil
-}
-
-func (l *LocalMarkDB) Clear() error {
-	return os.Truncate(l.DBFile, 0)
 }
 
-func GetLocalMarkFile() (string, error) {
-	homeDir, err := os.UserHomeDir()
+// ResolveOld prints the current index of the mark that had the given index
+// before the marks were last renumbered.
+func (m *MarkCli) ResolveOld(args []string) {
+	if len(args) != 1 {
+		m.handleError(errors.New("specify an index"))
+	}
+	old, err := strconv.Atoi(args[0])
 	if err != nil {
-		return "", err
+		m.handleError(fmt.Errorf("invalid index: %v", args[0]))
 	}
-	markFile := filepath.Join(homeDir, ".mark")
-	return markFile, nil
-}
-
-type MarkCli struct {
-	db MarkDB
+	journalFile, err := m.sidecarFile("_remap")
+	m.handleError(err)
+	before, err := NewRemapJournal(journalFile).Load()
+	m.handleError(err)
+	old, err = resolveIndex(len(before), old)
+	m.handleError(err)
+	marks, err := m.db.List()
+	m.handleError(err)
+	index := slices.Index(PathsOf(marks), before[old])
+	if index < 0 {
+		m.handleError(fmt.Errorf("%v has been deleted", before[old]))
+	}
+	fmt.Println(m.formatMark(index, marks[index], false))
 }
 
-func NewMarkCli(db MarkDB) (*MarkCli, error) {
-	return &MarkCli{db: db}, nil
+// Vars lists the variables defined for templated paths, followed by the
+// other variables the marks use, which are read from the environment.
+func (m *MarkCli) Vars(args []string) {
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	names := slices.Collect(maps.Keys(m.config.Vars))
+	for name := range markdb.BuiltinVars() {
+		names = append(names, name)
+	}
+	for _, mark := range marks {
+		names = append(names, markdb.TemplateNames(mark.Path)...)
+	}
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		value, source, ok := markdb.LookupVar(name, m.config.Vars)
+		if !ok {
+			fmt.Printf("%v (unset)\n", name)
+			continue
+		}
+		fmt.Printf("%v = %v (%v)\n", name, value, source)
+	}
 }
 
-func NewMarkCliWithLocalDB() (*MarkCli, error) {
-	db, err := NewLocalMarkDB()
-	if err != nil {
-		return nil, err
+// Flush replays the writes queued while the first of the configured
+// backends was unavailable.
+func (m *MarkCli) Flush(args []string) {
+	if len(args) != 0 {
+		m.handleError(errors.New("invalid number of arguments"))
+	}
+	chain, ok := m.db.(*ChainMarkDB)
+	if !ok {
+		fmt.Println("nothing to flush, writes are only queued for remote backends")
+		return
 	}
-	mark, err := NewMarkCli(db)
+	replayed, queued, conflicts, err := chain.Flush()
+	for _, conflict := range conflicts {
+		fmt.Fprintln(os.Stderr, conflict)
+	}
+	fmt.Printf("replayed %v queued writes\n", replayed)
 	if err != nil {
-		return nil, err
+		m.handleError(fmt.Errorf("%v writes still queued: %v", queued, err))
 	}
-	return mark, nil
-}
-
-func (m *MarkCli) DisplayHelp(args []string) {
-	fmt.Println(`
-Marks the current location.
-If no command is specified, the current working directory is saved to the mark db.
-
-Usage:
-	mark [command]
-
-Available Commands:
-	help            Displays help menu
-	add             Adds the current working directory to mark db(Default action)
-	back   <index>  Prints out the number of directories back based on the index provided
-	clear           Clears out the paths in the mark db
-	delete <index>  Deletes out a path in mark db based on the index provided
-	get    <index>  Get the path in mark db based on the index provided
-	list            List out the all the marked paths by index
-	install         Prints out directions to create move and back commands in your .bashrc
-`)
 }
 
-func (m *MarkCli) Back(args []string) {
-	cwd, err := os.Getwd()
+// Exists exits with status 0 when the mark resolves and 1 otherwise,
+// printing nothing. A path must be marked exactly, queries are not
+// matched.
+func (m *MarkCli) Exists(args []string) {
+	flags := newFlagSet("exists")
+	dir := flags.Bool("dir", false, "also require the marked directory to exist")
+	args, err := parseFlags(flags, args)
 	m.handleError(err)
 	if len(args) != 1 {
-		m.handleError(errors.New("invalid number of args"))
+		m.handleError(errors.New("specify a mark"))
 	}
-	index, err := strconv.Atoi(args[0])
+	marks, err := m.db.List()
 	m.handleError(err)
-	arr := strings.Split(cwd, "/")
-	if index < 0 {
-		m.handleError(errors.New("invalid index"))
+	index, err := ResolveMarkExact(marks, args[0])
+	if err != nil {
+		os.Exit(1)
 	}
-	directoriesBack := len(arr) - index
-	if directoriesBack == 1 {
-		fmt.Println("/")
-		return
-	} else if directoriesBack <= 0 {
-		m.handleError(errors.New("invalid index"))
+	if *dir {
+		path, err := m.expandPath(marks[index])
+		if err != nil {
+			os.Exit(1)
+		}
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			os.Exit(1)
+		}
 	}
-	fmt.Println(strings.Join(arr[0:directoriesBack], "/"))
 }
 
-func (m *MarkCli) List(args []string) {
-	if len(args) != 0 {
-		m.handleError(errors.New("invalid number of arguments"))
+// Init prints the configuration that integrates mark with another tool.
+func (m *MarkCli) Init(args []string) {
+	if len(args) != 1 {
+		m.handleError(errors.New("specify what to integrate with: bash, zsh, fish, powershell or broot"))
+	}
+	switch args[0] {
+	case "bash", "zsh", "fish", "powershell", "pwsh":
+		script, err := ShellInit(args[0], m.config.ZshJumpKey)
+		m.handleError(err)
+		fmt.Print(script)
+	case "broot":
+		marks, err := m.db.List()
+		m.handleError(err)
+		WriteBrootVerbs(os.Stdout, marks)
+	default:
+		m.handleError(fmt.Errorf("unknown integration %q", args[0]))
 	}
-	paths, err := m.db.List()
+}
+
+// Completion prints the completion script for a shell. With --marks it
+// prints the indexes and names of the marks, each followed by a tab and
+// the path, which the scripts complete marks with, and with --ancestors the
+// names of the parent directories, which they complete back and up with.
+func (m *MarkCli) Completion(args []string) {
+	flags := newFlagSet("completion")
+	listMarks := flags.Bool("marks", false, "print the marks to complete")
+	listAncestors := flags.Bool("ancestors", false, "print the names of the parent directories to complete")
+	args, err := parseFlags(flags, args)
 	m.handleError(err)
-	for index, path := range paths {
-		fmt.Printf("[%v] %v\n", index, path)
+	if *listAncestors {
+		cwd, err := os.Getwd()
+		m.handleError(err)
+		for _, name := range AncestorNames(cwd) {
+			fmt.Println(name)
+		}
+		return
+	}
+	if *listMarks {
+		marks, err := m.db.List()
+		m.handleError(err)
+		for index, mark := range marks {
+			if mark.IsDeleted() {
+				continue
+			}
+			fmt.Printf("%v\t%v\n", index, mark.Path)
+			if mark.Name != "" {
+				fmt.Printf("%v\t%v\n", mark.Name, mark.Path)
+			}
+		}
+		return
+	}
+	if len(args) != 1 {
+		m.handleError(fmt.Errorf("specify a shell: %v", strings.Join(completionShells, ", ")))
 	}
+	script, err := CompletionScript(args[0], m.commands)
+	m.handleError(err)
+	fmt.Print(script)
 }
 
-func (m *MarkCli) Add(args []string) {
-	if len(args) != 0 {
-		m.handleError(errors.New("invalid number of arguments"))
+func (m *MarkCli) Zellij(args []string) {
+	flags := newFlagSet("zellij")
+	tab := flags.Bool("tab", false, "open a new tab instead of a pane")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	if len(args) != 1 {
+		m.handleError(errors.New("specify a mark"))
 	}
-	path, err := os.Getwd()
+	index, err := m.resolve(args[0])
 	m.handleError(err)
-	paths, err := m.db.List()
+	mark, err := m.db.Get(index)
 	m.handleError(err)
-	if !slices.Contains(paths, path) {
-		err = m.db.Add(path)
-		if err != nil {
-			m.handleError(errors.New("invalid number of arguments"))
+	m.handleError(OpenZellij(mark.Path, *tab))
+}
+
+// Delete deletes the marks given as indexes, ranges of indexes such as 3-8,
+// names, paths or queries, or the mark of --path or --name. The marks are
+// all resolved before any is deleted, so the indexes refer to the list as
+// it was.
+func (m *MarkCli) Delete(args []string) {
+	flags := newFlagSet("delete")
+	path := flags.String("path", "", "delete the mark of exactly this path")
+	name := flags.String("name", "", "delete the mark with exactly this name")
+	var interactive bool
+	flags.BoolVar(&interactive, "interactive", false, "ask which of the marks to delete")
+	flags.BoolVar(&interactive, "i", false, "ask which of the marks to delete")
+	args, err := parseFlags(flags, args)
+	m.handleError(err)
+	given := min(len(args), 1)
+	for _, flag := range []string{"path", "name"} {
+		if flagWasSet(flags, flag) {
+			given++
 		}
-		return
 	}
-	fmt.Println("path already exists. Moving to top.")
-	m.db.Clear()
-	m.db.Add(path)
-	for _, item := range paths {
-		if item == path {
-			continue
+	if given != 1 && !(interactive && given == 0) {
+		m.handleError(errors.New("specify indexes, --path or --name"))
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	var indexes []int
+	switch {
+	case interactive && len(args) == 0:
+		for index := range liveLength(marks) {
+			indexes = append(indexes, index)
 		}
-		err := m.db.Add(item)
+	case flagWasSet(flags, "path"):
+		index := m.findPath(marks, *path)
+		if index < 0 {
+			m.handleError(fmt.Errorf("%v is not marked", *path))
+		}
+		indexes = append(indexes, index)
+	case flagWasSet(flags, "name"):
+		index, _, err := m.db.GetByName(*name)
 		m.handleError(err)
+		indexes = append(indexes, index)
+	default:
+		for _, arg := range args {
+			if from, to, ok := ParseIndexRange(arg); ok {
+				if from > to {
+					m.handleError(fmt.Errorf("invalid range %v, %v is after %v", arg, from, to))
+				}
+				for _, end := range []int{from, to} {
+					_, err := ResolveMark(marks, strconv.Itoa(end))
+					m.handleError(err)
+				}
+				for index := from; index <= to; index++ {
+					if !marks[index].IsDeleted() {
+						indexes = append(indexes, index)
+					}
+				}
+				continue
+			}
+			index, err := m.resolve(arg)
+			m.handleError(err)
+			indexes = append(indexes, index)
+		}
 	}
+	if interactive {
+		indexes = m.chooseDeletions(marks, indexes)
+	}
+	m.trashMarks(marks, indexes)
+	m.trashExpired(false)
+	m.purgeExpiredTrash()
 }
 
-func (m *MarkCli) Get(args []string) {
-	if len(args) > 1 {
-		m.handleError(errors.New("invalid number of arguments"))
+// chooseDeletions asks about each of the marks at indexes whether to delete
+// it, returning the indexes of those chosen once the user confirms them
+// all, so that they are deleted together.
+func (m *MarkCli) chooseDeletions(marks []Mark, indexes []int) []int {
+	if m.config.NonInteractive {
+		m.handleError(errors.New("delete -i needs a terminal to ask on"))
 	}
-	index := 0
-	var err error
-	if len(args) == 1 {
-		index, err = strconv.Atoi(args[0])
-		if err != nil {
-			m.handleError(errors.New("index is not a number"))
+	prompter := NewPrompter(os.Stdin, os.Stdout)
+	var chosen []int
+ask:
+	for _, index := range indexes {
+		mark := marks[index]
+		mark.Path = ShortenPath(mark.Path, m.config.Roots)
+		for {
+			switch strings.ToLower(prompter.Ask(fmt.Sprintf("Delete %v? y/n/q", FormatMark(index, mark)), "n")) {
+			case "y", "yes":
+				chosen = append(chosen, index)
+			case "n", "no":
+			case "q", "quit":
+				break ask
+			default:
+				continue
+			}
+			break
 		}
 	}
-	path, err := m.db.Get(index)
-	m.handleError(err)
-	fmt.Println(path)
+	if len(chosen) == 0 || !prompter.Confirm(fmt.Sprintf("Delete the %v marks chosen?", len(chosen)), true) {
+		return nil
+	}
+	return chosen
 }
 
-func (m *MarkCli) Install(args []string) {
-	fmt.Print(`
-Run the following commands to create a move function based on the index provided:
+// trashMarks moves the marks at indexes into marks, the marks in the db,
+// to the trash. The marks are only flagged as deleted, and moved out of the
+// way to the end of the list, until gc purges them. Adding them again
+// restores them.
+func (m *MarkCli) trashMarks(marks []Mark, indexes []int) {
+	m.handleError(m.trash(marks, indexes))
+}
 
-1. Add the following code to ~/.bashrc
+// trash is trashMarks returning the error, for the ui, which has to leave
+// the terminal as it found it before exiting.
+func (m *MarkCli) trash(marks []Mark, indexes []int) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+	paths := map[string]bool{}
+	for _, index := range indexes {
+		paths[marks[index].Path] = true
+	}
+	// The marks are written at once, as they are now, so that either all
+	// of them are trashed or none is, and undo brings them all back.
+	current, err := m.db.List()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var kept, trashed []Mark
+	for _, mark := range current {
+		if paths[mark.Path] && !mark.IsDeleted() {
+			mark.Deleted = now
+			trashed = append(trashed, mark)
+		} else {
+			kept = append(kept, mark)
+		}
+	}
+	slices.Reverse(trashed)
+	return m.db.Replace(append(kept, trashed...))
+}
 
-move() {
-	local readonly DEST=$(mark get $1)
-	if [[ ! -z $DEST ]]; then
-		cd $DEST
-	fi
+// evictOverflow moves the least recently used marks that are not pinned to
+// the trash while there are more marks than the max_entries setting allows.
+func (m *MarkCli) evictOverflow() {
+	if m.config.MaxEntries == 0 {
+		return
+	}
+	marks, err := m.db.List()
+	m.handleError(err)
+	evicted := LeastRecentlyUsed(marks, m.config.MaxEntries)
+	for _, index := range evicted {
+		fmt.Printf("evicted %v, there are more than max_entries (%v) marks\n", ShortenPath(marks[index].Path, m.config.Roots), m.config.MaxEntries)
+	}
+	if len(evicted) > 0 {
+		m.trashMarks(marks, evicted)
+	}
 }
 
-back() {
-	local readonly DEST=$(mark back $1)
-	if [[ ! -z $DEST ]]; then
-		cd $DEST
-	fi
+// findPath returns the index of the live mark of path, or -1. Unlike
+// resolve it never guesses: path must be the path of the mark, although it
+// may start with ~, be relative or lead there through symlinks.
+func (m *MarkCli) findPath(marks []Mark, path string) int {
+	candidates := []string{path}
+	if absolute, err := filepath.Abs(markdb.ExpandHome(path)); err == nil {
+		candidates = append(candidates, absolute)
+		if resolved, err := filepath.EvalSymlinks(absolute); err == nil {
+			candidates = append(candidates, resolved)
+		}
+	}
+	return slices.IndexFunc(marks[:liveLength(marks)], func(mark Mark) bool {
+		return !mark.IsDeleted() && slices.Contains(candidates, mark.Path)
+	})
 }
 
-2. Run the following command
-source ~/.bashrc
-`)
+// resolve resolves a mark identifier (index, name, path or query) to an
+// index using ResolveMark, looking names up directly in the db first.
+func (m *MarkCli) resolve(identifier string) (int, error) {
+	if ValidateName(identifier) == nil {
+		index, _, err := m.db.GetByName(identifier)
+		if err == nil {
+			return index, nil
+		} else if !errors.Is(err, markdb.ErrNameNotFound) {
+			return 0, err
+		}
+	}
+	marks, err := m.db.List()
+	if err != nil {
+		return 0, err
+	}
+	return ResolveMark(marks, identifier)
 }
 
-func (m *MarkCli) Clear(args []string) {
-	err := m.db.Clear()
-	m.handleError(err)
+// splitSubpath is SplitSubpath, except that a namespaced name such as
+// "repo/api" is not split, so "repo/api/cmd" is the subpath cmd of the mark
+// named repo/api.
+func (m *MarkCli) splitSubpath(identifier string) (string, string) {
+	for end := len(identifier); end > 0; end = strings.LastIndex(identifier[:end], "/") {
+		name := identifier[:end]
+		if !strings.Contains(name, "/") || ValidateName(name) != nil {
+			continue
+		}
+		if _, _, err := m.db.GetByName(name); err == nil {
+			return name, strings.TrimPrefix(identifier[end:], "/")
+		}
+	}
+	return SplitSubpath(identifier)
 }
 
-func (m *MarkCli) Delete(args []string) {
-	if len(args) != 1 {
-		m.handleError(errors.New("specify index"))
+// sidecarFile returns the location of a file stored alongside the db, such
+// as ~/.mark_visits.
+func (m *MarkCli) sidecarFile(suffix string) (string, error) {
+	return SidecarFile(m.config, suffix)
+}
+
+// workingDir returns the current working directory. When logical is set
+// $PWD is preferred, preserving the symlinked path the user typed, as long
+// as it still refers to the working directory.
+func workingDir(logical bool) (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil || !logical {
+		return cwd, err
 	}
-	index, err := strconv.Atoi(args[0])
-	m.handleError(err)
-	err = m.db.Delete(index)
-	m.handleError(err)
+	pwd := os.Getenv("PWD")
+	if !filepath.IsAbs(pwd) {
+		return cwd, nil
+	}
+	pwdInfo, err := os.Stat(pwd)
+	if err != nil {
+		return cwd, nil
+	}
+	cwdInfo, err := os.Stat(cwd)
+	if err != nil || !os.SameFile(pwdInfo, cwdInfo) {
+		return cwd, nil
+	}
+	return filepath.Clean(pwd), nil
+}
+
+// canonicalDir returns the absolute path of the directory at path, which
+// may start with ~ or be relative to the working directory. Symlinks are
+// resolved unless logical is set, as they are for the working directory.
+func canonicalDir(path string, logical bool) (string, error) {
+	path = markdb.ExpandHome(path)
+	if !filepath.IsAbs(path) {
+		cwd, err := workingDir(logical)
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(cwd, path)
+	}
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("not a directory: %v", path)
+	}
+	if logical {
+		return path, nil
+	}
+	return filepath.EvalSymlinks(path)
 }
 
 func (m *MarkCli) handleError(err error) {
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		m.closePager()
+		if m.config != nil && m.config.NonInteractive {
+			fmt.Fprintf(os.Stderr, "mark: error: %v\n", errorLineEscaper.Replace(err.Error()))
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		WaitWebhooks()
 		os.Exit(1)
 	}
 }
 
+// closePager closes the pager, if one is running, so that the output is
+// shown before the command returns or exits.
+func (m *MarkCli) closePager() {
+	if m.pager != nil {
+		m.pager.Close()
+		m.pager = nil
+	}
+}
+
+// errorLineEscaper keeps a multi-line error on a single line in
+// non-interactive mode, so scripts can read one error per line.
+var errorLineEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+
 func main() {
-	mark, err := NewMarkCliWithLocalDB()
+	global := newFlagSet("mark")
+	timeout := global.Duration("timeout", 0, "fail when storage does not respond in time")
+	ephemeral := global.Bool("ephemeral", os.Getenv("MARK_EPHEMERAL") == "1", "keep the marks in memory only")
+	runAs := global.String("user", "", "use the marks of this user when running as root")
+	profile := global.String("profile", "", "use the marks of this profile")
+	dryRun := global.Bool("dry-run", false, "print what the command would change without changing anything")
+	nonInteractive := global.Bool("non-interactive", os.Getenv("MARK_NON_INTERACTIVE") == "1" || !IsTerminal(os.Stdin), "never prompt, color or page")
+	err := global.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		err = global.Parse([]string{"help"})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// --dry-run may also be given among the arguments of the commands that
+	// support it.
+	rest := global.Args()
+	if len(rest) > 0 && dryRunCommands[rest[0]] {
+		var given bool
+		rest, given = cutDryRunFlag(rest)
+		*dryRun = *dryRun || given
+	}
+	if err := switchUser(*runAs); err != nil {
+		fmt.Fprintf(os.Stderr, "--user: %v\n", err)
+		os.Exit(1)
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mark: %v\n", err)
+		os.Exit(1)
+	}
+	config.Ephemeral = *ephemeral
+	config.DryRun = *dryRun
+	config.NonInteractive = *nonInteractive
+	if err := SelectProfile(config, *profile); err != nil {
+		fmt.Fprintf(os.Stderr, "--profile: %v\n", err)
+		os.Exit(1)
+	}
+	colorSetting = config.Color
+	if config.NonInteractive {
+		// Colors and the pager are only used on a terminal.
+		stdoutIsTerminal = false
+	}
+	if !flagWasSet(global, "timeout") {
+		*timeout = config.Timeout
+	}
+	mark, err := NewMarkCliFromConfig(config)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "mark: %v\n", err)
+		os.Exit(1)
 	}
+	defer WaitWebhooks()
 	commands := map[string]func(args []string){
-		"add":     func(args []string) { mark.Add(args) },
-		"back":    func(args []string) { mark.Back(args) },
-		"clear":   func(args []string) { mark.Clear(args) },
-		"delete":  func(args []string) { mark.Delete(args) },
-		"get":     func(args []string) { mark.Get(args) },
-		"help":    func(args []string) { mark.DisplayHelp(args) },
-		"install": func(args []string) { mark.Install(args) },
-		"list":    func(args []string) { mark.List(args) },
+		"add":            func(args []string) { mark.Add(args) },
+		"back":           func(args []string) { mark.Back(args) },
+		"backup":         func(args []string) { mark.Backup(args) },
+		"bench":          func(args []string) { mark.Bench(args) },
+		"clear":          func(args []string) { mark.Clear(args) },
+		"clone":          func(args []string) { mark.Clone(args) },
+		"color":          func(args []string) { mark.Color(args) },
+		"completion":     func(args []string) { mark.Completion(args) },
+		"config":         func(args []string) { mark.Config(args) },
+		"current":        func(args []string) { mark.Current(args) },
+		"delete":         func(args []string) { mark.Delete(args) },
+		"down":           func(args []string) { mark.Down(args) },
+		"events":         func(args []string) { mark.Events(args) },
+		"exec":           func(args []string) { mark.Exec(args) },
+		"exists":         func(args []string) { mark.Exists(args) },
+		"export":         func(args []string) { mark.Export(args) },
+		"flush":          func(args []string) { mark.Flush(args) },
+		"gc":             func(args []string) { mark.GC(args) },
+		"get":            func(args []string) { mark.Get(args) },
+		"help":           func(args []string) { mark.DisplayHelp(args) },
+		"import":         func(args []string) { mark.Import(args) },
+		"init":           func(args []string) { mark.Init(args) },
+		"install":        func(args []string) { mark.Install(args) },
+		"list":           func(args []string) { mark.List(args) },
+		"migrate-legacy": func(args []string) { mark.MigrateLegacy(args) },
+		"mount":          func(args []string) { mark.Mount(args) },
+		"move-to":        func(args []string) { mark.MoveTo(args) },
+		"pick":           func(args []string) { mark.Pick(args) },
+		"pin":            func(args []string) { mark.Pin(args) },
+		"profile":        func(args []string) { mark.Profile(args) },
+		"prune":          func(args []string) { mark.Prune(args) },
+		"rel":            func(args []string) { mark.Rel(args) },
+		"relink":         func(args []string) { mark.Relink(args) },
+		"rename":         func(args []string) { mark.Rename(args) },
+		"report":         func(args []string) { mark.Report(args) },
+		"resolve-old":    func(args []string) { mark.ResolveOld(args) },
+		"restore":        func(args []string) { mark.Restore(args) },
+		"scan":           func(args []string) { mark.Scan(args) },
+		"search":         func(args []string) { mark.Search(args) },
+		"serve":          func(args []string) { mark.Serve(args) },
+		"session":        func(args []string) { mark.Session(args) },
+		"setup":          func(args []string) { mark.Setup(args) },
+		"suggest":        func(args []string) { mark.Suggest(args) },
+		"swap":           func(args []string) { mark.Swap(args) },
+		"sync":           func(args []string) { mark.Sync(args) },
+		"tag":            func(args []string) { mark.Tag(args) },
+		"top":            func(args []string) { mark.Top(args) },
+		"topN":           func(args []string) { mark.TopN(args) },
+		"trash":          func(args []string) { mark.Trash(args) },
+		"ui":             func(args []string) { mark.UI(args) },
+		"unarchive":      func(args []string) { mark.Unarchive(args) },
+		"undo":           func(args []string) { mark.Undo(args) },
+		"up":             func(args []string) { mark.Up(args) },
+		"use":            func(args []string) { mark.Use(args) },
+		"vars":           func(args []string) { mark.Vars(args) },
+		"visit":          func(args []string) { mark.Visit(args) },
+		"zellij":         func(args []string) { mark.Zellij(args) },
 	}
+	mark.commands = slices.Sorted(maps.Keys(commands))
 	// If no arguments are specified then the default action is to
-	// add the current working directory
-	args := os.Args
+	// add the current working directory, unless default_command says
+	// otherwise
+	args := append([]string{os.Args[0]}, rest...)
+	if *timeout > 0 {
+		mark.db = markdb.NewTimeoutMarkDB(*timeout, mark.db)
+	}
 	if len(args) == 1 {
-		args = append(args, "add")
+		if _, ok := commands[config.DefaultCommand]; !ok {
+			fmt.Fprintf(os.Stderr, "default_command: unknown command %q\n", config.DefaultCommand)
+			os.Exit(1)
+		}
+		args = append(args, config.DefaultCommand)
 	}
 
 	// If the command used is not one that is defined
@@ -307,9 +3328,25 @@ func main() {
 		command = commands["help"]
 	}
 
+	if args[1] != "migrate-legacy" {
+		mark.warnLegacyDB()
+	}
+
 	var commandArgs []string
 	if len(args) >= 2 {
 		commandArgs = args[2:]
 	}
+	if config.DryRun {
+		if !dryRunCommands[args[1]] {
+			fmt.Fprintf(os.Stderr, "--dry-run is not supported by %v\n", args[1])
+			os.Exit(1)
+		}
+		mark.dryRun(command, commandArgs)
+		return
+	}
+	if remapCommands[args[1]] {
+		mark.trackRemap(command, commandArgs)
+		return
+	}
 	command(commandArgs)
 }
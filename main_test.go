@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+func TestConfiguredDBConcurrentUse(t *testing.T) {
+	config := NewDefaultConfig()
+	config.DBFile = filepath.Join(t.TempDir(), "marks")
+	useConcurrently(t, config)
+}
+
+func TestChainMarkDBConcurrentUse(t *testing.T) {
+	served, err := markdb.NewLocalMarkDB(filepath.Join(t.TempDir(), "served"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewMarkServer(served, ""))
+	defer server.Close()
+	config := NewDefaultConfig()
+	config.DBFile = filepath.Join(t.TempDir(), "marks")
+	config.Backends = []string{"remote", "local"}
+	config.RemoteURL = server.URL
+	useConcurrently(t, config)
+}
+
+func TestChainMarkDBConcurrentQueuedUse(t *testing.T) {
+	// A server that is gone, so that every write is queued.
+	server := httptest.NewServer(nil)
+	server.Close()
+	config := NewDefaultConfig()
+	config.DBFile = filepath.Join(t.TempDir(), "marks")
+	config.Backends = []string{"remote", "local"}
+	config.RemoteURL = server.URL
+	withStderr(t)
+	useConcurrently(t, config)
+}
+
+// useConcurrently adds, lists and looks up marks from several goroutines
+// at once, through all the wrappers of the db of config, and checks that
+// none of the marks added was lost.
+func useConcurrently(t *testing.T, config *Config) {
+	t.Helper()
+	const writers, adds = 4, 10
+	// The mark looked up is added beforehand, by a db of its own so that
+	// the first change, which the undo journal saves, is made concurrently.
+	setup, err := OpenConfiguredDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setup.Add(Mark{Path: "/initial", Name: "initial"}); err != nil {
+		t.Fatal(err)
+	}
+	configured, err := OpenConfiguredDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := markdb.NewTimeoutMarkDB(10*time.Second, configured)
+	var wg sync.WaitGroup
+	for writer := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range adds {
+				if err := db.Add(Mark{Path: fmt.Sprintf("/writer%v/%v", writer, i)}); err != nil {
+					t.Error(err)
+				}
+				if _, err := db.List(); err != nil {
+					t.Error(err)
+				}
+				// Indexes shift under the other writers, so only the
+				// name is looked up.
+				if _, _, err := db.GetByName("initial"); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	marks, err := db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]bool{}
+	for _, mark := range marks {
+		paths[mark.Path] = true
+	}
+	for writer := range writers {
+		for i := range adds {
+			if path := fmt.Sprintf("/writer%v/%v", writer, i); !paths[path] {
+				t.Errorf("%v was lost", path)
+			}
+		}
+	}
+}
+
+func TestDeleteInteractiveUndoesAtOnce(t *testing.T) {
+	config := NewDefaultConfig()
+	config.DBFile = filepath.Join(t.TempDir(), "marks")
+	// The marks are added, and deleted, by separate commands, each with
+	// its own db, since undo reverts one command at a time.
+	setup, err := OpenConfiguredDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		if err := setup.Add(Mark{Path: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := OpenConfiguredDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mark, err := NewMarkCli(db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete the first and third marks, keep the others, then confirm.
+	withStdin(t, "y\nn\ny\nn\n\n")
+	withStdout(t)
+	mark.Delete([]string{"-i"})
+
+	marks, err := db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var live []string
+	for _, mark := range marks {
+		if !mark.IsDeleted() {
+			live = append(live, mark.Path)
+		}
+	}
+	if want := []string{"/c", "/a"}; !slices.Equal(live, want) {
+		t.Fatalf("got %v left, want %v", live, want)
+	}
+
+	mark.Undo(nil)
+	after, err := db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(after, before, marksEqual) {
+		t.Fatalf("got %v after one undo, want %v", after, before)
+	}
+}
+
+func TestTrashPublishesDeleteEvents(t *testing.T) {
+	bus := &EventBus{}
+	var deleted []string
+	bus.Subscribe(func(event Event) {
+		if event.Type == EventDeleted {
+			deleted = append(deleted, event.Mark.Path)
+		}
+	})
+	db := NewEventMarkDB(markdb.NewMemoryMarkDB(), bus)
+	for _, path := range []string{"/a", "/b", "/c"} {
+		if err := db.Add(Mark{Path: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mark, err := NewMarkCli(db, NewDefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	marks, err := db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mark.trash(marks, []int{0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	// Trashing them again is not another deletion.
+	if err := mark.trash(marks, []int{0}); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(deleted)
+	if want := []string{"/a", "/c"}; !slices.Equal(deleted, want) {
+		t.Fatalf("got delete events for %v, want %v", deleted, want)
+	}
+}
+
+func TestLocalMarksReadsOnlyTheLocalDB(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	config := NewDefaultConfig()
+	config.DBFile = filepath.Join(t.TempDir(), "marks")
+	config.Backends = []string{"remote", "local"}
+	config.RemoteURL = server.URL
+	local, err := markdb.NewLocalMarkDB(config.DBFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Add(Mark{Path: "/a"}); err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenConfiguredDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mark, err := NewMarkCli(db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marks, err := mark.localMarks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marks) != 1 || marks[0].Path != "/a" {
+		t.Fatalf("got %v, want the mark of the local db", marks)
+	}
+	if requests != 0 {
+		t.Fatalf("the remote backend was sent %v requests", requests)
+	}
+}
+
+// withStdin makes input the standard input until the test ends.
+func withStdin(t *testing.T, input string) {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	stdin := os.Stdin
+	os.Stdin = reader
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		reader.Close()
+	})
+}
+
+// withStdout discards the standard output until the test ends.
+func withStdout(t *testing.T) {
+	t.Helper()
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
+
+// withStderr discards the standard error until the test ends.
+func withStderr(t *testing.T) {
+	t.Helper()
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = devNull
+	t.Cleanup(func() {
+		os.Stderr = stderr
+		devNull.Close()
+	})
+}
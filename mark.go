@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// liveLength returns the length of marks without the deleted marks at its
+// end.
+func liveLength(marks []Mark) int {
+	length := len(marks)
+	for length > 0 && marks[length-1].IsDeleted() {
+		length--
+	}
+	return length
+}
+
+// LeastRecentlyUsed returns the indexes of the marks to evict so that no
+// more than max remain, the least recently used first. Deleted marks do
+// not count and pinned marks are never evicted.
+func LeastRecentlyUsed(marks []Mark, max int) []int {
+	var candidates []int
+	live := 0
+	for index, mark := range marks {
+		if mark.IsDeleted() {
+			continue
+		}
+		live++
+		if !mark.Pinned {
+			candidates = append(candidates, index)
+		}
+	}
+	if live <= max {
+		return nil
+	}
+	// Marks used equally recently are evicted from the bottom of the list.
+	slices.SortStableFunc(candidates, func(a, b int) int {
+		return cmp.Or(marks[a].LastActive().Compare(marks[b].LastActive()), cmp.Compare(b, a))
+	})
+	return candidates[:min(live-max, len(candidates))]
+}
+
+// KeepPinned returns after, the marks following a change, with the marks
+// that were pinned in before back at the indexes they had there, except for
+// those in moved, which were moved on purpose.
+func KeepPinned(before []Mark, after []Mark, moved ...string) []Mark {
+	slots := map[string]int{}
+	for index, mark := range before[:liveLength(before)] {
+		if mark.Pinned && !slices.Contains(moved, mark.Path) {
+			slots[mark.Path] = index
+		}
+	}
+	var pinned, rest []Mark
+	for _, mark := range after {
+		if _, ok := slots[mark.Path]; ok && mark.Pinned && !mark.IsDeleted() {
+			pinned = append(pinned, mark)
+		} else {
+			rest = append(rest, mark)
+		}
+	}
+	// Placed from the top down, each pinned mark only shifts the ones
+	// below it.
+	slices.SortFunc(pinned, func(a, b Mark) int { return cmp.Compare(slots[a.Path], slots[b.Path]) })
+	live := liveLength(rest)
+	for _, mark := range pinned {
+		index := min(slots[mark.Path], live)
+		rest = slices.Insert(rest, index, mark)
+		live++
+	}
+	return rest
+}
+
+// sortOrders compare marks for the orders list can show them in. Marks
+// that compare equal stay in index order.
+var sortOrders = map[string]func(a Mark, b Mark) int{
+	"index": func(a Mark, b Mark) int { return 0 },
+	"name": func(a Mark, b Mark) int {
+		return cmp.Compare(strings.ToLower(sortName(a)), strings.ToLower(sortName(b)))
+	},
+	"path":    func(a Mark, b Mark) int { return cmp.Compare(a.Path, b.Path) },
+	"recent":  func(a Mark, b Mark) int { return b.LastActive().Compare(a.LastActive()) },
+	"created": func(a Mark, b Mark) int { return a.Created.Compare(b.Created) },
+}
+
+// sortName is the name of the mark, or else the name of its directory.
+func sortName(mark Mark) string {
+	if mark.Name != "" {
+		return mark.Name
+	}
+	return filepath.Base(mark.Path)
+}
+
+// SortedIndexes returns the indexes of marks in the given sort order.
+func SortedIndexes(marks []Mark, order string) ([]int, error) {
+	compare, ok := sortOrders[order]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort order %q, use one of %v", order, strings.Join(slices.Sorted(maps.Keys(sortOrders)), ", "))
+	}
+	indexes := make([]int, len(marks))
+	for index := range indexes {
+		indexes[index] = index
+	}
+	slices.SortStableFunc(indexes, func(a int, b int) int {
+		return compare(marks[a], marks[b])
+	})
+	return indexes, nil
+}
+
+// ValidateTag checks that tag can be stored and listed as #tag.
+func ValidateTag(tag string) error {
+	if tag == "" {
+		return errors.New("tag cannot be empty")
+	}
+	if strings.ContainsFunc(tag, unicode.IsSpace) || strings.HasPrefix(tag, "#") {
+		return fmt.Errorf("invalid tag %q, tags cannot contain spaces or start with #", tag)
+	}
+	return nil
+}
+
+// filterMarks yields the marks for which match returns true.
+func filterMarks(marks []Mark, match func(Mark) bool) iter.Seq[Mark] {
+	return func(yield func(Mark) bool) {
+		for _, mark := range marks {
+			if match(mark) && !yield(mark) {
+				return
+			}
+		}
+	}
+}
+
+// ValidateName checks that name can be used to refer to a mark. Names
+// cannot look like an index or a path, but can be namespaced with "/",
+// e.g. "repo/api".
+func ValidateName(name string) error {
+	if name == "" {
+		return errors.New("name cannot be empty")
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		return errors.New("name cannot be a number")
+	}
+	if strings.HasPrefix(name, "@") {
+		return errors.New("name cannot start with @, which refers to the id of a mark")
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.Contains(part, "\\") || strings.HasPrefix(part, "~") || strings.HasPrefix(part, ".") {
+			return errors.New("name cannot be a path")
+		}
+	}
+	return nil
+}
+
+// CheckNameFree returns an error if a mark other than the one at except
+// is already called name.
+func CheckNameFree(marks []Mark, name string, except int) error {
+	if name == "" {
+		return nil
+	}
+	for index, other := range marks {
+		if other.Name == name && index != except && !other.IsDeleted() {
+			return fmt.Errorf("name %q is already used by [%v] %v", name, index, other.Path)
+		}
+	}
+	return nil
+}
+
+// PathsOf returns the paths of marks.
+func PathsOf(marks []Mark) []string {
+	paths := make([]string, len(marks))
+	for index, mark := range marks {
+		paths[index] = mark.Path
+	}
+	return paths
+}
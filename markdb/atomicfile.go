@@ -0,0 +1,58 @@
+package markdb
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic replaces path with the output of write. The output goes
+// to a temporary file in the same directory, which is synced and renamed
+// over path, so path always holds either the old or the new contents even
+// if mark crashes or the machine loses power mid-write. A symlinked path
+// has its target replaced, keeping the link. The new file keeps the
+// permissions and, when running as root, the owner of the old one.
+func WriteFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	owner := chownToOwner
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+		if uid, gid, ok := FileOwner(info); ok && os.Geteuid() == 0 {
+			owner = func(name string) error { return os.Chown(name, uid, gid) }
+		}
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			temp.Close()
+			os.Remove(temp.Name())
+		}
+	}()
+	writer := bufio.NewWriter(temp)
+	if err := write(writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := temp.Chmod(perm); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	if err := owner(temp.Name()); err != nil {
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
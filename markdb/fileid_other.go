@@ -0,0 +1,15 @@
+//go:build !unix
+
+package markdb
+
+import "os"
+
+// FileID is not available on this platform.
+func FileID(path string) (device uint64, inode uint64, ok bool) {
+	return 0, 0, false
+}
+
+// FileOwner is not available on this platform.
+func FileOwner(info os.FileInfo) (uid int, gid int, ok bool) {
+	return 0, 0, false
+}
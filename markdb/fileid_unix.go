@@ -0,0 +1,31 @@
+//go:build unix
+
+package markdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileID returns the device and inode numbers of path, which identify a
+// directory even after it is moved or renamed within its filesystem.
+func FileID(path string) (device uint64, inode uint64, ok bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(stat.Dev), uint64(stat.Ino), true
+}
+
+// FileOwner returns the user and group owning the file described by info.
+func FileOwner(info os.FileInfo) (uid int, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
@@ -0,0 +1,222 @@
+package markdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dbFormatVersion is the version of the local db format written by this
+// version of mark. Version 1 files have no header and store one plain path
+// per line. Version 2 files start with a "# mark-db v2" header followed by
+// one JSON encoded mark per line. Version 3 flags the templated marks,
+// which version 2 told apart by the placeholders in their paths.
+const dbFormatVersion = 3
+
+const dbHeaderPrefix = "# mark-db v"
+
+// dbMigrations upgrade the lines of a db file from the version they are
+// keyed by to the next version.
+var dbMigrations = map[int]func(lines []string) ([]string, error){
+	1: migrateV1ToV2,
+	2: migrateV2ToV3,
+}
+
+func migrateV1ToV2(lines []string) ([]string, error) {
+	var migrated []string
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		mark, err := DecodeMark(line)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(mark)
+		if err != nil {
+			return nil, err
+		}
+		migrated = append(migrated, string(encoded))
+	}
+	return migrated, nil
+}
+
+func migrateV2ToV3(lines []string) ([]string, error) {
+	var migrated []string
+	for _, line := range lines {
+		mark, err := DecodeMark(line)
+		if err != nil {
+			return nil, err
+		}
+		if !LooksLikeTemplate(mark.Path) {
+			migrated = append(migrated, line)
+			continue
+		}
+		mark.Template = true
+		encoded, err := EncodeMark(mark)
+		if err != nil {
+			return nil, err
+		}
+		migrated = append(migrated, encoded)
+	}
+	return migrated, nil
+}
+
+// readDBLines returns the format version and the record lines of a db
+// file. Empty files are of the current version.
+func readDBLines(reader io.Reader) (int, []string, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+	version := 0
+	var lines []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if version == 0 {
+			if rest, ok := strings.CutPrefix(line, dbHeaderPrefix); ok {
+				parsed, err := strconv.Atoi(rest)
+				if err != nil {
+					return 0, nil, fmt.Errorf("invalid db header %q", line)
+				}
+				version = parsed
+				continue
+			}
+			version = 1
+		}
+		lines = append(lines, line)
+	}
+	if version == 0 {
+		version = dbFormatVersion
+	}
+	return version, lines, scanner.Err()
+}
+
+func writeDBLines(writer io.Writer, lines []string) error {
+	buffered := bufio.NewWriter(writer)
+	fmt.Fprintf(buffered, "%v%v\n", dbHeaderPrefix, dbFormatVersion)
+	for _, line := range lines {
+		buffered.WriteString(line)
+		buffered.WriteByte('\n')
+	}
+	return buffered.Flush()
+}
+
+// WriteDB writes the marks in the current db format.
+func WriteDB(writer io.Writer, marks []Mark) error {
+	var lines []string
+	for _, mark := range marks {
+		line, err := EncodeMark(mark)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	return writeDBLines(writer, lines)
+}
+
+// migrateDBLines upgrades lines from version to the current format.
+func migrateDBLines(version int, lines []string) ([]string, error) {
+	if version > dbFormatVersion {
+		return nil, fmt.Errorf("db format v%v is newer than this version of mark supports (v%v)", version, dbFormatVersion)
+	}
+	for ; version < dbFormatVersion; version++ {
+		migrate, ok := dbMigrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration from db format v%v", version)
+		}
+		var err error
+		lines, err = migrate(lines)
+		if err != nil {
+			return nil, fmt.Errorf("migrating db format v%v: %v", version, err)
+		}
+	}
+	return lines, nil
+}
+
+// ReadDBFile reads the marks of a db file in any format, without upgrading
+// the file.
+func ReadDBFile(path string) ([]Mark, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	marks, err := ReadDB(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return marks, nil
+}
+
+// ReadDB reads the marks of a db in any format.
+func ReadDB(reader io.Reader) ([]Mark, error) {
+	version, lines, err := readDBLines(reader)
+	if err == nil {
+		lines, err = migrateDBLines(version, lines)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var marks []Mark
+	for _, line := range lines {
+		mark, err := DecodeMark(line)
+		if err != nil {
+			return nil, err
+		}
+		marks = append(marks, mark)
+	}
+	return marks, nil
+}
+
+// migrateDBFile upgrades the db file at path to the current format, first
+// copying the original to path.v<version>.bak, or to
+// path.v<version>.<time>.bak when that exists already.
+func migrateDBFile(path string, perm os.FileMode) error {
+	original, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	version, lines, err := readDBLines(strings.NewReader(string(original)))
+	if err != nil {
+		return err
+	}
+	if version == dbFormatVersion {
+		return nil
+	}
+	migrated, err := migrateDBLines(version, lines)
+	if err != nil {
+		return err
+	}
+	// An earlier copy, such as of a file migrated before being restored
+	// from an old backup, is kept rather than overwritten.
+	backup := fmt.Sprintf("%v.v%v.bak", path, version)
+	file, err := OpenOwned(backup, os.O_EXCL|os.O_WRONLY|os.O_CREATE, perm)
+	if os.IsExist(err) {
+		backup = fmt.Sprintf("%v.v%v.%v.bak", path, version, time.Now().Format("20060102-150405.000000000"))
+		file, err = OpenOwned(backup, os.O_EXCL|os.O_WRONLY|os.O_CREATE, perm)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(original)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = WriteFileAtomic(path, perm, func(w io.Writer) error {
+		return writeDBLines(w, migrated)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "migrated %v to format v%v, the original is saved as %v\n", path, dbFormatVersion, backup)
+	return nil
+}
@@ -0,0 +1,60 @@
+package markdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateDBFileKeepsEarlierBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "marks")
+	old := []string{"# mark-db v2\n{\"path\":\"/a\"}\n", "# mark-db v2\n{\"path\":\"/b\"}\n"}
+	for _, content := range old {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := migrateDBFile(path, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := filepath.Glob(path + ".v2*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != len(old) {
+		t.Fatalf("got backups %v, want %v", backups, len(old))
+	}
+	first, err := os.ReadFile(path + ".v2.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != old[0] {
+		t.Fatalf("the first backup holds %q, want %q", first, old[0])
+	}
+}
+
+func TestMigrateV2ToV3(t *testing.T) {
+	literal := filepath.Join(t.TempDir(), "{proj}")
+	if err := os.Mkdir(literal, 0755); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		fmt.Sprintf(`{"path":%q}`, literal),
+		`{"path":"{project_root}/build"}`,
+		`{"path":"/src/api"}`,
+	}
+	migrated, err := migrateV2ToV3(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index, want := range []bool{false, true, false} {
+		mark, err := DecodeMark(migrated[index])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mark.Template != want {
+			t.Errorf("%v: got template %v, want %v", mark.Path, mark.Template, want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package markdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// LocalMarkDB stores the marks in a file, one mark per line. Files written
+// by older versions are migrated to the current format on first use. It is
+// safe for concurrent use by multiple goroutines: reads share a lock and
+// every mutation holds it exclusively for its whole read-modify-write.
+type LocalMarkDB struct {
+	DBFile   string
+	filePerm os.FileMode
+	// ctx bounds the wait for the lock, see WithContext.
+	ctx   context.Context
+	state *localState
+}
+
+// localState is the state of a LocalMarkDB, shared with its copies bound
+// to a context.
+type localState struct {
+	mu sync.RWMutex
+
+	migrateOnce sync.Once
+	migrateErr  error
+}
+
+// NewLocalMarkDB returns a db stored in dbFile, creating its directory if
+// needed.
+func NewLocalMarkDB(dbFile string) (*LocalMarkDB, error) {
+	if err := MkdirAllOwned(filepath.Dir(dbFile), 0755); err != nil {
+		return nil, err
+	}
+	return &LocalMarkDB{DBFile: dbFile, filePerm: 0660, ctx: context.Background(), state: &localState{}}, nil
+}
+
+func (l *LocalMarkDB) WithContext(ctx context.Context) MarkDB {
+	bound := *l
+	bound.ctx = ctx
+	return &bound
+}
+
+func (l *LocalMarkDB) Get(index int) (Mark, error) {
+	if err := l.migrate(); err != nil {
+		return Mark{}, err
+	}
+	if index < 0 {
+		return Mark{}, errors.New("invalid index")
+	}
+	unlock, err := l.lock(false)
+	if err != nil {
+		return Mark{}, err
+	}
+	defer unlock()
+	marks, err := l.read()
+	if err != nil {
+		return Mark{}, err
+	}
+	if index < 0 || index > len(marks)-1 {
+		return Mark{}, errors.New("invalid index")
+	}
+	return marks[index], nil
+}
+
+func (l *LocalMarkDB) GetByName(name string) (int, Mark, error) {
+	if err := l.migrate(); err != nil {
+		return 0, Mark{}, err
+	}
+	unlock, err := l.lock(false)
+	if err != nil {
+		return 0, Mark{}, err
+	}
+	defer unlock()
+	marks, err := l.read()
+	if err != nil {
+		return 0, Mark{}, err
+	}
+	return FindName(marks, name)
+}
+
+func (l *LocalMarkDB) Add(mark Mark) error {
+	return l.Insert(0, mark)
+}
+
+func (l *LocalMarkDB) Insert(index int, mark Mark) error {
+	if err := l.migrate(); err != nil {
+		return err
+	}
+	unlock, err := l.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	marks, err := l.read()
+	if err != nil {
+		return err
+	}
+	if index < 0 || index > len(marks) {
+		return errors.New("invalid index")
+	}
+	return l.write(slices.Insert(marks, index, mark))
+}
+
+func (l *LocalMarkDB) List() ([]Mark, error) {
+	if err := l.migrate(); err != nil {
+		return nil, err
+	}
+	unlock, err := l.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return l.read()
+}
+
+func (l *LocalMarkDB) read() ([]Mark, error) {
+	file, err := OpenOwned(l.DBFile, os.O_RDONLY|os.O_CREATE, l.filePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	version, lines, err := readDBLines(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", l.DBFile, err)
+	}
+	if version != dbFormatVersion {
+		return nil, fmt.Errorf("%v: unsupported db format v%v", l.DBFile, version)
+	}
+	var results []Mark
+	for _, line := range lines {
+		mark, err := DecodeMark(line)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", l.DBFile, err)
+		}
+		results = append(results, mark)
+	}
+	return results, nil
+}
+
+// migrate upgrades the db file to the current format the first time it is
+// called.
+func (l *LocalMarkDB) migrate() error {
+	l.state.migrateOnce.Do(func() {
+		unlock, err := l.lock(true)
+		if err != nil {
+			l.state.migrateErr = err
+			return
+		}
+		defer unlock()
+		l.state.migrateErr = migrateDBFile(l.DBFile, l.filePerm)
+	})
+	return l.state.migrateErr
+}
+
+// lock locks the db against the other goroutines of this process and,
+// with an flock on <db>.lock, against other mark processes, such as mark
+// add running in several terminals at once.
+func (l *LocalMarkDB) lock(exclusive bool) (unlock func(), err error) {
+	if exclusive {
+		l.state.mu.Lock()
+	} else {
+		l.state.mu.RLock()
+	}
+	unlockFile, err := LockFileContext(l.ctx, l.DBFile+".lock", exclusive)
+	unlockMu := l.state.mu.Unlock
+	if !exclusive {
+		unlockMu = l.state.mu.RUnlock
+	}
+	if err != nil {
+		unlockMu()
+		return nil, fmt.Errorf("locking %v: %v", l.DBFile, err)
+	}
+	return func() {
+		unlockFile()
+		unlockMu()
+	}, nil
+}
+
+func (l *LocalMarkDB) Delete(index int) error {
+	return l.DeleteMany([]int{index})
+}
+
+// DeleteMany removes all of the given indexes in a single rewrite so that
+// the indexes do not shift between deletions.
+func (l *LocalMarkDB) DeleteMany(indexes []int) error {
+	if err := l.migrate(); err != nil {
+		return err
+	}
+	unlock, err := l.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	marks, err := l.read()
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index < 0 || index >= len(marks) {
+			return errors.New("invalid index")
+		}
+	}
+	var remaining []Mark
+	for index, mark := range marks {
+		if slices.Contains(indexes, index) {
+			continue
+		}
+		remaining = append(remaining, mark)
+	}
+	return l.write(remaining)
+}
+
+// Update replaces the mark at index.
+func (l *LocalMarkDB) Update(index int, mark Mark) error {
+	if err := l.migrate(); err != nil {
+		return err
+	}
+	unlock, err := l.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	marks, err := l.read()
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= len(marks) {
+		return errors.New("invalid index")
+	}
+	marks[index] = mark
+	return l.write(marks)
+}
+
+// Move moves the mark at from to the index to, shifting the marks in
+// between.
+func (l *LocalMarkDB) Move(from int, to int) error {
+	if err := l.migrate(); err != nil {
+		return err
+	}
+	unlock, err := l.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	marks, err := l.read()
+	if err != nil {
+		return err
+	}
+	if from < 0 || from >= len(marks) || to < 0 || to >= len(marks) {
+		return errors.New("invalid index")
+	}
+	mark := marks[from]
+	marks = slices.Delete(marks, from, from+1)
+	marks = slices.Insert(marks, to, mark)
+	return l.write(marks)
+}
+
+// Locked runs fn holding the lock exclusively, as mark sync does while git
+// merges the file, once the file is migrated. write replaces the marks
+// from within fn.
+func (l *LocalMarkDB) Locked(fn func(write func(marks []Mark) error) error) error {
+	if err := l.migrate(); err != nil {
+		return err
+	}
+	unlock, err := l.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn(l.write)
+}
+
+func (l *LocalMarkDB) write(marks []Mark) error {
+	return WriteFileAtomic(l.DBFile, l.filePerm, func(w io.Writer) error {
+		return WriteDB(w, marks)
+	})
+}
+
+func (l *LocalMarkDB) Clear() error {
+	if err := l.migrate(); err != nil {
+		return err
+	}
+	unlock, err := l.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return l.write(nil)
+}
+
+func (l *LocalMarkDB) Replace(marks []Mark) error {
+	if err := l.migrate(); err != nil {
+		return err
+	}
+	unlock, err := l.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return l.write(marks)
+}
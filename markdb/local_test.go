@@ -0,0 +1,140 @@
+package markdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Run with go test -race, which also catches the data races the locking of
+// LocalMarkDB is there to prevent.
+
+func TestLocalMarkDBConcurrentWriters(t *testing.T) {
+	const (
+		initial  = 50
+		writers  = 4
+		adds     = 25
+		deleters = 2
+		deletes  = 10
+		readers  = 4
+	)
+	dbFile := filepath.Join(t.TempDir(), "marks")
+	// Two dbs on the same file, as two processes would have, as well as
+	// goroutines sharing each of them.
+	dbs := make([]*LocalMarkDB, 2)
+	for i := range dbs {
+		db, err := NewLocalMarkDB(dbFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbs[i] = db
+	}
+	for i := range initial {
+		if err := dbs[0].Add(Mark{Path: fmt.Sprintf("/initial/%v", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*adds+deleters*deletes+readers)
+	for writer := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db := dbs[writer%len(dbs)]
+			for i := range adds {
+				if err := db.Add(Mark{Path: fmt.Sprintf("/writer%v/%v", writer, i)}); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for deleter := range deleters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db := dbs[deleter%len(dbs)]
+			for range deletes {
+				if err := db.DeleteMany([]int{0}); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for reader := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db := dbs[reader%len(dbs)]
+			for range adds {
+				marks, err := db.List()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(marks) < initial-deleters*deletes {
+					errs <- fmt.Errorf("listed %v marks, fewer than there can be", len(marks))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	marks, err := dbs[1].List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := initial + writers*adds - deleters*deletes; len(marks) != want {
+		t.Fatalf("got %v marks, want %v", len(marks), want)
+	}
+	seen := map[string]bool{}
+	for _, mark := range marks {
+		if seen[mark.Path] {
+			t.Errorf("%v is in the db twice", mark.Path)
+		}
+		seen[mark.Path] = true
+	}
+}
+
+func TestLocalMarkDBConcurrentAdds(t *testing.T) {
+	const writers, adds = 8, 20
+	db, err := NewLocalMarkDB(filepath.Join(t.TempDir(), "marks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for writer := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range adds {
+				if err := db.Add(Mark{Path: fmt.Sprintf("/writer%v/%v", writer, i)}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	marks, err := db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]bool{}
+	for _, mark := range marks {
+		paths[mark.Path] = true
+	}
+	for writer := range writers {
+		for i := range adds {
+			if path := fmt.Sprintf("/writer%v/%v", writer, i); !paths[path] {
+				t.Errorf("%v was lost", path)
+			}
+		}
+	}
+}
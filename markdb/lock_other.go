@@ -0,0 +1,52 @@
+//go:build !unix
+
+package markdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockTimeout is how long LockFile waits for a lock file before assuming
+// it was left behind by a process that died, and removing it.
+const lockTimeout = 10 * time.Second
+
+// LockFile takes a lock by creating path exclusively. Readers and writers
+// are not told apart.
+func LockFile(path string, exclusive bool) (unlock func(), err error) {
+	return LockFileContext(context.Background(), path, exclusive)
+}
+
+// LockFileContext is LockFile giving up waiting for the lock once ctx is
+// done.
+func LockFileContext(ctx context.Context, path string, exclusive bool) (unlock func(), err error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		file, err := OpenOwned(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+		if err == nil {
+			fmt.Fprint(file, os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			os.Remove(path)
+			deadline = time.Now().Add(lockTimeout)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(10 * time.Millisecond):
+		}
+		// Both may be ready at once; a done ctx wins, so that a cancelled
+		// wait never goes on to take the lock.
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+	}
+}
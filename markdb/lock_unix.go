@@ -0,0 +1,61 @@
+//go:build unix
+
+package markdb
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// LockFile takes an flock on path, creating it if needed, shared for
+// readers and exclusive for writers. The lock is held until unlock is
+// called, or the process exits.
+func LockFile(path string, exclusive bool) (unlock func(), err error) {
+	return LockFileContext(context.Background(), path, exclusive)
+}
+
+// LockFileContext is LockFile giving up waiting for the lock once ctx is
+// done.
+func LockFileContext(ctx context.Context, path string, exclusive bool) (unlock func(), err error) {
+	file, err := OpenOwned(path, os.O_RDONLY|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if ctx.Done() != nil {
+		// A blocking flock cannot be interrupted, so the lock is tried
+		// until it is free or ctx is done.
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err = syscall.Flock(int(file.Fd()), how)
+		if err == syscall.EWOULDBLOCK {
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Millisecond):
+			}
+			// Both may be ready at once; a done ctx wins, so that a
+			// cancelled wait never goes on to take the lock.
+			if ctx.Err() == nil {
+				continue
+			}
+			err = context.Cause(ctx)
+		}
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,145 @@
+package markdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Mark is a single entry in the mark db.
+type Mark struct {
+	Path   string   `json:"path"`
+	Name   string   `json:"name,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Note   string   `json:"note,omitempty"`
+	Pinned bool     `json:"pinned,omitempty"`
+	Color  string   `json:"color,omitempty"`
+	// Template is set when Path has placeholders, such as {project_root},
+	// replaced by their values when the mark is used. Paths of other marks
+	// are used as they are, braces included.
+	Template bool `json:"template,omitempty"`
+
+	Created  time.Time `json:"created,omitzero"`
+	LastUsed time.Time `json:"last_used,omitzero"`
+	// Archived marks are hidden from the list.
+	Archived bool `json:"archived,omitempty"`
+	// Deleted is when the mark was deleted. Deleted marks are kept, hidden
+	// and at the end of the list, until gc purges them.
+	Deleted time.Time `json:"deleted,omitzero"`
+	// Expires is when a temporary mark, added with a time to live, moves
+	// to the trash.
+	Expires time.Time `json:"expires,omitzero"`
+
+	// MountPoint and MountSource record the volume the mark was made on,
+	// when it is not the root filesystem, to tell an unmounted volume
+	// apart from a deleted directory.
+	MountPoint  string `json:"mount_point,omitempty"`
+	MountSource string `json:"mount_source,omitempty"`
+
+	// Device and Inode identify the marked directory, where the platform
+	// has them, so that relink can tell it apart from a namesake after it
+	// moves.
+	Device uint64 `json:"device,omitempty"`
+	Inode  uint64 `json:"inode,omitempty"`
+}
+
+// LastActive returns when the mark was last used, or else created. It is
+// zero for marks stored before timestamps were recorded.
+func (m Mark) LastActive() time.Time {
+	if !m.LastUsed.IsZero() {
+		return m.LastUsed
+	}
+	return m.Created
+}
+
+// Merge returns the mark with the metadata set on other applied to it.
+func (m Mark) Merge(other Mark) Mark {
+	if other.Name != "" {
+		m.Name = other.Name
+	}
+	for _, tag := range other.Tags {
+		if !slices.Contains(m.Tags, tag) {
+			m.Tags = append(slices.Clip(m.Tags), tag)
+		}
+	}
+	if other.Note != "" {
+		m.Note = other.Note
+	}
+	m.Pinned = m.Pinned || other.Pinned
+	if !other.Expires.IsZero() {
+		m.Expires = other.Expires
+	}
+	if other.Color != "" {
+		m.Color = other.Color
+	}
+	return m
+}
+
+// ID returns the id of the mark, for referring to it as @<id>: the start
+// of the SHA-256 of its path. Unlike its index it stays the same as marks
+// are added, deleted and moved.
+func (m Mark) ID() string {
+	sum := sha256.Sum256([]byte(m.Path))
+	return hex.EncodeToString(sum[:4])
+}
+
+// IsDeleted reports whether the mark was deleted and awaits its purge.
+func (m Mark) IsDeleted() bool {
+	return !m.Deleted.IsZero()
+}
+
+// IsExpired reports whether the time to live of the mark is up.
+func (m Mark) IsExpired() bool {
+	return !m.Expires.IsZero() && !time.Now().Before(m.Expires)
+}
+
+// HasTags reports whether the mark has all of tags.
+func (m Mark) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(m.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether match matches the path, name, note or one of
+// the tags of the mark.
+func (m Mark) Matches(match func(s string) bool) bool {
+	return match(m.Path) || match(m.Name) || match(m.Note) || slices.ContainsFunc(m.Tags, match)
+}
+
+// ErrNameNotFound is returned when no mark has the name looked up.
+var ErrNameNotFound = errors.New("no mark has that name")
+
+// FindName returns the mark called name and its index.
+func FindName(marks []Mark, name string) (int, Mark, error) {
+	for index, mark := range marks {
+		if mark.Name != "" && mark.Name == name && !mark.IsDeleted() {
+			return index, mark, nil
+		}
+	}
+	return 0, Mark{}, fmt.Errorf("%w: %v", ErrNameNotFound, name)
+}
+
+// EncodeMark returns the line stored in the local db for a mark.
+func EncodeMark(mark Mark) (string, error) {
+	line, err := json.Marshal(mark)
+	return string(line), err
+}
+
+// DecodeMark parses a line of the local db. Lines that are not JSON are
+// plain paths, as written by db format v1.
+func DecodeMark(line string) (Mark, error) {
+	if !strings.HasPrefix(line, "{") {
+		return Mark{Path: line}, nil
+	}
+	var mark Mark
+	err := json.Unmarshal([]byte(line), &mark)
+	return mark, err
+}
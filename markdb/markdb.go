@@ -0,0 +1,44 @@
+// Package markdb stores the marks of mark: the Mark type, the MarkDB
+// interface its storage backends implement, and the local file backend
+// with the wrappers that do not depend on the configuration of mark.
+package markdb
+
+import "context"
+
+// MarkDB stores an ordered list of marks, newest first.
+type MarkDB interface {
+	Get(index int) (Mark, error)
+	// GetByName returns the mark called name and its index, or an error
+	// wrapping ErrNameNotFound.
+	GetByName(name string) (int, Mark, error)
+	Add(mark Mark) error
+	// Insert adds mark at index, shifting the marks from index on down.
+	// Add is Insert at 0.
+	Insert(index int, mark Mark) error
+	List() ([]Mark, error)
+	Clear() error
+	Delete(index int) error
+	DeleteMany(indexes []int) error
+	Update(index int, mark Mark) error
+	Move(from int, to int) error
+	// Replace replaces all the marks with marks in a single write.
+	Replace(marks []Mark) error
+}
+
+// ContextMarkDB is a MarkDB whose operations can be bound to a context, so
+// that they stop waiting on the network or on a lock once it is done.
+type ContextMarkDB interface {
+	MarkDB
+	// WithContext returns the db with its operations bound to ctx. It
+	// shares the state of the db, such as its locks and connection.
+	WithContext(ctx context.Context) MarkDB
+}
+
+// BindContext returns db bound to ctx when it is a ContextMarkDB, and db
+// itself otherwise.
+func BindContext(ctx context.Context, db MarkDB) MarkDB {
+	if db, ok := db.(ContextMarkDB); ok {
+		return db.WithContext(ctx)
+	}
+	return db
+}
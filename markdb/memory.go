@@ -0,0 +1,111 @@
+package markdb
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+// MemoryMarkDB keeps the marks in memory only, for the life of the
+// process. It never touches the disk.
+type MemoryMarkDB struct {
+	mu    sync.RWMutex
+	marks []Mark
+}
+
+func NewMemoryMarkDB() *MemoryMarkDB {
+	return &MemoryMarkDB{}
+}
+
+func (d *MemoryMarkDB) Get(index int) (Mark, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if index < 0 || index >= len(d.marks) {
+		return Mark{}, errors.New("invalid index")
+	}
+	return d.marks[index], nil
+}
+
+func (d *MemoryMarkDB) GetByName(name string) (int, Mark, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return FindName(d.marks, name)
+}
+
+func (d *MemoryMarkDB) Add(mark Mark) error {
+	return d.Insert(0, mark)
+}
+
+func (d *MemoryMarkDB) Insert(index int, mark Mark) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if index < 0 || index > len(d.marks) {
+		return errors.New("invalid index")
+	}
+	d.marks = slices.Insert(d.marks, index, mark)
+	return nil
+}
+
+func (d *MemoryMarkDB) List() ([]Mark, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.Clone(d.marks), nil
+}
+
+func (d *MemoryMarkDB) Clear() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.marks = nil
+	return nil
+}
+
+func (d *MemoryMarkDB) Replace(marks []Mark) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.marks = slices.Clone(marks)
+	return nil
+}
+
+func (d *MemoryMarkDB) Delete(index int) error {
+	return d.DeleteMany([]int{index})
+}
+
+func (d *MemoryMarkDB) DeleteMany(indexes []int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, index := range indexes {
+		if index < 0 || index >= len(d.marks) {
+			return errors.New("invalid index")
+		}
+	}
+	var remaining []Mark
+	for index, mark := range d.marks {
+		if !slices.Contains(indexes, index) {
+			remaining = append(remaining, mark)
+		}
+	}
+	d.marks = remaining
+	return nil
+}
+
+func (d *MemoryMarkDB) Update(index int, mark Mark) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if index < 0 || index >= len(d.marks) {
+		return errors.New("invalid index")
+	}
+	d.marks[index] = mark
+	return nil
+}
+
+func (d *MemoryMarkDB) Move(from int, to int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if from < 0 || from >= len(d.marks) || to < 0 || to >= len(d.marks) {
+		return errors.New("invalid index")
+	}
+	mark := d.marks[from]
+	d.marks = slices.Delete(d.marks, from, from+1)
+	d.marks = slices.Insert(d.marks, to, mark)
+	return nil
+}
@@ -0,0 +1,105 @@
+package markdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// Owner is a user that the files of the db are kept owned by.
+type Owner struct {
+	Name    string
+	HomeDir string
+	UID     int
+	GID     int
+}
+
+// dbOwner owns the files mark creates when it runs as root on behalf of
+// another user with --user. When it is nil new files are owned by the
+// process, as usual.
+var dbOwner *Owner
+
+func LookupOwner(name string) (*Owner, error) {
+	account, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.Atoi(account.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("user %v has no numeric uid", name)
+	}
+	gid, err := strconv.Atoi(account.Gid)
+	if err != nil {
+		return nil, fmt.Errorf("user %v has no numeric gid", name)
+	}
+	return &Owner{Name: account.Username, HomeDir: account.HomeDir, UID: uid, GID: gid}, nil
+}
+
+// SudoUser returns the user that ran mark through sudo, or "" when mark
+// is not running as root on someone's behalf.
+func SudoUser() string {
+	if os.Geteuid() != 0 {
+		return ""
+	}
+	return os.Getenv("SUDO_USER")
+}
+
+// RunAs makes mark use the marks and config of another user: the db and
+// config are looked up in their home directory, and with owned set the
+// files mark creates are given to them.
+func RunAs(owner *Owner, owned bool) {
+	os.Setenv("HOME", owner.HomeDir)
+	for _, variable := range []string{"MARK_HOME", "XDG_CONFIG_HOME", "XDG_DATA_HOME"} {
+		os.Unsetenv(variable)
+	}
+	if owned {
+		dbOwner = owner
+	}
+}
+
+// OpenOwned is os.OpenFile, except that a file it creates is given to the
+// db owner.
+func OpenOwned(path string, flag int, perm os.FileMode) (*os.File, error) {
+	_, statErr := os.Lstat(path)
+	file, err := os.OpenFile(path, flag, perm)
+	if err != nil || !errors.Is(statErr, os.ErrNotExist) {
+		return file, err
+	}
+	if err := chownToOwner(path); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// MkdirAllOwned is os.MkdirAll, except that the directories it creates are
+// given to the db owner.
+func MkdirAllOwned(path string, perm os.FileMode) error {
+	var created []string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		created = append(created, dir)
+	}
+	if err := os.MkdirAll(path, perm); err != nil {
+		return err
+	}
+	for _, dir := range created {
+		if err := chownToOwner(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chownToOwner gives path to the db owner, if there is one.
+func chownToOwner(path string) error {
+	if dbOwner == nil {
+		return nil
+	}
+	return os.Chown(path, dbOwner.UID, dbOwner.GID)
+}
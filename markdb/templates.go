@@ -0,0 +1,91 @@
+package markdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// templateVariable matches a placeholder such as {project_root} in a
+// marked path.
+var templateVariable = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// IsTemplate reports whether path contains placeholders.
+func IsTemplate(path string) bool {
+	return templateVariable.MatchString(path)
+}
+
+// LooksLikeTemplate reports whether path, not known to be templated or
+// not, is: whether it has placeholders and does not exist as written, as a
+// directory named with braces such as /src/{proj} may.
+func LooksLikeTemplate(path string) bool {
+	if !IsTemplate(path) {
+		return false
+	}
+	_, err := os.Stat(ExpandHome(path))
+	return err != nil
+}
+
+// TemplateNames returns the names of the placeholders in path.
+func TemplateNames(path string) []string {
+	var names []string
+	for _, match := range templateVariable.FindAllStringSubmatch(path, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// BuiltinVars returns the variables that are always defined.
+func BuiltinVars() map[string]string {
+	hostname, _ := os.Hostname()
+	return map[string]string{
+		"arch":     runtime.GOARCH,
+		"os":       runtime.GOOS,
+		"hostname": hostname,
+	}
+}
+
+// LookupVar returns the value of a placeholder, looking in the vars
+// setting, then the builtin variables and then the environment, along with
+// where it was found.
+func LookupVar(name string, vars map[string]string) (value string, source string, ok bool) {
+	if value, ok := vars[name]; ok {
+		return value, "config", true
+	}
+	if value, ok := BuiltinVars()[name]; ok {
+		return value, "builtin", true
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, "env", true
+	}
+	return "", "", false
+}
+
+// ExpandTemplate replaces the placeholders in path with their values.
+func ExpandTemplate(path string, vars map[string]string) (string, error) {
+	var err error
+	expanded := templateVariable.ReplaceAllStringFunc(path, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, _, ok := LookupVar(name, vars)
+		if !ok && err == nil {
+			err = fmt.Errorf("%v: variable {%v} is not set, define it under [vars] or in the environment", path, name)
+		}
+		return value
+	})
+	return ExpandHome(expanded), err
+}
+
+// ExpandHome replaces a leading "~" with the user's home directory.
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(homeDir, path[1:])
+}
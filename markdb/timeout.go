@@ -0,0 +1,116 @@
+package markdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimeoutMarkDB wraps a MarkDB so that each of its operations gives up once
+// timeout has passed, so a hung backend, such as a dead network mount,
+// fails with an error instead of hanging the shell. Every operation gets
+// the whole timeout, however long the command waited on the user before
+// it. The operation is bound to a context that ends with the timeout, so
+// that requests and lock waits are cancelled rather than left holding
+// what they hold; only what cannot be cancelled, such as a read on a dead
+// mount, is left running until the process exits.
+type TimeoutMarkDB struct {
+	db      MarkDB
+	timeout time.Duration
+}
+
+func NewTimeoutMarkDB(timeout time.Duration, db MarkDB) *TimeoutMarkDB {
+	return &TimeoutMarkDB{db: db, timeout: timeout}
+}
+
+// Timeout returns how long each operation may take.
+func (t *TimeoutMarkDB) Timeout() time.Duration {
+	return t.timeout
+}
+
+// Unwrap returns the db the operations are made on.
+func (t *TimeoutMarkDB) Unwrap() MarkDB {
+	return t.db
+}
+
+func (t *TimeoutMarkDB) Get(index int) (Mark, error) {
+	return withTimeout(t, "get", func(db MarkDB) (Mark, error) { return db.Get(index) })
+}
+
+func (t *TimeoutMarkDB) GetByName(name string) (int, Mark, error) {
+	type found struct {
+		index int
+		mark  Mark
+	}
+	result, err := withTimeout(t, "get", func(db MarkDB) (found, error) {
+		index, mark, err := db.GetByName(name)
+		return found{index, mark}, err
+	})
+	return result.index, result.mark, err
+}
+
+func (t *TimeoutMarkDB) Add(mark Mark) error {
+	return t.do("add", func(db MarkDB) error { return db.Add(mark) })
+}
+
+func (t *TimeoutMarkDB) Insert(index int, mark Mark) error {
+	return t.do("insert", func(db MarkDB) error { return db.Insert(index, mark) })
+}
+
+func (t *TimeoutMarkDB) List() ([]Mark, error) {
+	return withTimeout(t, "list", MarkDB.List)
+}
+
+func (t *TimeoutMarkDB) Clear() error {
+	return t.do("clear", MarkDB.Clear)
+}
+
+func (t *TimeoutMarkDB) Delete(index int) error {
+	return t.do("delete", func(db MarkDB) error { return db.Delete(index) })
+}
+
+func (t *TimeoutMarkDB) DeleteMany(indexes []int) error {
+	return t.do("delete", func(db MarkDB) error { return db.DeleteMany(indexes) })
+}
+
+func (t *TimeoutMarkDB) Update(index int, mark Mark) error {
+	return t.do("update", func(db MarkDB) error { return db.Update(index, mark) })
+}
+
+func (t *TimeoutMarkDB) Move(from int, to int) error {
+	return t.do("move", func(db MarkDB) error { return db.Move(from, to) })
+}
+
+func (t *TimeoutMarkDB) Replace(marks []Mark) error {
+	return t.do("replace", func(db MarkDB) error { return db.Replace(marks) })
+}
+
+func (t *TimeoutMarkDB) do(op string, run func(db MarkDB) error) error {
+	_, err := withTimeout(t, op, func(db MarkDB) (struct{}, error) { return struct{}{}, run(db) })
+	return err
+}
+
+func withTimeout[T any](t *TimeoutMarkDB, op string, run func(db MarkDB) (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	done := make(chan result, 1)
+	go func() {
+		value, err := run(BindContext(ctx, t.db))
+		done <- result{value, err}
+	}()
+	select {
+	case result := <-done:
+		// An operation failing because it was cancelled reports the
+		// timeout, like one still running.
+		if result.err == nil || ctx.Err() == nil {
+			return result.value, result.err
+		}
+	case <-ctx.Done():
+	}
+	var zero T
+	return zero, fmt.Errorf("%v: storage did not respond in time (see --timeout): %w", op, context.Cause(ctx))
+}
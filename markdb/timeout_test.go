@@ -0,0 +1,37 @@
+package markdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimeoutCancelsLockWait(t *testing.T) {
+	db, err := NewLocalMarkDB(filepath.Join(t.TempDir(), "marks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Add(Mark{Path: "/a"}); err != nil {
+		t.Fatal(err)
+	}
+	// Another process holding the lock.
+	unlock, err := LockFile(db.DBFile+".lock", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := NewTimeoutMarkDB(50*time.Millisecond, db)
+	if err := timeout.Add(Mark{Path: "/b"}); err == nil {
+		t.Fatal("add did not time out")
+	}
+	unlock()
+
+	// The add gave up waiting, rather than adding once the lock is free.
+	time.Sleep(100 * time.Millisecond)
+	marks, err := timeout.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marks) != 1 {
+		t.Fatalf("got %v marks, want the add to have been cancelled", len(marks))
+	}
+}
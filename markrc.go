@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const markRCFile = ".markrc"
+
+// ReadMarkRC reads the metadata declared by the .markrc file in dir, using
+// the same syntax as the config file:
+//
+//	name = "api"
+//	tags = ["work", "backend"]
+//	note = "main service"
+//	color = "blue"
+//	pin = true
+//
+// ok is false when dir has no .markrc file.
+func ReadMarkRC(dir string) (mark Mark, ok bool, err error) {
+	file, err := os.Open(filepath.Join(dir, markRCFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return Mark{}, false, nil
+	} else if err != nil {
+		return Mark{}, false, err
+	}
+	defer file.Close()
+	values, err := parseConfig(file.Name(), bufio.NewScanner(file))
+	if err != nil {
+		return Mark{}, false, err
+	}
+	for key, value := range values {
+		switch key {
+		case "name":
+			mark.Name, err = configString(key, value)
+			if err == nil {
+				err = ValidateName(mark.Name)
+			}
+		case "tags":
+			mark.Tags, err = configStrings(key, value)
+		case "note":
+			mark.Note, err = configString(key, value)
+		case "color":
+			mark.Color, err = configString(key, value)
+			if err == nil {
+				err = ValidateColor(mark.Color)
+			}
+		case "pin":
+			mark.Pinned, err = configBool(key, value)
+		default:
+			err = fmt.Errorf("unknown setting %q", key)
+		}
+		if err != nil {
+			return Mark{}, false, fmt.Errorf("%v: %v", file.Name(), err)
+		}
+	}
+	return mark, true, nil
+}
+
+// FindMarkRCDirs returns the directories beneath root, including root,
+// that contain a .markrc file. Hidden directories and directories matching
+// the exclude globs are not searched.
+func FindMarkRCDirs(root string, exclude []string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return fs.SkipDir
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != root && (strings.HasPrefix(entry.Name(), ".") || MatchesAny(path, exclude)) {
+			return fs.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(path, markRCFile)); err == nil {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	return dirs, err
+}
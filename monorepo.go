@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// monorepoDetectors recognise the directories of a monorepo that hold a
+// service or package, by the files they contain. The monorepo_detectors
+// setting chooses among them, and any other entry of the setting is taken
+// as the name of a file marking a package, e.g. "Cargo.toml".
+var monorepoDetectors = map[string][]string{
+	"go":    {"go.mod"},
+	"node":  {"package.json"},
+	"bazel": {"BUILD", "BUILD.bazel"},
+}
+
+// defaultMonorepoDetectors are used when the setting is not configured.
+var defaultMonorepoDetectors = []string{"go", "node", "bazel"}
+
+// vendoredDirs hold third party code whose packages are never marked.
+var vendoredDirs = []string{"node_modules", "vendor", "third_party"}
+
+// FindPackages returns the package directories beneath root, not including
+// root itself, detected by the given detectors. Packages nested within a
+// package are not searched for. Hidden and vendored directories and
+// directories matching the exclude globs are skipped.
+func FindPackages(root string, detectors []string, exclude []string) ([]string, error) {
+	var markers []string
+	for _, detector := range detectors {
+		if files, ok := monorepoDetectors[detector]; ok {
+			markers = append(markers, files...)
+		} else {
+			markers = append(markers, detector)
+		}
+	}
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return fs.SkipDir
+		}
+		if !entry.IsDir() || path == root {
+			return nil
+		}
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || slices.Contains(vendoredDirs, name) || MatchesAny(path, exclude) {
+			return fs.SkipDir
+		}
+		for _, marker := range markers {
+			if _, err := os.Stat(filepath.Join(path, marker)); err == nil {
+				dirs = append(dirs, path)
+				return fs.SkipDir
+			}
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// PackageNames names the packages found beneath root within namespace,
+// e.g. "repo/svc-a", after their directory. Packages whose directory names
+// clash are named after their path relative to root instead, e.g.
+// "repo/services/api" and "repo/tools/api".
+func PackageNames(root string, namespace string, dirs []string) map[string]string {
+	counts := map[string]int{}
+	for _, dir := range dirs {
+		counts[filepath.Base(dir)]++
+	}
+	names := map[string]string{}
+	for _, dir := range dirs {
+		name := filepath.Base(dir)
+		if counts[name] > 1 {
+			relative, _ := filepath.Rel(root, dir)
+			name = filepath.ToSlash(relative)
+		}
+		names[dir] = namespace + "/" + name
+	}
+	return names
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// MountInfo is a mounted filesystem.
+type MountInfo struct {
+	Point  string
+	Source string
+}
+
+// Mounts returns the currently mounted filesystems, read from /proc/mounts
+// on Linux and from the output of mount(8) elsewhere.
+func Mounts() ([]MountInfo, error) {
+	if runtime.GOOS == "linux" {
+		file, err := os.Open("/proc/self/mounts")
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return parseProcMounts(file)
+	}
+	output, err := exec.Command("mount").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseMountOutput(bytes.NewReader(output))
+}
+
+func parseProcMounts(reader io.Reader) ([]MountInfo, error) {
+	var mounts []MountInfo
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		mounts = append(mounts, MountInfo{Point: unescapeMountField(fields[1]), Source: unescapeMountField(fields[0])})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountField decodes the octal escapes, such as \040 for a space,
+// used in /proc/mounts.
+func unescapeMountField(field string) string {
+	var builder strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			var value byte
+			if _, err := fmt.Sscanf(field[i+1:i+4], "%03o", &value); err == nil {
+				builder.WriteByte(value)
+				i += 3
+				continue
+			}
+		}
+		builder.WriteByte(field[i])
+	}
+	return builder.String()
+}
+
+// parseMountOutput parses lines of the form "source on point (options)".
+func parseMountOutput(reader io.Reader) ([]MountInfo, error) {
+	var mounts []MountInfo
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		source, rest, ok := strings.Cut(scanner.Text(), " on ")
+		if !ok {
+			continue
+		}
+		point, _, _ := strings.Cut(rest, " (")
+		mounts = append(mounts, MountInfo{Point: point, Source: source})
+	}
+	return mounts, scanner.Err()
+}
+
+// MountOf returns the filesystem that path is on: the mount with the
+// deepest mount point containing path.
+func MountOf(path string, mounts []MountInfo) (MountInfo, bool) {
+	var best MountInfo
+	found := false
+	for _, mount := range mounts {
+		if isWithin(path, mount.Point) && (!found || len(mount.Point) > len(best.Point)) {
+			best, found = mount, true
+		}
+	}
+	return best, found
+}
+
+// IsMounted reports whether a filesystem is mounted at point.
+func IsMounted(point string, mounts []MountInfo) bool {
+	for _, mount := range mounts {
+		if mount.Point == point {
+			return true
+		}
+	}
+	return false
+}
+
+// Why a mark's directory cannot be used.
+var (
+	errTargetMissing      = errors.New("missing")
+	errTargetUnmounted    = errors.New("unmounted")
+	errTargetUnresponsive = errors.New("not responding")
+)
+
+// CheckTarget reports whether the directory of mark is usable, stating it
+// with stat, returning errTargetUnmounted when it is missing because the
+// volume it was marked on is not mounted, errTargetUnresponsive when stat
+// timed out, and errTargetMissing when it was deleted.
+func CheckTarget(mark Mark, mounts []MountInfo, stat func(path string) (os.FileInfo, error)) error {
+	_, err := stat(mark.Path)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errTargetUnresponsive
+	}
+	if mark.MountPoint != "" && !IsMounted(mark.MountPoint, mounts) {
+		return errTargetUnmounted
+	}
+	return errTargetMissing
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// Notifier reports failures to resolve a mark outside of the terminal, so
+// they are not lost inside the shell wrapper functions.
+type Notifier struct {
+	// Desktop sends a desktop notification with notify-send or osascript.
+	Desktop bool
+	// Command is run by the shell with MARK_IDENTIFIER, MARK_PATH and
+	// MARK_ERROR set in its environment.
+	Command string
+}
+
+// Notify reports err for the mark identifier and, when known, its path.
+// Failures to notify are ignored.
+func (n Notifier) Notify(identifier string, path string, err error) {
+	if n.Desktop {
+		notifyDesktop("mark: " + err.Error())
+	}
+	if n.Command != "" {
+		command := exec.Command("sh", "-c", n.Command)
+		command.Env = append(os.Environ(),
+			"MARK_IDENTIFIER="+identifier,
+			"MARK_PATH="+path,
+			"MARK_ERROR="+err.Error(),
+		)
+		command.Stdout = os.Stderr
+		command.Stderr = os.Stderr
+		command.Run()
+	}
+}
+
+func notifyDesktop(message string) {
+	var command *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := "display notification " + strconv.Quote(message) + ` with title "mark"`
+		command = exec.Command("osascript", "-e", script)
+	default:
+		command = exec.Command("notify-send", "mark", message)
+	}
+	command.Run()
+}
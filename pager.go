@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Pager pipes everything written to os.Stdout through a pager program
+// until it is closed.
+type Pager struct {
+	command *exec.Cmd
+	writer  *os.File
+	stdout  *os.File
+}
+
+// StartPager runs command, through the shell, and points os.Stdout at it.
+// Like git, less is told to quit when the output fits on one screen and
+// to pass colors through, unless $LESS says otherwise.
+func StartPager(command string) (*Pager, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	pager := exec.Command("sh", "-c", command)
+	pager.Stdin = reader
+	pager.Stdout = os.Stdout
+	pager.Stderr = os.Stderr
+	pager.Env = os.Environ()
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		pager.Env = append(pager.Env, "LESS=FRX")
+	}
+	if err := pager.Start(); err != nil {
+		reader.Close()
+		writer.Close()
+		return nil, err
+	}
+	reader.Close()
+	p := &Pager{command: pager, writer: writer, stdout: os.Stdout}
+	os.Stdout = writer
+	return p, nil
+}
+
+// Close restores os.Stdout and waits for the user to quit the pager.
+func (p *Pager) Close() error {
+	os.Stdout = p.stdout
+	p.writer.Close()
+	return p.command.Wait()
+}
+
+// PagerCommand returns the pager to use: the pager setting, then $PAGER,
+// then less. It is empty when paging is disabled.
+func PagerCommand(config *Config) string {
+	if config.NoPager {
+		return ""
+	}
+	command := config.Pager
+	if command == "" {
+		command = os.Getenv("PAGER")
+	}
+	if command == "" {
+		command = "less"
+	}
+	if command == "cat" {
+		return ""
+	}
+	return command
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// defaultProfile names the marks kept in the db itself, rather than in a
+// profile of their own.
+const defaultProfile = "default"
+
+// currentProfileFile holds the name of the profile chosen with "mark use".
+const currentProfileFile = ".current"
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+// ValidateProfileName checks that name can name a profile. Underscores and
+// dots are not allowed so that the files kept alongside the db of a
+// profile, such as work_events and work.lock, are never taken for profiles.
+func ValidateProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q, use letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// ProfileStore keeps profiles, separate sets of marks each in a db of its
+// own, in a directory next to the db of the default profile.
+type ProfileStore struct {
+	Dir string
+}
+
+func NewProfileStore(dir string) *ProfileStore {
+	return &ProfileStore{Dir: dir}
+}
+
+// DBFile returns the location of the db of a profile.
+func (p *ProfileStore) DBFile(name string) string {
+	return filepath.Join(p.Dir, name)
+}
+
+// List returns the names of the profiles, not including the default one.
+func (p *ProfileStore) List() ([]string, error) {
+	entries, err := os.ReadDir(p.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && ValidateProfileName(entry.Name()) == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Exists reports whether the profile called name exists. The default
+// profile always does.
+func (p *ProfileStore) Exists(name string) bool {
+	if name == defaultProfile {
+		return true
+	}
+	_, err := os.Stat(p.DBFile(name))
+	return err == nil
+}
+
+// Create creates an empty profile.
+func (p *ProfileStore) Create(name string) error {
+	if err := ValidateProfileName(name); err != nil {
+		return err
+	}
+	if p.Exists(name) {
+		return fmt.Errorf("profile %v already exists", name)
+	}
+	db, err := markdb.NewLocalMarkDB(p.DBFile(name))
+	if err != nil {
+		return err
+	}
+	return db.Clear()
+}
+
+// Delete deletes a profile along with its marks and sidecar files.
+func (p *ProfileStore) Delete(name string) error {
+	if name == defaultProfile {
+		return errors.New("the default profile cannot be deleted")
+	}
+	if !p.Exists(name) {
+		return fmt.Errorf("no profile named %q", name)
+	}
+	sidecars, err := filepath.Glob(filepath.Join(p.Dir, name+"_*"))
+	if err != nil {
+		return err
+	}
+	for _, file := range append(sidecars, p.DBFile(name)+".lock", p.DBFile(name)) {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Current returns the profile chosen with Use, the default one unless
+// another was chosen.
+func (p *ProfileStore) Current() (string, error) {
+	contents, err := os.ReadFile(filepath.Join(p.Dir, currentProfileFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return defaultProfile, nil
+	} else if err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(string(contents))
+	if name == "" {
+		return defaultProfile, nil
+	}
+	return name, nil
+}
+
+// Use makes name the current profile.
+func (p *ProfileStore) Use(name string) error {
+	if !p.Exists(name) {
+		return fmt.Errorf("no profile named %q, create it with: mark profile create %v", name, name)
+	}
+	file := filepath.Join(p.Dir, currentProfileFile)
+	if name == defaultProfile {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return markdb.WriteFileAtomic(file, 0644, func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, name)
+		return err
+	})
+}
+
+// SelectProfile points config at the db of a profile: the one given, else
+// $MARK_PROFILE, else the one chosen with "mark use".
+func SelectProfile(config *Config, name string) error {
+	if config.Ephemeral {
+		return nil
+	}
+	dir, err := SidecarFile(config, "_profiles")
+	if err != nil {
+		return err
+	}
+	config.ProfilesDir = dir
+	profiles := NewProfileStore(dir)
+	if name == "" {
+		name = os.Getenv("MARK_PROFILE")
+	}
+	if name == "" {
+		if name, err = profiles.Current(); err != nil {
+			return err
+		}
+		if !profiles.Exists(name) {
+			fmt.Fprintf(os.Stderr, "warning: the %v profile in use no longer exists, using the default profile\n", name)
+			name = defaultProfile
+		}
+	}
+	if !profiles.Exists(name) {
+		return fmt.Errorf("no profile named %q, create it with: mark profile create %v", name, name)
+	}
+	config.Profile = name
+	if name != defaultProfile {
+		config.DBFile = profiles.DBFile(name)
+	}
+	return nil
+}
+
+// SortedProfileNames returns the default profile followed by the others
+// in order.
+func SortedProfileNames(names []string) []string {
+	return append([]string{defaultProfile}, slices.Sorted(slices.Values(names))...)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Prompter asks the user questions on an input and output stream.
+type Prompter struct {
+	reader *bufio.Reader
+	writer io.Writer
+	// NonInteractive answers every question without asking: Ask with its
+	// fallback and Confirm with no, so nothing is changed unasked.
+	NonInteractive bool
+}
+
+func NewPrompter(reader io.Reader, writer io.Writer) *Prompter {
+	return &Prompter{reader: bufio.NewReader(reader), writer: writer}
+}
+
+// Ask asks a question and returns the answer, or fallback when the answer
+// is empty or the input has ended.
+func (p *Prompter) Ask(question string, fallback string) string {
+	if p.NonInteractive {
+		return fallback
+	}
+	if fallback != "" {
+		fmt.Fprintf(p.writer, "%v [%v]: ", question, fallback)
+	} else {
+		fmt.Fprintf(p.writer, "%v: ", question)
+	}
+	answer, _ := p.reader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return fallback
+	}
+	return answer
+}
+
+// Confirm asks a yes or no question, returning fallback when the answer is
+// empty or the input has ended.
+func (p *Prompter) Confirm(question string, fallback bool) bool {
+	if p.NonInteractive {
+		return false
+	}
+	options := "y/N"
+	if fallback {
+		options = "Y/n"
+	}
+	for {
+		fmt.Fprintf(p.writer, "%v [%v] ", question, options)
+		answer, err := p.reader.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		case "":
+			if err != nil {
+				fmt.Fprintln(p.writer)
+			}
+			return fallback
+		}
+		if err != nil {
+			return fallback
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// QueuedWrite is a write that could not be applied to a backend. Marks are
+// identified by path rather than index because the indexes may have
+// changed by the time the write is replayed.
+type QueuedWrite struct {
+	Op    string   `json:"op"`
+	Mark  Mark     `json:"mark,omitzero"`
+	Paths []string `json:"paths,omitempty"`
+	Marks []Mark   `json:"marks,omitempty"`
+	To    int      `json:"to,omitempty"`
+}
+
+// WriteQueue journals writes, one JSON encoded QueuedWrite per line, until
+// they can be replayed.
+type WriteQueue struct {
+	File     string
+	filePerm os.FileMode
+}
+
+func NewWriteQueue(file string) *WriteQueue {
+	return &WriteQueue{File: file, filePerm: 0660}
+}
+
+func (q *WriteQueue) Append(write QueuedWrite) error {
+	line, err := json.Marshal(write)
+	if err != nil {
+		return err
+	}
+	file, err := markdb.OpenOwned(q.File, os.O_APPEND|os.O_WRONLY|os.O_CREATE, q.filePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func (q *WriteQueue) List() ([]QueuedWrite, error) {
+	file, err := os.Open(q.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var writes []QueuedWrite
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var write QueuedWrite
+		if err := json.Unmarshal(scanner.Bytes(), &write); err != nil {
+			return nil, fmt.Errorf("%v: %v", q.File, err)
+		}
+		writes = append(writes, write)
+	}
+	return writes, scanner.Err()
+}
+
+// set replaces the queued writes.
+func (q *WriteQueue) set(writes []QueuedWrite) error {
+	if len(writes) == 0 {
+		err := os.Remove(q.File)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	file, err := markdb.OpenOwned(q.File, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, q.filePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, write := range writes {
+		line, err := json.Marshal(write)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replay applies the queued writes to db in order, removing each from the
+// queue once applied, and returns how many were replayed. Replay stops,
+// keeping the remaining writes, when db is unavailable, returning the
+// error. Writes that no longer apply, such as updating a mark that has
+// been deleted since, are dropped and returned as conflicts.
+func (q *WriteQueue) Replay(db MarkDB) (replayed int, conflicts []error, err error) {
+	writes, err := q.List()
+	if err != nil {
+		return 0, nil, err
+	}
+	for len(writes) > 0 {
+		err := applyQueuedWrite(db, writes[0])
+		if errors.Is(err, ErrUnavailable) {
+			return replayed, conflicts, err
+		} else if err != nil {
+			conflicts = append(conflicts, fmt.Errorf("dropped queued %v: %v", writes[0].Op, err))
+		} else {
+			replayed++
+		}
+		writes = writes[1:]
+		if err := q.set(writes); err != nil {
+			return replayed, conflicts, err
+		}
+	}
+	return replayed, conflicts, nil
+}
+
+func applyQueuedWrite(db MarkDB, write QueuedWrite) error {
+	marks, err := db.List()
+	if err != nil {
+		return err
+	}
+	indexOf := func(path string) int {
+		return slices.IndexFunc(marks, func(mark Mark) bool { return mark.Path == path })
+	}
+	switch write.Op {
+	case "add":
+		if index := indexOf(write.Mark.Path); index >= 0 {
+			if err := db.Update(index, marks[index].Merge(write.Mark)); err != nil {
+				return err
+			}
+			return db.Move(index, 0)
+		}
+		return db.Add(write.Mark)
+	case "insert":
+		if index := indexOf(write.Mark.Path); index >= 0 {
+			if err := db.Update(index, marks[index].Merge(write.Mark)); err != nil {
+				return err
+			}
+			return db.Move(index, min(write.To, len(marks)-1))
+		}
+		return db.Insert(min(write.To, len(marks)), write.Mark)
+	case "update":
+		index := indexOf(write.Mark.Path)
+		if index < 0 {
+			return fmt.Errorf("%v is no longer marked", write.Mark.Path)
+		}
+		return db.Update(index, write.Mark)
+	case "delete":
+		var indexes []int
+		for _, path := range write.Paths {
+			if index := indexOf(path); index >= 0 {
+				indexes = append(indexes, index)
+			}
+		}
+		if len(indexes) == 0 {
+			return fmt.Errorf("%v already deleted", write.Paths)
+		}
+		return db.DeleteMany(indexes)
+	case "move":
+		index := indexOf(write.Mark.Path)
+		if index < 0 {
+			return fmt.Errorf("%v is no longer marked", write.Mark.Path)
+		}
+		return db.Move(index, min(write.To, len(marks)-1))
+	case "clear":
+		return db.Clear()
+	case "replace":
+		return db.Replace(write.Marks)
+	}
+	return fmt.Errorf("unknown operation %q", write.Op)
+}
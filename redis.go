@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// defaultRedisKey is the key of the list holding the marks unless the
+// redis_key setting says otherwise.
+const defaultRedisKey = "mark:marks"
+
+// RedisMarkDB keeps the marks in a Redis list, one encoded mark per
+// element, newest first like the local db. Get, Add, Update and Clear map
+// onto single list commands; the changes that shift several marks are
+// made atomically with WATCH and MULTI, retried when another client
+// changed the list in between.
+type RedisMarkDB struct {
+	Key    string
+	client *redisClient
+	// ctx cancels the commands, see WithContext.
+	ctx context.Context
+}
+
+// NewRedisMarkDB returns a db on the server of a redis:// or rediss://
+// URL, e.g. redis://:password@localhost:6379/0. It connects on first use.
+func NewRedisMarkDB(serverURL string, key string, timeout time.Duration) (*RedisMarkDB, error) {
+	if key == "" {
+		key = defaultRedisKey
+	}
+	client, err := newRedisClient(serverURL, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisMarkDB{Key: key, client: client, ctx: context.Background()}, nil
+}
+
+func (r *RedisMarkDB) WithContext(ctx context.Context) MarkDB {
+	bound := *r
+	bound.ctx = ctx
+	return &bound
+}
+
+func (r *RedisMarkDB) Get(index int) (Mark, error) {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	reply, err := r.client.do(r.ctx, "LINDEX", r.Key, strconv.Itoa(index))
+	if err != nil {
+		return Mark{}, err
+	}
+	line, ok := reply.(string)
+	if !ok || index < 0 {
+		return Mark{}, errors.New("invalid index")
+	}
+	return markdb.DecodeMark(line)
+}
+
+func (r *RedisMarkDB) GetByName(name string) (int, Mark, error) {
+	marks, err := r.List()
+	if err != nil {
+		return 0, Mark{}, err
+	}
+	return markdb.FindName(marks, name)
+}
+
+func (r *RedisMarkDB) Add(mark Mark) error {
+	line, err := markdb.EncodeMark(mark)
+	if err != nil {
+		return err
+	}
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	_, err = r.client.do(r.ctx, "LPUSH", r.Key, line)
+	return err
+}
+
+func (r *RedisMarkDB) Insert(index int, mark Mark) error {
+	return r.change(func(marks []Mark) ([]Mark, error) {
+		if index < 0 || index > len(marks) {
+			return nil, errors.New("invalid index")
+		}
+		return slices.Insert(marks, index, mark), nil
+	})
+}
+
+func (r *RedisMarkDB) List() ([]Mark, error) {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	return r.list()
+}
+
+func (r *RedisMarkDB) list() ([]Mark, error) {
+	reply, err := r.client.do(r.ctx, "LRANGE", r.Key, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	lines, _ := reply.([]any)
+	var marks []Mark
+	for _, line := range lines {
+		text, _ := line.(string)
+		mark, err := markdb.DecodeMark(text)
+		if err != nil {
+			return nil, fmt.Errorf("redis %v: %v", r.Key, err)
+		}
+		marks = append(marks, mark)
+	}
+	return marks, nil
+}
+
+func (r *RedisMarkDB) Clear() error {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	_, err := r.client.do(r.ctx, "DEL", r.Key)
+	return err
+}
+
+func (r *RedisMarkDB) Replace(marks []Mark) error {
+	return r.change(func([]Mark) ([]Mark, error) {
+		return slices.Clone(marks), nil
+	})
+}
+
+func (r *RedisMarkDB) Delete(index int) error {
+	return r.DeleteMany([]int{index})
+}
+
+func (r *RedisMarkDB) DeleteMany(indexes []int) error {
+	return r.change(func(marks []Mark) ([]Mark, error) {
+		var remaining []Mark
+		for index, mark := range marks {
+			if !slices.Contains(indexes, index) {
+				remaining = append(remaining, mark)
+			}
+		}
+		for _, index := range indexes {
+			if index < 0 || index >= len(marks) {
+				return nil, errors.New("invalid index")
+			}
+		}
+		return remaining, nil
+	})
+}
+
+func (r *RedisMarkDB) Update(index int, mark Mark) error {
+	line, err := markdb.EncodeMark(mark)
+	if err != nil {
+		return err
+	}
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	if index < 0 {
+		return errors.New("invalid index")
+	}
+	if _, err := r.client.do(r.ctx, "LSET", r.Key, strconv.Itoa(index), line); err != nil {
+		var redisErr redisError
+		if errors.As(err, &redisErr) {
+			return errors.New("invalid index")
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *RedisMarkDB) Move(from int, to int) error {
+	return r.change(func(marks []Mark) ([]Mark, error) {
+		if from < 0 || from >= len(marks) || to < 0 || to >= len(marks) {
+			return nil, errors.New("invalid index")
+		}
+		mark := marks[from]
+		marks = slices.Delete(marks, from, from+1)
+		return slices.Insert(marks, to, mark), nil
+	})
+}
+
+// change replaces the marks with the result of apply in a transaction,
+// retrying when another client changes them before it commits.
+func (r *RedisMarkDB) change(apply func(marks []Mark) ([]Mark, error)) error {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	for {
+		if _, err := r.client.do(r.ctx, "WATCH", r.Key); err != nil {
+			return err
+		}
+		marks, err := r.list()
+		if err == nil {
+			marks, err = apply(marks)
+		}
+		if err != nil {
+			r.client.do(r.ctx, "UNWATCH")
+			return err
+		}
+		commands := [][]string{{"MULTI"}, {"DEL", r.Key}}
+		if len(marks) > 0 {
+			push := []string{"RPUSH", r.Key}
+			for _, mark := range marks {
+				line, err := markdb.EncodeMark(mark)
+				if err != nil {
+					r.client.do(r.ctx, "UNWATCH")
+					return err
+				}
+				push = append(push, line)
+			}
+			commands = append(commands, push)
+		}
+		commands = append(commands, []string{"EXEC"})
+		replies, err := r.client.pipeline(r.ctx, commands)
+		if err != nil {
+			return err
+		}
+		// EXEC answers nil when the watched list changed.
+		switch reply := replies[len(replies)-1].(type) {
+		case redisError:
+			return reply
+		case nil:
+			continue
+		}
+		return nil
+	}
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisClient speaks just enough of the Redis protocol, RESP, for
+// RedisMarkDB: commands are arrays of bulk strings and replies are
+// decoded into strings, int64s, []any, nil or redisError.
+type redisClient struct {
+	// mu is held by RedisMarkDB for each use of the connection.
+	mu      sync.Mutex
+	url     *url.URL
+	timeout time.Duration
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+func newRedisClient(serverURL string, timeout time.Duration) (*redisClient, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "redis" && parsed.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid redis_url %q, it must start with redis:// or rediss://", parsed.Redacted())
+	}
+	if timeout == 0 {
+		timeout = defaultRemoteTimeout
+	}
+	return &redisClient{url: parsed, timeout: timeout}, nil
+}
+
+// connect connects to the server, logging in and selecting the database
+// of the URL.
+func (c *redisClient) connect(ctx context.Context) error {
+	parsed := c.url
+	address := parsed.Host
+	if parsed.Port() == "" {
+		address = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if parsed.Scheme == "rediss" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: parsed.Hostname()}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+	if password, ok := parsed.User.Password(); ok {
+		auth := []string{"AUTH", password}
+		if user := parsed.User.Username(); user != "" {
+			auth = []string{"AUTH", user, password}
+		}
+		if _, err := c.do(ctx, auth...); err != nil {
+			c.close()
+			return err
+		}
+	}
+	if db := strings.Trim(parsed.Path, "/"); db != "" {
+		if _, err := c.do(ctx, "SELECT", db); err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// do sends a command and returns its reply.
+func (c *redisClient) do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.pipeline(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(redisError); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// pipeline sends the commands at once and returns their replies, error
+// replies included. Failing to talk to the server wraps ErrUnavailable.
+// The commands are cut short once ctx is done.
+func (c *redisClient) pipeline(ctx context.Context, commands [][]string) ([]any, error) {
+	if c.conn == nil {
+		if err := c.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	conn := c.conn
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer func() {
+		// Once ctx is done the deadline may be cut short at any time, so
+		// the connection is not used again.
+		if !stop() {
+			c.close()
+		}
+	}()
+	writer := bufio.NewWriter(c.conn)
+	for _, args := range commands {
+		fmt.Fprintf(writer, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		c.close()
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	replies := make([]any, len(commands))
+	for index := range commands {
+		reply, err := c.read()
+		if err != nil {
+			// The replies still to come would answer the next commands.
+			c.close()
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		replies[index] = reply
+	}
+	return replies, nil
+}
+
+func (c *redisClient) read() (any, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch kind, value := line[0], line[1:]; kind {
+	case '+':
+		return value, nil
+	case '-':
+		return redisError(value), nil
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:length]), nil
+	case '*':
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]any, count)
+		for index := range items {
+			if items[index], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// errNoFileID is returned when a mark has no recorded device and inode.
+var errNoFileID = errors.New("no device and inode were recorded for the mark, mark it again to record them")
+
+// RecordFileID stores the device and inode of the marked directory, when
+// the platform has them.
+func RecordFileID(mark *Mark, path string) {
+	mark.Device, mark.Inode, _ = markdb.FileID(path)
+}
+
+// SameDirectory reports whether path is the directory that was marked,
+// comparing device and inode numbers.
+func SameDirectory(mark Mark, path string) (bool, error) {
+	if mark.Inode == 0 {
+		return false, errNoFileID
+	}
+	device, inode, ok := markdb.FileID(path)
+	if !ok {
+		return false, errors.New("device and inode numbers are not available for " + path)
+	}
+	return device == mark.Device && inode == mark.Inode, nil
+}
+
+// FindMoved searches the directories beneath root, at most maxDepth levels
+// deep, for the marked directory after it moved. Hidden directories and
+// directories matching the exclude globs are skipped.
+func FindMoved(mark Mark, root string, maxDepth int, exclude []string) (string, error) {
+	if mark.Inode == 0 {
+		return "", errNoFileID
+	}
+	found := ""
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		if path != root {
+			relative, _ := filepath.Rel(root, path)
+			depth := strings.Count(relative, string(filepath.Separator)) + 1
+			if depth > maxDepth || strings.HasPrefix(entry.Name(), ".") || MatchesAny(path, exclude) {
+				return filepath.SkipDir
+			}
+		}
+		if same, _ := SameDirectory(mark, path); same {
+			found = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if found == "" {
+		return "", errors.New("the directory was not found beneath " + root)
+	}
+	return found, nil
+}
+
+// nearestExisting returns the nearest ancestor of path that still exists.
+func nearestExisting(path string) string {
+	for _, ancestor := range Ancestors(path) {
+		if _, err := os.Stat(ancestor); err == nil {
+			return ancestor
+		}
+	}
+	return string(filepath.Separator)
+}
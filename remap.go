@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// remapCommands are the commands that can renumber the marks.
+var remapCommands = map[string]bool{
+	"add":       true,
+	"clear":     true,
+	"clone":     true,
+	"delete":    true,
+	"gc":        true,
+	"import":    true,
+	"move-to":   true,
+	"prune":     true,
+	"scan":      true,
+	"swap":      true,
+	"top":       true,
+	"ui":        true,
+	"unarchive": true,
+}
+
+// IndexChange records the index of a mark before and after a change. Old
+// is -1 for an added mark and New is -1 for a deleted one.
+type IndexChange struct {
+	Old  int
+	New  int
+	Path string
+}
+
+// Remap compares the marked paths before and after a change and returns
+// the marks whose index changed.
+func Remap(before []string, after []string) []IndexChange {
+	var changes []IndexChange
+	for old, path := range before {
+		if now := slices.Index(after, path); now != old {
+			changes = append(changes, IndexChange{Old: old, New: now, Path: path})
+		}
+	}
+	for now, path := range after {
+		if !slices.Contains(before, path) {
+			changes = append(changes, IndexChange{Old: -1, New: now, Path: path})
+		}
+	}
+	slices.SortStableFunc(changes, func(a, b IndexChange) int {
+		return a.Old - b.Old
+	})
+	return changes
+}
+
+// WriteRemap prints the changes as "[old] -> [new] path" lines.
+func WriteRemap(w io.Writer, changes []IndexChange, roots map[string]string) {
+	for _, change := range changes {
+		old, now := fmt.Sprintf("[%v]", change.Old), fmt.Sprintf("[%v]", change.New)
+		if change.Old < 0 {
+			old = "new"
+		}
+		if change.New < 0 {
+			now = "deleted"
+		}
+		fmt.Fprintf(w, "%v -> %v %v\n", old, now, ShortenPath(change.Path, roots))
+	}
+}
+
+// RemapJournal keeps the order of the marks from before the last change
+// that renumbered them, one path per line, so that old indexes can still
+// be resolved.
+type RemapJournal struct {
+	File     string
+	filePerm os.FileMode
+}
+
+func NewRemapJournal(file string) *RemapJournal {
+	return &RemapJournal{File: file, filePerm: 0660}
+}
+
+func (j *RemapJournal) Save(paths []string) error {
+	file, err := markdb.OpenOwned(j.File, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, j.filePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return writePaths(file, paths, false)
+}
+
+func (j *RemapJournal) Load() ([]string, error) {
+	file, err := os.Open(j.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New("the marks have not been renumbered yet")
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		paths = append(paths, scanner.Text())
+	}
+	return paths, scanner.Err()
+}
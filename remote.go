@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// RemoteMarkDB keeps the marks on a server, such as "mark serve", shared
+// by a team or several machines. It speaks a small JSON API:
+//
+//	GET    /marks             the marks
+//	POST   /marks             add the mark in the body
+//	GET    /marks/{index}     the mark at index
+//	PUT    /marks/{index}     replace the mark at index with the body
+//	DELETE /marks/{index}     delete the mark at index
+//	GET    /names/{name}      {"index": ..., "mark": ...} of the named mark
+//	POST   /marks/delete      delete {"indexes": [...]}
+//	POST   /marks/move        move {"from": ..., "to": ...}
+//	POST   /marks/insert      insert {"index": ..., "mark": ...}
+//	POST   /marks/clear       delete all of the marks
+//
+// Requests carry the token as "Authorization: Bearer <token>". Failures
+// are answered with an error status and {"error": "<message>"}.
+type RemoteMarkDB struct {
+	URL    string
+	Token  string
+	client *http.Client
+	// ctx cancels the requests, see WithContext.
+	ctx context.Context
+}
+
+// defaultRemoteTimeout bounds the requests to the server unless the timeout
+// setting says otherwise.
+const defaultRemoteTimeout = 10 * time.Second
+
+func NewRemoteMarkDB(serverURL string, token string, timeout time.Duration) (*RemoteMarkDB, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("invalid remote_url %q, it must start with http:// or https://", serverURL)
+	}
+	if timeout == 0 {
+		timeout = defaultRemoteTimeout
+	}
+	return &RemoteMarkDB{
+		URL:    strings.TrimSuffix(serverURL, "/"),
+		Token:  token,
+		client: &http.Client{Timeout: timeout},
+		ctx:    context.Background(),
+	}, nil
+}
+
+func (r *RemoteMarkDB) WithContext(ctx context.Context) MarkDB {
+	bound := *r
+	bound.ctx = ctx
+	return &bound
+}
+
+// remoteError is the body of a failed request.
+type remoteError struct {
+	Error string `json:"error"`
+}
+
+// namedMark is the body of a /names/{name} response.
+type namedMark struct {
+	Index int  `json:"index"`
+	Mark  Mark `json:"mark"`
+}
+
+// indexList is the body of a /marks/delete request.
+type indexList struct {
+	Indexes []int `json:"indexes"`
+}
+
+// insertRequest is the body of a /marks/insert request.
+type insertRequest struct {
+	Index int  `json:"index"`
+	Mark  Mark `json:"mark"`
+}
+
+// moveRequest is the body of a /marks/move request.
+type moveRequest struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+func (r *RemoteMarkDB) Get(index int) (Mark, error) {
+	var mark Mark
+	err := r.do(http.MethodGet, "/marks/"+strconv.Itoa(index), nil, &mark)
+	return mark, err
+}
+
+func (r *RemoteMarkDB) GetByName(name string) (int, Mark, error) {
+	var found namedMark
+	err := r.do(http.MethodGet, "/names/"+url.PathEscape(name), nil, &found)
+	if errors.Is(err, markdb.ErrNameNotFound) {
+		err = fmt.Errorf("%w: %v", markdb.ErrNameNotFound, name)
+	}
+	return found.Index, found.Mark, err
+}
+
+func (r *RemoteMarkDB) Add(mark Mark) error {
+	return r.do(http.MethodPost, "/marks", mark, nil)
+}
+
+func (r *RemoteMarkDB) Insert(index int, mark Mark) error {
+	return r.do(http.MethodPost, "/marks/insert", insertRequest{index, mark}, nil)
+}
+
+func (r *RemoteMarkDB) List() ([]Mark, error) {
+	var marks []Mark
+	err := r.do(http.MethodGet, "/marks", nil, &marks)
+	return marks, err
+}
+
+func (r *RemoteMarkDB) Clear() error {
+	return r.do(http.MethodPost, "/marks/clear", nil, nil)
+}
+
+func (r *RemoteMarkDB) Replace(marks []Mark) error {
+	if marks == nil {
+		marks = []Mark{}
+	}
+	return r.do(http.MethodPut, "/marks", marks, nil)
+}
+
+func (r *RemoteMarkDB) Delete(index int) error {
+	return r.do(http.MethodDelete, "/marks/"+strconv.Itoa(index), nil, nil)
+}
+
+func (r *RemoteMarkDB) DeleteMany(indexes []int) error {
+	return r.do(http.MethodPost, "/marks/delete", indexList{indexes}, nil)
+}
+
+func (r *RemoteMarkDB) Update(index int, mark Mark) error {
+	return r.do(http.MethodPut, "/marks/"+strconv.Itoa(index), mark, nil)
+}
+
+func (r *RemoteMarkDB) Move(from int, to int) error {
+	return r.do(http.MethodPost, "/marks/move", moveRequest{from, to}, nil)
+}
+
+// do sends a request with body, if not nil, encoded as JSON and decodes
+// the response into result, if not nil. Failing to reach the server, and
+// server errors, wrap ErrUnavailable so the fallback chain can use the
+// next backend.
+func (r *RemoteMarkDB) do(method string, path string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequestWithContext(r.ctx, method, r.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if r.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		var failure remoteError
+		if json.NewDecoder(response.Body).Decode(&failure) != nil || failure.Error == "" {
+			failure.Error = response.Status
+		}
+		switch {
+		case response.StatusCode >= 500:
+			return fmt.Errorf("%w: %v: %v", ErrUnavailable, r.URL, failure.Error)
+		case response.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/names/"):
+			return markdb.ErrNameNotFound
+		case response.StatusCode == http.StatusUnauthorized:
+			return fmt.Errorf("%v: %v, check remote_token", r.URL, failure.Error)
+		}
+		return errors.New(failure.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("%v: invalid response: %v", r.URL, err)
+	}
+	return nil
+}
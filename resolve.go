@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// ResolveMark turns a mark identifier into an index into marks. Every
+// command that takes a mark accepts the same grammar:
+//
+//	2, -1        an index, negative indexes counting from the end
+//	@3fa2        the id of a mark, or the start of it
+//	api          the name of a mark
+//	~/src/api    a path that is marked
+//	api          a query matched against the marked paths, trying the
+//	             directory name, a prefix of the directory name, a
+//	             substring and finally a fuzzy match
+//
+// When a query matches several marks as a substring or fuzzily, the one
+// scoring best with FuzzyScore is taken. Otherwise, or when several score
+// the same, an error listing them is returned.
+func ResolveMark(marks []Mark, identifier string) (int, error) {
+	return resolveMark(marks, identifier, true)
+}
+
+// ResolveMarkExact is like ResolveMark but only accepts an index, a name
+// or the exact path of a mark, never a query.
+func ResolveMarkExact(marks []Mark, identifier string) (int, error) {
+	return resolveMark(marks, identifier, false)
+}
+
+func resolveMark(marks []Mark, identifier string, queries bool) (int, error) {
+	if identifier == "" {
+		return 0, errors.New("empty mark identifier")
+	}
+	length := liveLength(marks)
+	if index, err := strconv.Atoi(identifier); err == nil {
+		resolved, err := resolveIndex(length, index)
+		if err != nil || marks[resolved].IsDeleted() {
+			nearest := slices.DeleteFunc(nearestIndexes(length, index), func(index int) bool {
+				return marks[index].IsDeleted()
+			})
+			return 0, candidatesError(indexRangeMessage(length, index), marks, nearest)
+		}
+		return resolved, nil
+	}
+	if id, ok := strings.CutPrefix(identifier, "@"); ok {
+		return findID(marks, id)
+	}
+	if index, _, err := markdb.FindName(marks, identifier); err == nil {
+		return index, nil
+	}
+
+	matchers := []pathMatcher{{
+		match: func(path string) bool {
+			return path == filepath.Clean(markdb.ExpandHome(identifier))
+		},
+	}}
+	if queries {
+		matchers = append(matchers, queryMatchers(identifier)...)
+	}
+	for _, matcher := range matchers {
+		var matches []int
+		for index, mark := range marks {
+			if !mark.IsDeleted() && matcher.match(mark.Path) {
+				matches = append(matches, index)
+			}
+		}
+		if len(matches) > 1 && matcher.scored {
+			matches = bestScoring(marks, identifier, matches)
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		} else if len(matches) > 1 {
+			return 0, candidatesError(fmt.Sprintf("%q matches several marks", identifier), marks, matches)
+		}
+	}
+	return 0, candidatesError(fmt.Sprintf("no mark matches %q", identifier), marks, suggestMarks(marks, identifier))
+}
+
+// findID returns the index of the live mark whose id is, or starts with,
+// id.
+func findID(marks []Mark, id string) (int, error) {
+	var matches []int
+	for index, mark := range marks {
+		if id != "" && !mark.IsDeleted() && strings.HasPrefix(mark.ID(), strings.ToLower(id)) {
+			matches = append(matches, index)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("no mark has the id @%v", id)
+	case 1:
+		return matches[0], nil
+	}
+	return 0, candidatesError(fmt.Sprintf("@%v is the start of the id of several marks", id), marks, matches)
+}
+
+// SplitSubpath splits an identifier such as "api/cmd/server" into the mark
+// identifier "api" and the subpath "cmd/server" beneath it. Paths, which
+// start with "/", "~", "." or a Windows volume such as "C:", are not split.
+func SplitSubpath(identifier string) (string, string) {
+	if strings.HasPrefix(identifier, "/") || strings.HasPrefix(identifier, "~") || strings.HasPrefix(identifier, ".") ||
+		filepath.IsAbs(identifier) || filepath.VolumeName(identifier) != "" {
+		return identifier, ""
+	}
+	separator := strings.IndexAny(identifier, "/"+string(filepath.Separator))
+	if separator < 0 {
+		return identifier, ""
+	}
+	return identifier[:separator], identifier[separator+1:]
+}
+
+// pathMatcher matches the paths of the marks. When it matches several and
+// scored is set, the best of them by FuzzyScore is taken.
+type pathMatcher struct {
+	match  func(path string) bool
+	scored bool
+}
+
+// queryMatchers returns the matchers for a query, from the most to the
+// least specific.
+func queryMatchers(identifier string) []pathMatcher {
+	lowerIdentifier := strings.ToLower(identifier)
+	return []pathMatcher{
+		{match: func(path string) bool {
+			return filepath.Base(path) == identifier
+		}},
+		{match: func(path string) bool {
+			return strings.HasPrefix(strings.ToLower(filepath.Base(path)), lowerIdentifier)
+		}},
+		{match: func(path string) bool {
+			return strings.Contains(strings.ToLower(path), lowerIdentifier)
+		}, scored: true},
+		{match: func(path string) bool {
+			return isSubsequence(lowerIdentifier, strings.ToLower(path))
+		}, scored: true},
+	}
+}
+
+// bestScoring returns the mark of matches whose path scores best against
+// the query, or all the matches, best first, when several score the same.
+func bestScoring(marks []Mark, query string, matches []int) []int {
+	scores := map[int]int{}
+	for _, index := range matches {
+		scores[index] = FuzzyScore(query, marks[index].Path)
+	}
+	ranked := slices.Clone(matches)
+	slices.SortStableFunc(ranked, func(a, b int) int {
+		return scores[b] - scores[a]
+	})
+	if scores[ranked[0]] > scores[ranked[1]] {
+		return ranked[:1]
+	}
+	return ranked
+}
+
+// FuzzyScore scores how well query matches path, ignoring case, taking the
+// best way of matching the characters of query in order within path: each
+// character counts, more so when it follows the one matched before, starts
+// a directory name or a word within one, or is in the last directory name.
+// A path the query does not match scores -1.
+func FuzzyScore(query string, path string) int {
+	const (
+		matched     = 1
+		consecutive = 5
+		wordStart   = 3
+		inBase      = 2
+		none        = -1 << 30
+	)
+	q := []rune(strings.ToLower(query))
+	p := []rune(strings.ToLower(path))
+	if len(q) == 0 {
+		return 0
+	}
+	if len(p) == 0 {
+		return -1
+	}
+	baseStart := len(p) - len([]rune(filepath.Base(path)))
+	// previous[j] is the best score of the query up to the last character
+	// matched with that character at p[j], or none.
+	previous := make([]int, len(p))
+	current := make([]int, len(p))
+	for i, qr := range q {
+		// before is the best of previous[k] for k < j-1.
+		before := none
+		for j, pr := range p {
+			if i > 0 && j >= 2 {
+				before = max(before, previous[j-2])
+			}
+			current[j] = none
+			if pr != qr {
+				continue
+			}
+			score := matched
+			if j == 0 || strings.ContainsRune("/\\-_. ", p[j-1]) {
+				score += wordStart
+			}
+			if j >= baseStart {
+				score += inBase
+			}
+			if i > 0 {
+				best := before
+				if j >= 1 && previous[j-1] != none {
+					best = max(best, previous[j-1]+consecutive)
+				}
+				if best == none {
+					continue
+				}
+				score += best
+			}
+			current[j] = score
+		}
+		previous, current = current, previous
+	}
+	best := slices.Max(previous)
+	if best == none {
+		return -1
+	}
+	return best
+}
+
+// ParseIndexRange parses an inclusive range of indexes such as "3-8".
+func ParseIndexRange(identifier string) (int, int, bool) {
+	first, last, ok := strings.Cut(identifier, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	from, err := strconv.ParseUint(first, 10, 31)
+	if err != nil {
+		return 0, 0, false
+	}
+	to, err := strconv.ParseUint(last, 10, 31)
+	if err != nil {
+		return 0, 0, false
+	}
+	return int(from), int(to), true
+}
+
+func resolveIndex(length int, index int) (int, error) {
+	if index < 0 {
+		index += length
+	}
+	if index < 0 || index >= length {
+		return 0, errors.New("invalid index")
+	}
+	return index, nil
+}
+
+// indexRangeMessage explains why index is not a valid index into a list of
+// length marks.
+func indexRangeMessage(length int, index int) string {
+	switch length {
+	case 0:
+		return fmt.Sprintf("no mark at index %v, there are no marks", index)
+	case 1:
+		return fmt.Sprintf("no mark at index %v, there is 1 mark (0, or -1)", index)
+	}
+	return fmt.Sprintf("no mark at index %v, there are %v marks (0 to %v, or -%v to -1)", index, length, length-1, length)
+}
+
+// nearestIndexes returns up to three valid indexes closest to an index
+// outside of a list of length marks: the oldest marks for an index past the
+// end and the newest for a negative index past the start.
+func nearestIndexes(length int, index int) []int {
+	var indexes []int
+	if index >= 0 {
+		for i := max(0, length-3); i < length; i++ {
+			indexes = append(indexes, i)
+		}
+	} else {
+		for i := 0; i < min(3, length); i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// candidatesError builds an error whose message lists the given marks.
+func candidatesError(message string, marks []Mark, indexes []int) error {
+	if len(indexes) == 0 {
+		return errors.New(message)
+	}
+	var builder strings.Builder
+	builder.WriteString(message)
+	if strings.HasPrefix(message, "no mark") {
+		builder.WriteString(", did you mean:")
+	} else {
+		builder.WriteString(":")
+	}
+	for _, index := range indexes {
+		fmt.Fprintf(&builder, "\n\t%v", FormatMark(index, marks[index]))
+	}
+	return errors.New(builder.String())
+}
+
+// suggestMarks returns the indexes of marks whose name or directory name is
+// close to the identifier.
+func suggestMarks(marks []Mark, identifier string) []int {
+	identifier = strings.ToLower(identifier)
+	maxDistance := max(2, len(identifier)/3)
+	var suggestions []int
+	for index, mark := range marks {
+		if mark.IsDeleted() {
+			continue
+		}
+		name := strings.ToLower(filepath.Base(mark.Path))
+		if editDistance(identifier, name) <= maxDistance ||
+			mark.Name != "" && editDistance(identifier, strings.ToLower(mark.Name)) <= maxDistance {
+			suggestions = append(suggestions, index)
+		}
+	}
+	return suggestions
+}
+
+// isSubsequence reports whether the characters of query appear in order
+// within s.
+func isSubsequence(query string, s string) bool {
+	for _, r := range s {
+		if query == "" {
+			break
+		}
+		if strings.HasPrefix(query, string(r)) {
+			query = query[len(string(r)):]
+		}
+	}
+	return query == ""
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	ar, br := []rune(a), []rune(b)
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(br)]
+}
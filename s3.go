@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// defaultS3Key is the key of the object holding the marks unless the
+// s3_key setting says otherwise.
+const defaultS3Key = "mark/marks"
+
+// s3Retries bounds how often a change is retried when another machine
+// changes the object in between.
+const s3Retries = 5
+
+// S3MarkDB keeps the db, in the format of the local db, as an object in
+// S3 compatible storage so that the marks follow their owner across
+// short-lived machines. Every change reads the object and writes it back
+// only if its ETag is unchanged, retrying otherwise, so that concurrent
+// changes from several machines are never lost.
+type S3MarkDB struct {
+	Bucket string
+	Key    string
+	client *s3Client
+	// ctx cancels the requests, see WithContext.
+	ctx context.Context
+}
+
+// NewS3MarkDB returns a db stored at key in bucket. Credentials are read
+// from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN.
+// endpoint defaults to AWS in region; other providers, such as MinIO, are
+// used by giving their endpoint.
+func NewS3MarkDB(endpoint string, region string, bucket string, key string, timeout time.Duration) (*S3MarkDB, error) {
+	if bucket == "" {
+		return nil, errors.New("s3_bucket is not set")
+	}
+	if key == "" {
+		key = defaultS3Key
+	}
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%v.amazonaws.com", region)
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("invalid s3_endpoint %q, it must start with http:// or https://", endpoint)
+	}
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to use the s3 backend")
+	}
+	if timeout == 0 {
+		timeout = defaultRemoteTimeout
+	}
+	return &S3MarkDB{
+		Bucket: bucket,
+		Key:    strings.TrimPrefix(key, "/"),
+		client: &s3Client{
+			endpoint:     parsed,
+			region:       region,
+			accessKey:    accessKey,
+			secretKey:    secretKey,
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			http:         &http.Client{Timeout: timeout},
+		},
+		ctx: context.Background(),
+	}, nil
+}
+
+func (s *S3MarkDB) WithContext(ctx context.Context) MarkDB {
+	bound := *s
+	bound.ctx = ctx
+	return &bound
+}
+
+func (s *S3MarkDB) Get(index int) (Mark, error) {
+	marks, _, err := s.read()
+	if err != nil {
+		return Mark{}, err
+	}
+	if index < 0 || index >= len(marks) {
+		return Mark{}, errors.New("invalid index")
+	}
+	return marks[index], nil
+}
+
+func (s *S3MarkDB) GetByName(name string) (int, Mark, error) {
+	marks, _, err := s.read()
+	if err != nil {
+		return 0, Mark{}, err
+	}
+	return markdb.FindName(marks, name)
+}
+
+func (s *S3MarkDB) Add(mark Mark) error {
+	return s.Insert(0, mark)
+}
+
+func (s *S3MarkDB) Insert(index int, mark Mark) error {
+	return s.change(func(marks []Mark) ([]Mark, error) {
+		if index < 0 || index > len(marks) {
+			return nil, errors.New("invalid index")
+		}
+		return slices.Insert(marks, index, mark), nil
+	})
+}
+
+func (s *S3MarkDB) List() ([]Mark, error) {
+	marks, _, err := s.read()
+	return marks, err
+}
+
+func (s *S3MarkDB) Clear() error {
+	return s.change(func(marks []Mark) ([]Mark, error) {
+		return nil, nil
+	})
+}
+
+func (s *S3MarkDB) Replace(marks []Mark) error {
+	return s.change(func([]Mark) ([]Mark, error) {
+		return slices.Clone(marks), nil
+	})
+}
+
+func (s *S3MarkDB) Delete(index int) error {
+	return s.DeleteMany([]int{index})
+}
+
+func (s *S3MarkDB) DeleteMany(indexes []int) error {
+	return s.change(func(marks []Mark) ([]Mark, error) {
+		for _, index := range indexes {
+			if index < 0 || index >= len(marks) {
+				return nil, errors.New("invalid index")
+			}
+		}
+		var remaining []Mark
+		for index, mark := range marks {
+			if !slices.Contains(indexes, index) {
+				remaining = append(remaining, mark)
+			}
+		}
+		return remaining, nil
+	})
+}
+
+func (s *S3MarkDB) Update(index int, mark Mark) error {
+	return s.change(func(marks []Mark) ([]Mark, error) {
+		if index < 0 || index >= len(marks) {
+			return nil, errors.New("invalid index")
+		}
+		marks[index] = mark
+		return marks, nil
+	})
+}
+
+func (s *S3MarkDB) Move(from int, to int) error {
+	return s.change(func(marks []Mark) ([]Mark, error) {
+		if from < 0 || from >= len(marks) || to < 0 || to >= len(marks) {
+			return nil, errors.New("invalid index")
+		}
+		mark := marks[from]
+		marks = slices.Delete(marks, from, from+1)
+		return slices.Insert(marks, to, mark), nil
+	})
+}
+
+// read returns the marks and the ETag of the object, which is empty when
+// the object does not exist yet.
+func (s *S3MarkDB) read() ([]Mark, string, error) {
+	response, err := s.client.do(s.ctx, http.MethodGet, s.Bucket, s.Key, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if err := s3Error(response); err != nil {
+		return nil, "", err
+	}
+	marks, err := markdb.ReadDB(response.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("s3://%v/%v: %v", s.Bucket, s.Key, err)
+	}
+	return marks, response.Header.Get("ETag"), nil
+}
+
+// change writes the result of apply back if the object is unchanged since
+// it was read, retrying with the new marks otherwise.
+func (s *S3MarkDB) change(apply func(marks []Mark) ([]Mark, error)) error {
+	for attempt := 0; attempt < s3Retries; attempt++ {
+		marks, etag, err := s.read()
+		if err != nil {
+			return err
+		}
+		marks, err = apply(marks)
+		if err != nil {
+			return err
+		}
+		var body bytes.Buffer
+		if err := markdb.WriteDB(&body, marks); err != nil {
+			return err
+		}
+		header := http.Header{}
+		if etag != "" {
+			header.Set("If-Match", etag)
+		} else {
+			header.Set("If-None-Match", "*")
+		}
+		response, err := s.client.do(s.ctx, http.MethodPut, s.Bucket, s.Key, header, body.Bytes())
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		// 412 when the object changed, 409 when another write to it is in
+		// progress.
+		if response.StatusCode == http.StatusPreconditionFailed || response.StatusCode == http.StatusConflict {
+			continue
+		}
+		return s3Error(response)
+	}
+	return fmt.Errorf("s3://%v/%v keeps changing, giving up after %v attempts", s.Bucket, s.Key, s3Retries)
+}
+
+// s3Error returns the error of a failed response. Server errors wrap
+// ErrUnavailable so the fallback chain can use the next backend.
+func s3Error(response *http.Response) error {
+	if response.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+	message := response.Status
+	if code := xmlElement(string(body), "Code"); code != "" {
+		message = fmt.Sprintf("%v: %v", code, xmlElement(string(body), "Message"))
+	}
+	if response.StatusCode >= 500 {
+		return fmt.Errorf("%w: s3: %v", ErrUnavailable, message)
+	}
+	return fmt.Errorf("s3: %v", message)
+}
+
+// xmlElement returns the text of the first element called name in the
+// error document of S3, which is too simple to need a parser.
+func xmlElement(document string, name string) string {
+	_, rest, ok := strings.Cut(document, "<"+name+">")
+	if !ok {
+		return ""
+	}
+	text, _, _ := strings.Cut(rest, "</"+name+">")
+	return text
+}
+
+// s3Client sends requests to S3 signed with AWS Signature Version 4,
+// addressing objects by path, endpoint/bucket/key, which every S3
+// compatible service supports.
+type s3Client struct {
+	endpoint     *url.URL
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	http         *http.Client
+}
+
+func (c *s3Client) do(ctx context.Context, method string, bucket string, key string, header http.Header, body []byte) (*http.Response, error) {
+	target := *c.endpoint
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + bucket + "/" + key
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	c.sign(request, body, time.Now().UTC())
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return response, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to request.
+func (c *s3Client) sign(request *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+	signed := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") || strings.HasPrefix(lower, "if-") {
+			signed[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := slices.Sorted(maps.Keys(signed))
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%v:%v\n", name, signed[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%v/%v/s3/aws4_request", now.Format("20060102"), c.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), now.Format("20060102"))
+	for _, part := range []string{c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v", c.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
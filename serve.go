@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// defaultListenAddress is where "mark serve" listens unless told otherwise.
+const defaultListenAddress = ":8745"
+
+// MarkServer exposes a MarkDB over the JSON API RemoteMarkDB speaks, so
+// that one mark binary can serve the marks the others use as their remote
+// backend.
+type MarkServer struct {
+	db    MarkDB
+	token string
+	mux   *http.ServeMux
+}
+
+// NewMarkServer serves db. Unless token is empty, requests must carry it
+// as a bearer token.
+func NewMarkServer(db MarkDB, token string) *MarkServer {
+	s := &MarkServer{db: db, token: token, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /marks", s.list)
+	s.mux.HandleFunc("POST /marks", s.add)
+	s.mux.HandleFunc("PUT /marks", s.replace)
+	s.mux.HandleFunc("GET /marks/{index}", s.get)
+	s.mux.HandleFunc("PUT /marks/{index}", s.update)
+	s.mux.HandleFunc("DELETE /marks/{index}", s.delete)
+	s.mux.HandleFunc("GET /names/{name}", s.getByName)
+	s.mux.HandleFunc("POST /marks/delete", s.deleteMany)
+	s.mux.HandleFunc("POST /marks/move", s.move)
+	s.mux.HandleFunc("POST /marks/insert", s.insert)
+	s.mux.HandleFunc("POST /marks/clear", s.clear)
+	return s
+}
+
+func (s *MarkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *MarkServer) list(w http.ResponseWriter, r *http.Request) {
+	marks, err := s.db.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if marks == nil {
+		marks = []Mark{}
+	}
+	writeJSON(w, marks)
+}
+
+func (s *MarkServer) add(w http.ResponseWriter, r *http.Request) {
+	var mark Mark
+	if !readJSON(w, r, &mark) {
+		return
+	}
+	if mark.Path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("the mark has no path"))
+		return
+	}
+	s.write(w, s.db.Add(mark))
+}
+
+func (s *MarkServer) replace(w http.ResponseWriter, r *http.Request) {
+	var marks []Mark
+	if !readJSON(w, r, &marks) {
+		return
+	}
+	for _, mark := range marks {
+		if mark.Path == "" {
+			writeError(w, http.StatusBadRequest, errors.New("a mark has no path"))
+			return
+		}
+	}
+	s.write(w, s.db.Replace(marks))
+}
+
+func (s *MarkServer) get(w http.ResponseWriter, r *http.Request) {
+	index, ok := s.index(w, r)
+	if !ok {
+		return
+	}
+	mark, err := s.db.Get(index)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, mark)
+}
+
+func (s *MarkServer) update(w http.ResponseWriter, r *http.Request) {
+	index, ok := s.index(w, r)
+	if !ok {
+		return
+	}
+	var mark Mark
+	if !readJSON(w, r, &mark) {
+		return
+	}
+	s.write(w, s.db.Update(index, mark))
+}
+
+func (s *MarkServer) delete(w http.ResponseWriter, r *http.Request) {
+	index, ok := s.index(w, r)
+	if !ok {
+		return
+	}
+	s.write(w, s.db.Delete(index))
+}
+
+func (s *MarkServer) getByName(w http.ResponseWriter, r *http.Request) {
+	index, mark, err := s.db.GetByName(r.PathValue("name"))
+	if errors.Is(err, markdb.ErrNameNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, namedMark{Index: index, Mark: mark})
+}
+
+func (s *MarkServer) deleteMany(w http.ResponseWriter, r *http.Request) {
+	var request indexList
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if !s.checkIndexes(w, request.Indexes...) {
+		return
+	}
+	s.write(w, s.db.DeleteMany(request.Indexes))
+}
+
+func (s *MarkServer) move(w http.ResponseWriter, r *http.Request) {
+	var request moveRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if !s.checkIndexes(w, request.From, request.To) {
+		return
+	}
+	s.write(w, s.db.Move(request.From, request.To))
+}
+
+func (s *MarkServer) insert(w http.ResponseWriter, r *http.Request) {
+	var request insertRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if request.Mark.Path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("the mark has no path"))
+		return
+	}
+	marks, err := s.db.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// The mark may be inserted after the last one.
+	if request.Index < 0 || request.Index > len(marks) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no mark at index %v", request.Index))
+		return
+	}
+	s.write(w, s.db.Insert(request.Index, request.Mark))
+}
+
+func (s *MarkServer) clear(w http.ResponseWriter, r *http.Request) {
+	s.write(w, s.db.Clear())
+}
+
+// index returns the index of the request path, answering with an error
+// when it is not the index of a mark.
+func (s *MarkServer) index(w http.ResponseWriter, r *http.Request) (int, bool) {
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid index %q", r.PathValue("index")))
+		return 0, false
+	}
+	return index, s.checkIndexes(w, index)
+}
+
+// checkIndexes answers with an error unless every index is the index of a
+// mark, so that requests for missing marks are not mistaken by clients for
+// the server failing.
+func (s *MarkServer) checkIndexes(w http.ResponseWriter, indexes ...int) bool {
+	marks, err := s.db.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return false
+	}
+	for _, index := range indexes {
+		if index < 0 || index >= len(marks) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no mark at index %v", index))
+			return false
+		}
+	}
+	return true
+}
+
+// write answers a change to the db.
+func (s *MarkServer) write(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, value any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(value); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(remoteError{Error: err.Error()})
+}
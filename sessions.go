@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// SessionStore keeps named, ordered sets of marked directories that are
+// worked on together.
+type SessionStore struct {
+	File     string
+	filePerm os.FileMode
+}
+
+func NewSessionStore(file string) *SessionStore {
+	return &SessionStore{File: file, filePerm: 0660}
+}
+
+// List returns the paths of every session by name.
+func (s *SessionStore) List() (map[string][]string, error) {
+	sessions := map[string][]string{}
+	contents, err := os.ReadFile(s.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return sessions, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &sessions); err != nil {
+		return nil, fmt.Errorf("%v: %v", s.File, err)
+	}
+	return sessions, nil
+}
+
+func (s *SessionStore) Get(name string) ([]string, error) {
+	sessions, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	paths, ok := sessions[name]
+	if !ok {
+		return nil, fmt.Errorf("no session named %q", name)
+	}
+	return paths, nil
+}
+
+func (s *SessionStore) Save(name string, paths []string) error {
+	sessions, err := s.List()
+	if err != nil {
+		return err
+	}
+	sessions[name] = paths
+	return s.write(sessions)
+}
+
+func (s *SessionStore) Delete(name string) error {
+	sessions, err := s.List()
+	if err != nil {
+		return err
+	}
+	if _, ok := sessions[name]; !ok {
+		return fmt.Errorf("no session named %q", name)
+	}
+	delete(sessions, name)
+	return s.write(sessions)
+}
+
+func (s *SessionStore) write(sessions map[string][]string) error {
+	contents, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := markdb.OpenOwned(s.File, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, s.filePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(contents, '\n'))
+	return err
+}
+
+// OpenSession opens a window for each path of a session. Inside tmux a
+// window is opened for each path, inside zellij a tab. Otherwise, or when
+// print is set, the tmux commands creating a session with a window for each
+// path are written to w instead.
+func OpenSession(w io.Writer, name string, paths []string, print bool) error {
+	switch {
+	case !print && os.Getenv("TMUX") != "":
+		for _, path := range paths {
+			if err := runAttached("tmux", "new-window", "-c", path, "-n", filepath.Base(path)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case !print && os.Getenv("ZELLIJ") != "":
+		for _, path := range paths {
+			if err := OpenZellij(path, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	session := ShellQuote("mark-" + name)
+	for index, path := range paths {
+		window := ShellQuote(filepath.Base(path))
+		if index == 0 {
+			fmt.Fprintf(w, "tmux new-session -d -s %v -n %v -c %v\n", session, window, ShellQuote(path))
+		} else {
+			fmt.Fprintf(w, "tmux new-window -t %v -n %v -c %v\n", session, window, ShellQuote(path))
+		}
+	}
+	fmt.Fprintf(w, "tmux attach -t %v\n", session)
+	return nil
+}
+
+func runAttached(name string, args ...string) error {
+	command := exec.Command(name, args...)
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("%v %v: %v", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// SortedSessionNames returns the names of sessions in order.
+func SortedSessionNames(sessions map[string][]string) []string {
+	names := make([]string, 0, len(sessions))
+	for name := range sessions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
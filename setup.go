@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// XDGMarkFile returns the location of the db under $XDG_DATA_HOME.
+func XDGMarkFile() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(homeDir, ".local", "share")
+	}
+	return filepath.Join(dataHome, "mark", "marks"), nil
+}
+
+// RunSetup walks a new user through configuring mark: hooking it into
+// their shell and choosing where the db is stored, and writes the config
+// file.
+func RunSetup(prompter *Prompter) error {
+	shell := prompter.Ask("Which shell do you use", DetectShell())
+	if _, err := ShellInit(shell, ""); err != nil {
+		fmt.Printf("%v is not supported yet, run \"mark install\" for manual instructions.\n", shell)
+	} else if err := setupShell(prompter, shell); err != nil {
+		return err
+	}
+
+	configFile, err := GetConfigFile()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(configFile); err == nil {
+		fmt.Printf("%v already exists, leaving it unchanged.\n", configFile)
+		return nil
+	}
+	legacyFile, err := GetLocalMarkFile()
+	if err != nil {
+		return err
+	}
+	xdgFile, err := XDGMarkFile()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Where should marks be stored?\n  1. %v (XDG)\n  2. %v (legacy)\n", xdgFile, legacyFile)
+	dbFile := legacyFile
+	if prompter.Ask("Choice", "2") == "1" {
+		dbFile = xdgFile
+		if err := moveLegacyDB(prompter, legacyFile, xdgFile); err != nil {
+			return err
+		}
+	}
+
+	var config strings.Builder
+	config.WriteString("# mark configuration, see https://github.com/derickdiaz/mark\n")
+	fmt.Fprintf(&config, "db_file = %v\n", strconv.Quote(dbFile))
+	config.WriteString("\n# Directories that are never tracked or suggested.\n")
+	config.WriteString("exclude = [\"node_modules\", \".cache\", \"/tmp/**\"]\n")
+	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(configFile, []byte(config.String()), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %v\n", configFile)
+	return nil
+}
+
+func setupShell(prompter *Prompter, shell string) error {
+	rcFile, err := ShellRCFile(shell)
+	if err != nil {
+		return err
+	}
+	initLine := InitLine(shell)
+	contents, err := os.ReadFile(rcFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var lines []string
+	if strings.Contains(string(contents), initLine) {
+		fmt.Printf("%v already loads mark.\n", rcFile)
+	} else if prompter.Confirm(fmt.Sprintf("Add the move, back, up and down commands to %v?", rcFile), true) {
+		lines = append(lines, initLine)
+	}
+	hook, err := VisitHook(shell)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(contents), hook) && prompter.Confirm("Track visited directories for \"mark suggest\"?", false) {
+		lines = append(lines, hook)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	file, err := os.OpenFile(rcFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintf(file, "\n# mark\n%v\n", strings.Join(lines, "\n")); err != nil {
+		return err
+	}
+	fmt.Printf("Updated %v, restart your shell or run: source %v\n", rcFile, rcFile)
+	return nil
+}
+
+// moveLegacyDB offers to move an existing legacy db to its new location.
+func moveLegacyDB(prompter *Prompter, legacyFile string, dbFile string) error {
+	if _, err := os.Stat(legacyFile); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(dbFile); err == nil {
+		return nil
+	}
+	if !prompter.Confirm(fmt.Sprintf("Move your existing marks from %v to %v?", legacyFile, dbFile), true) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dbFile), 0755); err != nil {
+		return err
+	}
+	return os.Rename(legacyFile, dbFile)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// bashFunctions are the shell functions that change directory using mark.
+// They work in both bash and zsh. move evaluates the shell quoted output
+// of "mark get --quote" so paths with spaces, quotes or "$" survive;
+// "move -i" picks the mark with fzf instead and "move -u" with mark ui.
+const bashFunctions = `move() {
+	local DEST
+	if [[ $1 == -i ]]; then
+		shift
+		DEST=$(mark pick --quote "$@")
+	elif [[ $1 == -u ]]; then
+		DEST=$(mark ui --quote)
+	else
+		DEST=$(mark get --quote "$@")
+	fi
+	if [[ -n $DEST ]]; then
+		eval "cd -- $DEST"
+	fi
+}
+
+back() {
+	local DEST
+	DEST=$(mark back "$@")
+	if [[ -n $DEST ]]; then
+		cd -- "$DEST"
+	fi
+}
+
+up() {
+	local DEST
+	DEST=$(mark up "$@")
+	if [[ -n $DEST ]]; then
+		cd -- "$DEST"
+	fi
+}
+
+down() {
+	local DEST
+	DEST=$(mark down "$@")
+	if [[ -n $DEST ]]; then
+		cd -- "$DEST"
+	fi
+}
+`
+
+// zshWidget is a zle widget jumping to a mark picked with fzf, bound to a
+// key without having to type a command. %v is replaced by the key.
+const zshWidget = `
+mark-jump() {
+	local DEST
+	DEST=$(mark pick --quote </dev/tty)
+	if [[ -n $DEST ]]; then
+		eval "cd -- $DEST"
+	fi
+	zle reset-prompt
+}
+zle -N mark-jump
+bindkey %v mark-jump
+`
+
+// fishFunctions are the fish versions of the move, back, up and down
+// functions, which cannot use the bash syntax, along with the completions
+// of "mark completion fish". Command substitution in
+// fish only splits on newlines, so the paths need no quoting.
+const fishFunctions = `function move
+	set -l dest
+	if test "$argv[1]" = -i
+		set dest (mark pick $argv[2..-1])
+	else if test "$argv[1]" = -u
+		set dest (mark ui)
+	else
+		set dest (mark get $argv)
+	end
+	if test -n "$dest"
+		cd -- $dest
+	end
+end
+
+function back
+	set -l dest (mark back $argv)
+	if test -n "$dest"
+		cd -- $dest
+	end
+end
+
+function up
+	set -l dest (mark up $argv)
+	if test -n "$dest"
+		cd -- $dest
+	end
+end
+
+function down
+	set -l dest (mark down $argv)
+	if test -n "$dest"
+		cd -- $dest
+	end
+end
+
+mark completion fish | source
+`
+
+// powershellFunctions are the PowerShell versions of the move, back, up
+// and down functions. Move-Mark replaces the move alias of Move-Item.
+const powershellFunctions = `function Move-Mark {
+	if ($args[0] -eq '-i') {
+		$dest = mark pick @($args | Select-Object -Skip 1)
+	} elseif ($args[0] -eq '-u') {
+		$dest = mark ui
+	} else {
+		$dest = mark get @args
+	}
+	if ($LASTEXITCODE -eq 0 -and $dest) {
+		Set-Location -LiteralPath $dest
+	}
+}
+Set-Alias -Name move -Value Move-Mark -Option AllScope -Force
+
+function back {
+	$dest = mark back @args
+	if ($LASTEXITCODE -eq 0 -and $dest) {
+		Set-Location -LiteralPath $dest
+	}
+}
+
+function up {
+	$dest = mark up @args
+	if ($LASTEXITCODE -eq 0 -and $dest) {
+		Set-Location -LiteralPath $dest
+	}
+}
+
+function down {
+	$dest = mark down @args
+	if ($LASTEXITCODE -eq 0 -and $dest) {
+		Set-Location -LiteralPath $dest
+	}
+}
+`
+
+// defaultZshJumpKey is the key the mark-jump widget is bound to unless the
+// zsh_jump_key setting says otherwise: ctrl-g, for "go".
+const defaultZshJumpKey = "^G"
+
+// ShellQuote quotes s so that a POSIX shell reads it back as a single
+// word. Strings made only of safe characters are returned unchanged.
+func ShellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-+=.,/:@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ShellInit returns the script that `eval "$(mark init <shell>)"` runs. In
+// zsh the mark-jump widget is bound to jumpKey, or the default key when
+// it is empty.
+func ShellInit(shell string, jumpKey string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashFunctions, nil
+	case "zsh":
+		if jumpKey == "" {
+			jumpKey = defaultZshJumpKey
+		}
+		return bashFunctions + fmt.Sprintf(zshWidget, ShellQuote(jumpKey)), nil
+	case "fish":
+		return fishFunctions, nil
+	case "powershell", "pwsh":
+		return powershellFunctions, nil
+	}
+	return "", fmt.Errorf("unsupported shell %q", shell)
+}
+
+// VisitHook returns the line that records every visited directory for
+// "mark suggest".
+func VisitHook(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return `PROMPT_COMMAND="mark visit${PROMPT_COMMAND:+; $PROMPT_COMMAND}"`, nil
+	case "zsh":
+		return `precmd_functions+=(mark_visit); mark_visit() { mark visit }`, nil
+	case "fish":
+		return `function __mark_visit --on-event fish_prompt; mark visit; end`, nil
+	case "powershell":
+		return `$__markPrompt = $function:prompt; function prompt { mark visit; & $__markPrompt }`, nil
+	}
+	return "", fmt.Errorf("unsupported shell %q", shell)
+}
+
+// InitLine returns the line a startup file uses to load mark into shell.
+func InitLine(shell string) string {
+	switch shell {
+	case "fish":
+		return "mark init fish | source"
+	case "powershell":
+		return "mark init powershell | Out-String | Invoke-Expression"
+	}
+	return fmt.Sprintf(`eval "$(mark init %v)"`, shell)
+}
+
+// DetectShell returns the name of the user's login shell from $SHELL, or
+// powershell on Windows where it is not set.
+func DetectShell() string {
+	shell := os.Getenv("SHELL")
+	if shell == "" && runtime.GOOS == "windows" {
+		return "powershell"
+	}
+	shell = strings.TrimSuffix(filepath.Base(shell), ".exe")
+	if shell == "pwsh" {
+		return "powershell"
+	}
+	return shell
+}
+
+// ShellRCFile returns the startup file of shell in the home directory.
+func ShellRCFile(shell string) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch shell {
+	case "bash":
+		return filepath.Join(homeDir, ".bashrc"), nil
+	case "zsh":
+		return filepath.Join(homeDir, ".zshrc"), nil
+	case "fish":
+		configHome := os.Getenv("XDG_CONFIG_HOME")
+		if configHome == "" {
+			configHome = filepath.Join(homeDir, ".config")
+		}
+		return filepath.Join(configHome, "fish", "config.fish"), nil
+	case "powershell":
+		if runtime.GOOS == "windows" {
+			return filepath.Join(homeDir, "Documents", "PowerShell", "Microsoft.PowerShell_profile.ps1"), nil
+		}
+		return filepath.Join(homeDir, ".config", "powershell", "Microsoft.PowerShell_profile.ps1"), nil
+	}
+	return "", fmt.Errorf("unsupported shell %q", shell)
+}
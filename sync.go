@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// GitSync keeps a db that lives in a git repository in step with the
+// remote of the repository: changes to the db are committed, the remote's
+// changes are pulled and the result pushed. When both sides changed the
+// db, the conflict is resolved by merging the marks rather than the lines.
+type GitSync struct {
+	db *markdb.LocalMarkDB
+	// Log receives the progress of the sync.
+	Log io.Writer
+}
+
+func NewGitSync(db *markdb.LocalMarkDB, log io.Writer) *GitSync {
+	return &GitSync{db: db, Log: log}
+}
+
+// Sync commits, pulls and pushes the db. The db is locked throughout, so
+// that other mark processes wait rather than write in the middle of a
+// merge.
+func (g *GitSync) Sync(message string) error {
+	return g.db.Locked(func(write func(marks []Mark) error) error {
+		return g.sync(message, write)
+	})
+}
+
+// sync is Sync once the db is locked; write replaces the marks.
+func (g *GitSync) sync(message string, write func(marks []Mark) error) error {
+	dir := filepath.Dir(g.db.DBFile)
+	top, err := g.git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return fmt.Errorf("%v is not in a git repository, create one with: git -C %v init", g.db.DBFile, dir)
+	}
+	top = strings.TrimSpace(top)
+	dbFile, err := filepath.EvalSymlinks(g.db.DBFile)
+	if err != nil {
+		return err
+	}
+	file, err := filepath.Rel(top, dbFile)
+	if err != nil {
+		return err
+	}
+	file = filepath.ToSlash(file)
+
+	if _, err := g.git(top, "add", "--", file); err != nil {
+		return err
+	}
+	if _, err := g.git(top, "diff", "--cached", "--quiet", "--", file); err != nil {
+		if _, err := g.git(top, "commit", "--quiet", "-m", message, "--", file); err != nil {
+			return err
+		}
+		fmt.Fprintf(g.Log, "committed the changes to %v\n", file)
+	}
+	remotes, err := g.git(top, "remote")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(remotes) == "" {
+		fmt.Fprintln(g.Log, "the repository has no remote, nothing to pull or push")
+		return nil
+	}
+	if _, err := g.git(top, "rev-parse", "--abbrev-ref", "@{upstream}"); err != nil {
+		// Nothing to pull from yet: the first sync publishes the db.
+		remote := strings.Fields(remotes)[0]
+		if _, err := g.git(top, "push", "--quiet", "--set-upstream", remote, "HEAD"); err != nil {
+			return err
+		}
+		fmt.Fprintf(g.Log, "pushed to %v\n", remote)
+		return nil
+	}
+	if _, err := g.git(top, "pull", "--quiet", "--no-rebase", "--no-edit"); err != nil {
+		conflicts, _ := g.git(top, "diff", "--name-only", "--diff-filter=U")
+		if !slices.Contains(strings.Fields(conflicts), file) {
+			return err
+		}
+		if err := g.resolve(top, file, write); err != nil {
+			return err
+		}
+		fmt.Fprintf(g.Log, "merged the marks changed on both sides\n")
+	}
+	if _, err := g.git(top, "push", "--quiet"); err != nil {
+		return err
+	}
+	fmt.Fprintln(g.Log, "synced")
+	return nil
+}
+
+// resolve resolves a conflicted pull by merging our and their versions of
+// the db with MergeSynced, writing it with write, and committing the
+// result.
+func (g *GitSync) resolve(top string, file string, write func(marks []Mark) error) error {
+	var versions [][]Mark
+	for _, stage := range []string{":2:", ":3:"} {
+		contents, err := g.git(top, "show", stage+file)
+		if err != nil {
+			return err
+		}
+		marks, err := markdb.ReadDB(strings.NewReader(contents))
+		if err != nil {
+			return fmt.Errorf("%v%v: %v", stage, file, err)
+		}
+		versions = append(versions, marks)
+	}
+	if err := write(MergeSynced(versions[0], versions[1])); err != nil {
+		return err
+	}
+	if _, err := g.git(top, "add", "--", file); err != nil {
+		return err
+	}
+	conflicts, err := g.git(top, "diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(conflicts) != "" {
+		return fmt.Errorf("the pull also conflicts in files other than the marks, resolve them in %v:\n%v", top, conflicts)
+	}
+	_, err = g.git(top, "commit", "--quiet", "--no-edit")
+	return err
+}
+
+// git runs a git command in dir, returning its output, or an error with
+// what git printed.
+func (g *GitSync) git(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	command.Stdout, command.Stderr = &stdout, &stderr
+	command.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if err := command.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if message := strings.TrimSpace(stderr.String()); message != "" && errors.As(err, &exitErr) {
+			return stdout.String(), fmt.Errorf("git %v: %v", args[0], message)
+		}
+		return stdout.String(), fmt.Errorf("git %v: %v", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// MergeSynced merges two versions of the marks changed independently. The
+// metadata of marks on both sides is merged as by import, and a mark
+// deleted on one side stays deleted unless it was marked again or used
+// since on the other. Ours keeps its order, their new marks follow and the deleted
+// marks go last.
+func MergeSynced(ours []Mark, theirs []Mark) []Mark {
+	merged, _, _ := MergeMarks(ours, theirs)
+	for index, mark := range merged[:len(ours)] {
+		theirIndex := slices.IndexFunc(theirs, func(other Mark) bool { return other.Path == mark.Path })
+		if theirIndex < 0 {
+			continue
+		}
+		other := theirs[theirIndex]
+		active := mark.LastActive()
+		if other.LastActive().After(active) {
+			active = other.LastActive()
+		}
+		if other.LastUsed.After(mark.LastUsed) {
+			mark.LastUsed = other.LastUsed
+		}
+		if other.Deleted.After(mark.Deleted) {
+			mark.Deleted = other.Deleted
+		}
+		// Marking the path again restores it.
+		if mark.IsDeleted() && active.After(mark.Deleted) {
+			mark.Deleted = time.Time{}
+		}
+		merged[index] = mark
+	}
+	live := slices.DeleteFunc(slices.Clone(merged), Mark.IsDeleted)
+	return append(live, slices.DeleteFunc(merged, func(mark Mark) bool { return !mark.IsDeleted() })...)
+}
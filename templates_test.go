@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestCli returns a MarkCli on a db in a temporary directory.
+func newTestCli(t *testing.T) *MarkCli {
+	t.Helper()
+	config := NewDefaultConfig()
+	config.DBFile = filepath.Join(t.TempDir(), "marks")
+	db, err := OpenConfiguredDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mark, err := NewMarkCli(db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mark
+}
+
+func TestAddLiteralBraceDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "{proj}")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mark := newTestCli(t)
+	withStdout(t)
+	mark.Add([]string{dir})
+
+	marks, err := mark.db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marks) != 1 || marks[0].Path != dir || marks[0].Template {
+		t.Fatalf("got %+v, want an untemplated mark of %v", marks, dir)
+	}
+	path, err := mark.expandPath(marks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != dir {
+		t.Fatalf("got %v, want %v", path, dir)
+	}
+}
+
+func TestAddTemplate(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "build"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("mark_test_root", root)
+	mark := newTestCli(t)
+	withStdout(t)
+	mark.Add([]string{"{mark_test_root}/build"})
+
+	marks, err := mark.db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marks) != 1 || marks[0].Path != "{mark_test_root}/build" || !marks[0].Template {
+		t.Fatalf("got %+v, want a templated mark", marks)
+	}
+	path, err := mark.expandPath(marks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "build"); path != want {
+		t.Fatalf("got %v, want %v", path, want)
+	}
+}
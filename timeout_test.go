@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+func TestTimeoutCancelsRequests(t *testing.T) {
+	cancelled := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- true
+		case <-time.After(5 * time.Second):
+			cancelled <- false
+		}
+	}))
+	defer server.Close()
+	db, err := NewRemoteMarkDB(server.URL, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := markdb.NewTimeoutMarkDB(50*time.Millisecond, db).List(); err == nil {
+		t.Fatal("list did not time out")
+	}
+	if !<-cancelled {
+		t.Fatal("the request was left running")
+	}
+}
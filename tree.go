@@ -0,0 +1,101 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// TreeNode is a directory in the tree list --tree prints: a mark, or an
+// ancestor shared by marks.
+type TreeNode struct {
+	// Name is the part of the path below the parent node, several
+	// directories when those in between have nothing else in them.
+	Name string
+	// Index is the index of the mark of the directory, or -1.
+	Index    int
+	Children []*TreeNode
+}
+
+// BuildTree arranges the marks at indexes, by their paths as displayed,
+// under the directories they share, returning the top level of the tree.
+func BuildTree(indexes []int, paths []string) []*TreeNode {
+	root := &TreeNode{Index: -1}
+	separator := string(filepath.Separator)
+	for position, path := range paths {
+		node := root
+		for _, part := range strings.Split(path, separator) {
+			if part == "" {
+				// The root directory, or a doubled separator.
+				if node != root {
+					continue
+				}
+				part = separator
+			}
+			child := slices.IndexFunc(node.Children, func(child *TreeNode) bool { return child.Name == part })
+			if child < 0 {
+				node.Children = append(node.Children, &TreeNode{Name: part, Index: -1})
+				child = len(node.Children) - 1
+			}
+			node = node.Children[child]
+		}
+		node.Index = indexes[position]
+	}
+	compactTree(root)
+	return root.Children
+}
+
+// compactTree merges the directories that are not marked and hold a single
+// directory into it, and sorts the children of each node by name.
+func compactTree(node *TreeNode) {
+	for _, child := range node.Children {
+		compactTree(child)
+		for child.Index < 0 && len(child.Children) == 1 {
+			only := child.Children[0]
+			child.Name = joinTreeNames(child.Name, only.Name)
+			child.Index, child.Children = only.Index, only.Children
+		}
+	}
+	slices.SortFunc(node.Children, func(a, b *TreeNode) int {
+		return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+}
+
+func joinTreeNames(parent string, child string) string {
+	if strings.HasSuffix(parent, string(filepath.Separator)) {
+		return parent + child
+	}
+	return parent + string(filepath.Separator) + child
+}
+
+// WriteTree prints the tree with the lines tree(1) draws, the marks
+// formatted by format given their index and name in the tree and the other
+// directories by name:
+//
+//	~/src
+//	├── [3] web-api (api) #work
+//	└── [5] cli
+func WriteTree(w io.Writer, nodes []*TreeNode, format func(index int, name string) string) {
+	var write func(nodes []*TreeNode, indent string, top bool)
+	write = func(nodes []*TreeNode, indent string, top bool) {
+		for position, node := range nodes {
+			branch, next := "├── ", "│   "
+			if position == len(nodes)-1 {
+				branch, next = "└── ", "    "
+			}
+			if top {
+				branch, next = "", ""
+			}
+			line := node.Name
+			if node.Index >= 0 {
+				line = format(node.Index, node.Name)
+			}
+			fmt.Fprintf(w, "%v%v%v\n", indent, branch, line)
+			write(node.Children, indent+next, false)
+		}
+	}
+	write(nodes, "", true)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// browser is the state of the mark ui terminal browser. It draws on the
+// terminal directly so that the path of the mark jumped to is the only
+// thing written to stdout.
+type browser struct {
+	m       *MarkCli
+	tty     *os.File
+	keys    *bufio.Reader
+	marks   []Mark
+	visible []int
+	cursor  int
+	offset  int
+	message string
+}
+
+const browserHelp = "j/k move  J/K reorder  enter jump  d delete  r rename  q quit"
+
+// Browse runs the browser until the user quits, returning the index of
+// the mark to jump to, or -1.
+func Browse(m *MarkCli) (int, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return -1, errors.New("mark ui needs a terminal")
+	}
+	defer tty.Close()
+	state, err := stty(tty, "-g")
+	if err != nil {
+		return -1, err
+	}
+	if _, err := stty(tty, "raw", "-echo"); err != nil {
+		return -1, err
+	}
+	fmt.Fprint(tty, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(tty, "\x1b[?25h\x1b[?1049l")
+		stty(tty, strings.TrimSpace(state))
+	}()
+	b := &browser{m: m, tty: tty, keys: bufio.NewReader(tty)}
+	if err := b.reload(); err != nil {
+		return -1, err
+	}
+	for {
+		b.draw()
+		key, err := b.readKey()
+		if err != nil {
+			return -1, err
+		}
+		b.message = ""
+		switch key {
+		case "q", "esc", "ctrl-c":
+			return -1, nil
+		case "j", "down":
+			b.cursor = min(b.cursor+1, len(b.visible)-1)
+		case "k", "up":
+			b.cursor = max(b.cursor-1, 0)
+		case "g":
+			b.cursor = 0
+		case "G":
+			b.cursor = len(b.visible) - 1
+		case "enter":
+			if len(b.visible) > 0 {
+				return b.visible[b.cursor], nil
+			}
+		case "J", "K":
+			err = b.reorder(key == "J")
+		case "d":
+			err = b.delete()
+		case "r":
+			err = b.rename()
+		}
+		if err != nil {
+			b.message = err.Error()
+		}
+	}
+}
+
+func (b *browser) reload() error {
+	marks, err := b.m.db.List()
+	if err != nil {
+		return err
+	}
+	b.marks, b.visible = marks, nil
+	for index, mark := range marks {
+		if !mark.Archived && !mark.IsDeleted() {
+			b.visible = append(b.visible, index)
+		}
+	}
+	b.cursor = max(0, min(b.cursor, len(b.visible)-1))
+	return nil
+}
+
+func (b *browser) draw() {
+	rows, columns := terminalSize(b.tty)
+	height := max(1, rows-3)
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	} else if b.cursor >= b.offset+height {
+		b.offset = b.cursor - height + 1
+	}
+	var screen strings.Builder
+	screen.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&screen, "\x1b[1m%v\x1b[0m\r\n\r\n", truncate("mark ui  "+browserHelp, columns))
+	for row := b.offset; row < min(len(b.visible), b.offset+height); row++ {
+		index := b.visible[row]
+		mark := b.marks[index]
+		mark.Path = ShortenPath(mark.Path, b.m.config.Roots)
+		line := truncate(FormatMark(index, mark), columns-2)
+		if row == b.cursor {
+			fmt.Fprintf(&screen, "\x1b[7m> %v\x1b[0m\r\n", line)
+		} else {
+			fmt.Fprintf(&screen, "  %v\r\n", line)
+		}
+	}
+	if len(b.visible) == 0 {
+		screen.WriteString("  no marks\r\n")
+	}
+	if b.message != "" {
+		fmt.Fprintf(&screen, "\x1b[%v;1H%v", rows, truncate(b.message, columns))
+	}
+	fmt.Fprint(b.tty, screen.String())
+}
+
+func (b *browser) readKey() (string, error) {
+	key, err := b.keys.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch key {
+	case '\r', '\n':
+		return "enter", nil
+	case 3:
+		return "ctrl-c", nil
+	case 0x1b:
+		// Arrow keys arrive as a single escape sequence.
+		if b.keys.Buffered() < 2 {
+			return "esc", nil
+		}
+		sequence := make([]byte, 2)
+		b.keys.Read(sequence)
+		switch string(sequence) {
+		case "[A":
+			return "up", nil
+		case "[B":
+			return "down", nil
+		}
+		return "", nil
+	}
+	return string(key), nil
+}
+
+func (b *browser) reorder(down bool) error {
+	if len(b.visible) == 0 {
+		return nil
+	}
+	target := b.cursor - 1
+	if down {
+		target = b.cursor + 1
+	}
+	if target < 0 || target >= len(b.visible) {
+		return nil
+	}
+	if err := b.m.db.Move(b.visible[b.cursor], b.visible[target]); err != nil {
+		return err
+	}
+	b.cursor = target
+	return b.reload()
+}
+
+func (b *browser) delete() error {
+	if len(b.visible) == 0 {
+		return nil
+	}
+	index := b.visible[b.cursor]
+	b.message = fmt.Sprintf("delete %v? y/n", FormatMark(index, b.marks[index]))
+	b.draw()
+	key, err := b.readKey()
+	if err != nil || key != "y" {
+		b.message = ""
+		return err
+	}
+	if err := b.m.trash(b.marks, []int{index}); err != nil {
+		return err
+	}
+	return b.reload()
+}
+
+func (b *browser) rename() error {
+	if len(b.visible) == 0 {
+		return nil
+	}
+	index := b.visible[b.cursor]
+	b.message = "name (empty to remove): "
+	b.draw()
+	name, err := b.readLine()
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		if err := ValidateName(name); err != nil {
+			return err
+		}
+		if err := CheckNameFree(b.marks, name, index); err != nil {
+			return err
+		}
+	}
+	mark := b.marks[index]
+	mark.Name = name
+	if err := b.m.db.Update(index, mark); err != nil {
+		return err
+	}
+	return b.reload()
+}
+
+// readLine reads a line of text, echoing it, while the terminal is raw.
+func (b *browser) readLine() (string, error) {
+	fmt.Fprint(b.tty, "\x1b[?25h")
+	defer fmt.Fprint(b.tty, "\x1b[?25l")
+	var line []byte
+	for {
+		key, err := b.keys.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case key == '\r' || key == '\n':
+			return strings.TrimSpace(string(line)), nil
+		case key == 3 || key == 0x1b:
+			return "", errors.New("rename cancelled")
+		case key == 127 || key == 8:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(b.tty, "\b \b")
+			}
+		case key >= ' ':
+			line = append(line, key)
+			b.tty.Write([]byte{key})
+		}
+	}
+}
+
+// stty runs stty on the terminal.
+func stty(tty *os.File, args ...string) (string, error) {
+	command := exec.Command("stty", args...)
+	command.Stdin = tty
+	output, err := command.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %v: %v", strings.Join(args, " "), err)
+	}
+	return string(output), nil
+}
+
+// terminalSize returns the number of rows and columns of the terminal,
+// assuming 24x80 when it cannot be found.
+func terminalSize(tty *os.File) (int, int) {
+	output, err := stty(tty, "size")
+	if err == nil {
+		if rows, columns, ok := strings.Cut(strings.TrimSpace(output), " "); ok {
+			r, errRows := strconv.Atoi(rows)
+			c, errColumns := strconv.Atoi(columns)
+			if errRows == nil && errColumns == nil && r > 0 && c > 0 {
+				return r, c
+			}
+		}
+	}
+	return 24, 80
+}
+
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if width <= 0 || len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
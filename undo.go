@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// undoDepth is how many changes mark undo can revert.
+const undoDepth = 10
+
+// UndoMarkDB wraps a MarkDB, saving the marks to a journal before the
+// first change made through it, so that mark undo can revert the last
+// commands that changed them. Updates that only record a jump, by setting
+// LastUsed, are not worth undoing and are not saved. It is safe for
+// concurrent use.
+type UndoMarkDB struct {
+	MarkDB
+	journal *BackupStore
+	state   *undoState
+}
+
+// undoState is the state of an UndoMarkDB, shared with its copies bound to
+// a context.
+type undoState struct {
+	// mu guards saved, and is held while the marks are saved so that
+	// concurrent changes save them once.
+	mu    sync.Mutex
+	saved bool
+}
+
+func NewUndoMarkDB(db MarkDB, dir string) *UndoMarkDB {
+	return &UndoMarkDB{MarkDB: db, journal: NewBackupStore(dir, undoDepth), state: &undoState{}}
+}
+
+func (u *UndoMarkDB) WithContext(ctx context.Context) MarkDB {
+	return &UndoMarkDB{MarkDB: markdb.BindContext(ctx, u.MarkDB), journal: u.journal, state: u.state}
+}
+
+func (u *UndoMarkDB) Add(mark Mark) error {
+	if err := u.save(); err != nil {
+		return err
+	}
+	return u.MarkDB.Add(mark)
+}
+
+func (u *UndoMarkDB) Insert(index int, mark Mark) error {
+	if err := u.save(); err != nil {
+		return err
+	}
+	return u.MarkDB.Insert(index, mark)
+}
+
+func (u *UndoMarkDB) Clear() error {
+	if err := u.save(); err != nil {
+		return err
+	}
+	return u.MarkDB.Clear()
+}
+
+func (u *UndoMarkDB) Delete(index int) error {
+	return u.DeleteMany([]int{index})
+}
+
+func (u *UndoMarkDB) DeleteMany(indexes []int) error {
+	if err := u.save(); err != nil {
+		return err
+	}
+	return u.MarkDB.DeleteMany(indexes)
+}
+
+func (u *UndoMarkDB) Update(index int, mark Mark) error {
+	old, err := u.MarkDB.Get(index)
+	if err != nil {
+		return err
+	}
+	old.LastUsed = mark.LastUsed
+	if !marksEqual(old, mark) {
+		if err := u.save(); err != nil {
+			return err
+		}
+	}
+	return u.MarkDB.Update(index, mark)
+}
+
+func (u *UndoMarkDB) Move(from int, to int) error {
+	if err := u.save(); err != nil {
+		return err
+	}
+	return u.MarkDB.Move(from, to)
+}
+
+func (u *UndoMarkDB) Replace(marks []Mark) error {
+	if err := u.save(); err != nil {
+		return err
+	}
+	return u.MarkDB.Replace(marks)
+}
+
+// save saves the marks to the journal unless they were saved already.
+func (u *UndoMarkDB) save() error {
+	u.state.mu.Lock()
+	defer u.state.mu.Unlock()
+	if u.state.saved {
+		return nil
+	}
+	marks, err := u.MarkDB.List()
+	if errors.Is(err, ErrUnavailable) {
+		// The change can only be queued, and is not undone.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := u.journal.Save(marks, true); err != nil {
+		return err
+	}
+	u.state.saved = true
+	return nil
+}
+
+// Undo brings the marks back to how they were before the last change
+// saved in the journal, returning when that change was made, and removes
+// it from the journal.
+func (u *UndoMarkDB) Undo() (time.Time, error) {
+	u.state.mu.Lock()
+	defer u.state.mu.Unlock()
+	paths, err := u.journal.List()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(paths) == 0 {
+		return time.Time{}, errors.New("there is nothing to undo")
+	}
+	path := paths[len(paths)-1]
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	marks, err := markdb.ReadDBFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := u.MarkDB.Replace(marks); err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), os.Remove(path)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// UsageEntry records a jump to a mark and how many marks there were at
+// the time.
+type UsageEntry struct {
+	Time  time.Time `json:"time"`
+	Path  string    `json:"path"`
+	Marks int       `json:"marks"`
+}
+
+// UsageJournal keeps the jumps made with get, one JSON encoded UsageEntry
+// per line, when the usage_journal setting opts in. It is only ever read
+// by mark report.
+type UsageJournal struct {
+	File     string
+	filePerm os.FileMode
+}
+
+func NewUsageJournal(file string) *UsageJournal {
+	return &UsageJournal{File: file, filePerm: 0600}
+}
+
+func (j *UsageJournal) Record(entry UsageEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := markdb.OpenOwned(j.File, os.O_APPEND|os.O_WRONLY|os.O_CREATE, j.filePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func (j *UsageJournal) List() ([]UsageEntry, error) {
+	file, err := os.Open(j.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []UsageEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry UsageEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// WriteUsageReport summarises the journal: jumps per day over the last
+// week, the most used marks, the marks never jumped to and the average
+// number of marks per week.
+func WriteUsageReport(w io.Writer, entries []UsageEntry, marks []Mark, now time.Time, top int, roots map[string]string) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "no jumps recorded yet")
+		return
+	}
+	since := entries[0].Time
+	days := max(1, int(now.Sub(since).Hours()/24+0.5))
+	fmt.Fprintf(w, "%v jumps since %v, %.1f per day\n", len(entries), since.Format(time.DateOnly), float64(len(entries))/float64(days))
+
+	fmt.Fprintln(w, "\nLast 7 days:")
+	perDay := map[string]int{}
+	for _, entry := range entries {
+		perDay[entry.Time.Local().Format(time.DateOnly)]++
+	}
+	for day := 6; day >= 0; day-- {
+		date := now.AddDate(0, 0, -day).Format(time.DateOnly)
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("  %v %4v %v", date, perDay[date], bar(perDay[date])), " "))
+	}
+
+	paths, counts := RankPaths(entries)
+	fmt.Fprintln(w, "\nTop marks:")
+	for _, path := range paths[:min(top, len(paths))] {
+		fmt.Fprintf(w, "  %4v %v\n", counts[path], ShortenPath(path, roots))
+	}
+
+	var unused []int
+	for index, mark := range marks {
+		if counts[mark.Path] == 0 && !mark.Archived && !mark.IsDeleted() && (mark.Created.IsZero() || mark.Created.Before(since)) {
+			unused = append(unused, index)
+		}
+	}
+	if len(unused) > 0 {
+		fmt.Fprintf(w, "\nNever jumped to since %v (candidates for delete or gc):\n", since.Format(time.DateOnly))
+		for _, index := range unused {
+			fmt.Fprintf(w, "  [%v] %v\n", index, ShortenPath(marks[index].Path, roots))
+		}
+	}
+
+	fmt.Fprintln(w, "\nAverage number of marks per week:")
+	type week struct{ total, jumps int }
+	weeks := map[string]*week{}
+	var order []string
+	for _, entry := range entries {
+		year, number := entry.Time.Local().ISOWeek()
+		key := fmt.Sprintf("%v-W%02d", year, number)
+		if weeks[key] == nil {
+			weeks[key] = &week{}
+			order = append(order, key)
+		}
+		weeks[key].total += entry.Marks
+		weeks[key].jumps++
+	}
+	for _, key := range order {
+		fmt.Fprintf(w, "  %v %.1f\n", key, float64(weeks[key].total)/float64(weeks[key].jumps))
+	}
+}
+
+// RankPaths counts the jumps to each path, returning the paths from the
+// most to the least jumped to.
+func RankPaths(entries []UsageEntry) ([]string, map[string]int) {
+	counts := map[string]int{}
+	for _, entry := range entries {
+		counts[entry.Path]++
+	}
+	paths := make([]string, 0, len(counts))
+	for path := range counts {
+		paths = append(paths, path)
+	}
+	slices.SortStableFunc(paths, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return cmp.Compare(a, b)
+	})
+	return paths, counts
+}
+
+// ParseWindow parses a window of time to look back over: week, month, all
+// or an age such as "14d". All is zero.
+func ParseWindow(window string) (time.Duration, error) {
+	switch window {
+	case "week":
+		return 7 * 24 * time.Hour, nil
+	case "month":
+		return 30 * 24 * time.Hour, nil
+	case "all":
+		return 0, nil
+	}
+	return ParseAge(window)
+}
+
+func bar(count int) string {
+	return string(slices.Repeat([]byte("#"), min(count, 50)))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/derickdiaz/mark/markdb"
+)
+
+// VisitLog counts how often directories are visited so that frequently
+// used, unmarked directories can be suggested.
+type VisitLog struct {
+	File     string
+	filePerm os.FileMode
+}
+
+type Visit struct {
+	Path  string
+	Count int
+}
+
+func NewVisitLog(file string) *VisitLog {
+	return &VisitLog{File: file, filePerm: 0660}
+}
+
+// Record counts a visit to path. The log is locked while it is read and
+// rewritten, and replaced atomically, so that shells recording visits at
+// once neither lose counts nor leave it half written.
+func (v *VisitLog) Record(path string) error {
+	unlock, err := markdb.LockFile(v.File+".lock", true)
+	if err != nil {
+		return fmt.Errorf("locking %v: %v", v.File, err)
+	}
+	defer unlock()
+	visits, err := v.list()
+	if err != nil {
+		return err
+	}
+	found := false
+	for index := range visits {
+		if visits[index].Path == path {
+			visits[index].Count++
+			found = true
+			break
+		}
+	}
+	if !found {
+		visits = append(visits, Visit{Path: path, Count: 1})
+	}
+	return v.write(visits)
+}
+
+// List returns the recorded visits, most visited first.
+func (v *VisitLog) List() ([]Visit, error) {
+	unlock, err := markdb.LockFile(v.File+".lock", false)
+	if err != nil {
+		return nil, fmt.Errorf("locking %v: %v", v.File, err)
+	}
+	defer unlock()
+	return v.list()
+}
+
+func (v *VisitLog) list() ([]Visit, error) {
+	file, err := markdb.OpenOwned(v.File, os.O_RDONLY|os.O_CREATE, v.filePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	var visits []Visit
+	for scanner.Scan() {
+		countField, path, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		count, err := strconv.Atoi(countField)
+		if err != nil {
+			continue
+		}
+		visits = append(visits, Visit{Path: path, Count: count})
+	}
+	sort.SliceStable(visits, func(i, j int) bool {
+		return visits[i].Count > visits[j].Count
+	})
+	return visits, scanner.Err()
+}
+
+func (v *VisitLog) write(visits []Visit) error {
+	return markdb.WriteFileAtomic(v.File, v.filePerm, func(w io.Writer) error {
+		for _, visit := range visits {
+			if _, err := fmt.Fprintf(w, "%v\t%v\n", visit.Count, filepath.Clean(visit.Path)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
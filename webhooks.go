@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Webhook posts events to a URL, either as the JSON encoded Event or, for
+// Slack incoming webhooks, as a message.
+type Webhook struct {
+	URL   string
+	Slack bool
+
+	mu sync.Mutex
+	// last is closed once the last event sent has been posted.
+	last chan struct{}
+}
+
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// webhookDeadline bounds how long mark waits, before it exits, for all of
+// the events still being posted.
+const webhookDeadline = 5 * time.Second
+
+// pendingWebhooks counts the events being posted.
+var pendingWebhooks sync.WaitGroup
+
+// Send posts the event in the background, after the events sent to the
+// webhook before it, so that the change is not held up by the webhook.
+// Webhooks are best effort: failures are reported on stderr but never
+// fail the change that caused them.
+func (w *Webhook) Send(event Event) {
+	w.mu.Lock()
+	previous, done := w.last, make(chan struct{})
+	w.last = done
+	w.mu.Unlock()
+	pendingWebhooks.Add(1)
+	go func() {
+		defer pendingWebhooks.Done()
+		defer close(done)
+		if previous != nil {
+			<-previous
+		}
+		w.post(event)
+	}()
+}
+
+// WaitWebhooks waits for the events being posted, for webhookDeadline at
+// most however many there are.
+func WaitWebhooks() {
+	done := make(chan struct{})
+	go func() {
+		pendingWebhooks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(webhookDeadline):
+		fmt.Fprintf(os.Stderr, "webhook: gave up on the events not posted within %v\n", webhookDeadline)
+	}
+}
+
+func (w *Webhook) post(event Event) {
+	var payload any = event
+	if w.Slack {
+		text := fmt.Sprintf("mark %v: %v", event.Type, event.Mark.Path)
+		if event.Mark.Name != "" {
+			text += fmt.Sprintf(" (%v)", event.Mark.Name)
+		}
+		payload = map[string]string{"text": text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "webhook %v: %v\n", w.URL, err)
+		return
+	}
+	response, err := webhookClient.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "webhook: %v\n", err)
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		fmt.Fprintf(os.Stderr, "webhook %v: %v\n", w.URL, response.Status)
+	}
+}
+
+// ConfiguredWebhooks returns the webhooks of the webhooks and
+// slack_webhooks settings.
+func ConfiguredWebhooks(config *Config) []*Webhook {
+	var webhooks []*Webhook
+	for _, url := range config.Webhooks {
+		webhooks = append(webhooks, &Webhook{URL: url})
+	}
+	for _, url := range config.SlackWebhooks {
+		webhooks = append(webhooks, &Webhook{URL: url, Slack: true})
+	}
+	return webhooks
+}
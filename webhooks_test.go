@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookSendsInOrderInTheBackground(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		posted = append(posted, event.Mark.Path)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	webhook := &Webhook{URL: server.URL}
+	start := time.Now()
+	for _, path := range []string{"/a", "/b", "/c"} {
+		webhook.Send(Event{Type: EventAdded, Mark: Mark{Path: path}})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("sending waited %v on the webhook", elapsed)
+	}
+	close(release)
+	WaitWebhooks()
+	if want := []string{"/a", "/b", "/c"}; !slices.Equal(posted, want) {
+		t.Fatalf("posted %v, want %v", posted, want)
+	}
+}